@@ -0,0 +1,61 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// GetByID loads the session with the given ID without a request, e.g. for a
+// websocket handshake or a background job that only carries the session ID.
+// The session gets the name it was saved under. It returns
+// ErrSessionNotFound when there is no live session with that ID.
+func (mstore *MongoDBStore) GetByID(ctx context.Context, id string) (*sessions.Session, error) {
+	if _, err := mstore.docID(id); err != nil {
+		return nil, fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	loaded := sessions.NewSession(mstore, "")
+	options := mstore.options
+	loaded.Options = &options
+	loaded.ID = id
+	sessDoc, err := mstore.loadDoc(ctx, loaded)
+	if err != nil {
+		return nil, err
+	}
+	if sessDoc == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	session := sessions.NewSession(mstore, sessDoc.Name)
+	options.MaxAge = mstore.maxAge(sessDoc.Name)
+	session.Options = &options
+	session.ID, session.IsNew = id, false
+	session.Values = loaded.Values
+
+	return session, nil
+}
+
+// SaveByID saves session like Save, but without a request or a cookie, e.g.
+// for a session loaded with GetByID. It returns ErrSessionNotFound when the
+// session was deleted in the meantime. A CollectionSelector is passed a
+// request that only carries ctx.
+func (mstore *MongoDBStore) SaveByID(ctx context.Context, session *sessions.Session) error {
+	// Unlike a request context, ctx is not done just because a client went
+	// away, so Save does not outlive it.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := (&http.Request{Header: http.Header{}}).WithContext(ctx)
+
+	return mstore.save(r, discardWriter{}, session, time.Time{})
+}
+
+// discardWriter is the response writer of SaveByID, which drops the cookie.
+type discardWriter struct{}
+
+func (discardWriter) Header() http.Header         { return http.Header{} }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,66 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetByID(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	loaded, err := store.GetByID(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Error loading session by ID: %v", err)
+	}
+	if loaded.IsNew || loaded.Name() != "session-key" || loaded.ID != session.ID || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected the saved session; Got name %q, ID %q, IsNew %t, values %v", loaded.Name(), loaded.ID, loaded.IsNew, loaded.Values)
+	}
+
+	// Changes saved by ID show up for the cookie.
+	loaded.Values["foo"] = "baz"
+	if err = store.SaveByID(ctx, loaded); err != nil {
+		t.Fatalf("Error saving session by ID: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["foo"] != "baz" {
+		t.Errorf("Expected the session saved by ID to load; Got %v, %v", session.Values, err)
+	}
+
+	if _, err = store.GetByID(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+	if _, err = store.GetByID(ctx, "not-an-id"); err == nil || errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected an invalid ID error; Got %v", err)
+	}
+
+	if err = store.DeleteByID(ctx, loaded.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if err = store.SaveByID(ctx, loaded); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for a deleted session; Got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = store.SaveByID(cancelled, loaded); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled; Got %v", err)
+	}
+}
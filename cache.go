@@ -0,0 +1,215 @@
+package mongodbstoregorilla
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long a cached session is served when
+	// CacheConfig.TTL is not set.
+	DefaultCacheTTL = 30 * time.Second
+
+	// DefaultCacheMaxEntries is the number of cached sessions when
+	// CacheConfig.MaxEntries is not set.
+	DefaultCacheMaxEntries = 10000
+
+	// DefaultCacheMaxStaleness is how long after it was cached a session is
+	// served by ServeStaleOnError when CacheConfig.MaxStaleness is not set.
+	DefaultCacheMaxStaleness = 5 * time.Minute
+)
+
+// CacheConfig configures the in-process cache of loaded sessions, which
+// saves the FindOne of loading a session that this instance loaded or saved
+// recently.
+//
+// The cache holds the session documents rather than the decoded values, so
+// that every load decodes its own copy. Save and Delete keep it up to date,
+// but changes made by other instances are only seen once an entry is older
+// than TTL. Cached sessions past their expiry are never served.
+type CacheConfig struct {
+	// whether to cache sessions
+	Enabled bool
+	// how long a cached session is served, 0 for DefaultCacheTTL
+	TTL time.Duration
+	// maximum number of cached sessions, the least recently used are
+	// evicted first, 0 for DefaultCacheMaxEntries
+	MaxEntries int
+	// how long after it was cached a session is served by ServeStaleOnError
+	// while mongoDB is unavailable, 0 for DefaultCacheMaxStaleness
+	MaxStaleness time.Duration
+}
+
+// sessionCache is an LRU cache of session documents keyed by session ID.
+// A nil cache caches nothing.
+type sessionCache struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	maxStaleness time.Duration
+	maxEntries   int
+	entries      map[string]*list.Element
+	lru          *list.List
+	stats        CacheStats
+}
+
+// CacheStats counts the lookups of the session cache, as returned by
+// CacheStats.
+type CacheStats struct {
+	// loads served from the cache
+	Hits int64
+	// loads that went to the database
+	Misses int64
+	// number of cached sessions
+	Entries int
+}
+
+type cacheEntry struct {
+	id      string
+	sessDoc sessionDoc
+	added   time.Time
+}
+
+func newSessionCache(cfg CacheConfig) *sessionCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultCacheMaxEntries
+	}
+	if cfg.MaxStaleness <= 0 {
+		cfg.MaxStaleness = DefaultCacheMaxStaleness
+	}
+	return &sessionCache{
+		ttl:          cfg.TTL,
+		maxStaleness: cfg.MaxStaleness,
+		maxEntries:   cfg.MaxEntries,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+// get returns a copy of the cached document of the session id of tenant,
+// or nil.
+func (c *sessionCache) get(id, tenant string) *sessionDoc {
+	return c.lookup(id, tenant, false)
+}
+
+// stale returns a copy of the cached document of the session id of tenant
+// past the TTL but within MaxStaleness, e.g. while the database is
+// unavailable, or nil.
+func (c *sessionCache) stale(id, tenant string) *sessionDoc {
+	return c.lookup(id, tenant, true)
+}
+
+func (c *sessionCache) lookup(id, tenant string, stale bool) *sessionDoc {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[id]
+	if !ok {
+		c.miss(stale)
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	// Old entries stay cached until they are replaced or evicted, to be
+	// served stale.
+	maxAge := c.ttl
+	if stale {
+		maxAge = c.maxStaleness
+	}
+	if time.Since(entry.added) > maxAge {
+		c.miss(stale)
+		return nil
+	}
+	if entry.sessDoc.TenantID != tenant {
+		c.miss(stale)
+		return nil
+	}
+	if !stale {
+		c.stats.Hits++
+	}
+	c.lru.MoveToFront(elem)
+	sessDoc := entry.sessDoc
+
+	return &sessDoc
+}
+
+// put caches a copy of sessDoc for the session id.
+func (c *sessionCache) put(id string, sessDoc *sessionDoc) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+	entry := &cacheEntry{id: id, sessDoc: *sessDoc, added: time.Now()}
+	// Whether the data is stale is found out on every load.
+	entry.sessDoc.stale, entry.sessDoc.legacy = false, false
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the session id from the cache.
+func (c *sessionCache) invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+}
+
+// purge empties the cache, e.g. after deleting sessions by filter.
+func (c *sessionCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// miss counts a lookup that found nothing, unless it was a stale one, which
+// is made after the database failed.
+func (c *sessionCache) miss(stale bool) {
+	if !stale {
+		c.stats.Misses++
+	}
+}
+
+// snapshot returns the counters of the cache, zero for a nil cache.
+func (c *sessionCache) snapshot() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+
+	return stats
+}
+
+func (c *sessionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).id)
+}
+
+// CacheStats returns the hit and miss counts of the session cache since the
+// store was created, all zero when CacheConfig.Enabled is not set.
+func (mstore *MongoDBStore) CacheStats() CacheStats {
+	return mstore.cache.snapshot()
+}
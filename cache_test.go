@@ -0,0 +1,201 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newCachedStore(t testing.TB, coll *mongo.Collection, cache CacheConfig) *MongoDBStore {
+	cfg := defaultConfig
+	cfg.Cache = cache
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	return store
+}
+
+// overwriteValues replaces the stored values behind the store's back, as
+// another instance would.
+func overwriteValues(t *testing.T, store *MongoDBStore, coll *mongo.Collection, values map[interface{}]interface{}) {
+	sessDoc := &sessionDoc{}
+	if err := store.encodeValues("session-key", values, sessDoc); err != nil {
+		t.Fatalf("Error encoding values: %v", err)
+	}
+	if _, err := coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"data": sessDoc.Data}}); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	coll := newTestCollection(t)
+	store := newCachedStore(t, coll, CacheConfig{Enabled: true, TTL: time.Hour})
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "saved"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	value := func() interface{} {
+		loaded, err := loadWithCookie(store, cookie)
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return loaded.Values["foo"]
+	}
+
+	overwriteValues(t, store, coll, map[interface{}]interface{}{"foo": "elsewhere"})
+	if got := value(); got != "saved" {
+		t.Errorf("Expected the cached values; Got %v", got)
+	}
+
+	// Save updates the cache.
+	loaded, _ := loadWithCookie(store, cookie)
+	loaded.Values["foo"] = "resaved"
+	if err := store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	overwriteValues(t, store, coll, map[interface{}]interface{}{"foo": "elsewhere"})
+	if got := value(); got != "resaved" {
+		t.Errorf("Expected the cache to hold the saved values; Got %v", got)
+	}
+
+	// Loads get independent copies.
+	loaded, _ = loadWithCookie(store, cookie)
+	loaded.Values["foo"] = "modified"
+	if got := value(); got != "resaved" {
+		t.Errorf("Expected modifying a loaded session not to change the cache; Got %v", got)
+	}
+
+	// Save caches the session, so every load was a hit.
+	if stats := store.CacheStats(); stats.Hits != 5 || stats.Misses != 0 {
+		t.Errorf("Expected 5 hits and no misses; Got %+v", stats)
+	}
+
+	// Delete invalidates it.
+	if err := store.DeleteByID(context.Background(), session.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if loaded, _ = loadWithCookie(store, cookie); !loaded.IsNew {
+		t.Error("Expected a deleted session not to be served from the cache")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	coll := newTestCollection(t)
+	store := newCachedStore(t, coll, CacheConfig{Enabled: true, TTL: 50 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "saved"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	overwriteValues(t, store, coll, map[interface{}]interface{}{"foo": "elsewhere"})
+
+	time.Sleep(100 * time.Millisecond)
+	loaded, err := loadWithCookie(store, resp.Header().Get("Set-Cookie"))
+	if err != nil || loaded.Values["foo"] != "elsewhere" {
+		t.Errorf("Expected the change to show after TTL; Got %v, %v", loaded.Values, err)
+	}
+}
+
+func TestCacheExpiredSession(t *testing.T) {
+	store := newCachedStore(t, newTestCollection(t), CacheConfig{Enabled: true, TTL: time.Hour})
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Options.MaxAge = 1
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if loaded, _ := loadWithCookie(store, resp.Header().Get("Set-Cookie")); !loaded.IsNew {
+		t.Error("Expected an expired session not to be served from the cache")
+	}
+}
+
+func TestSessionCacheEviction(t *testing.T) {
+	cache := newSessionCache(CacheConfig{Enabled: true, MaxEntries: 2})
+	for _, id := range []string{"a", "b"} {
+		cache.put(id, &sessionDoc{Name: id})
+	}
+	cache.get("a", "")
+	cache.put("c", &sessionDoc{Name: "c"})
+	if cache.get("b", "") != nil {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if cache.get("a", "") == nil || cache.get("c", "") == nil {
+		t.Error("Expected the recently used entries to be kept")
+	}
+	if cache.get("a", "other-tenant") != nil {
+		t.Error("Expected entries not to be served to another tenant")
+	}
+	if stats := cache.snapshot(); stats != (CacheStats{Hits: 3, Misses: 2, Entries: 2}) {
+		t.Errorf("Expected 3 hits, 2 misses and 2 entries; Got %+v", stats)
+	}
+	if newSessionCache(CacheConfig{}) != nil {
+		t.Error("Expected no cache when disabled")
+	}
+}
+
+func benchmarkLoadFinds(b *testing.B, cache CacheConfig) {
+	var finds int64
+	monitor := &event.CommandMonitor{Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+		if evt.CommandName == "find" {
+			atomic.AddInt64(&finds, 1)
+		}
+	}}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetMonitor(monitor).SetServerSelectionTimeout(time.Second))
+	if err != nil {
+		b.Fatalf("Error connecting to mongoDB: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	if err = client.Ping(ctx, nil); err != nil {
+		b.Skipf("mongoDB is not available: %v", err)
+	}
+	coll := client.Database("test").Collection("mongodbstore_" + b.Name())
+	defer coll.Drop(ctx)
+
+	store := newCachedStore(b, coll, cache)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values = benchmarkValues(20)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		b.Fatalf("Error saving session: %v", err)
+	}
+
+	atomic.StoreInt64(&finds, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loaded := sessions.NewSession(store, "session-key")
+		loaded.ID = session.ID
+		if found, err := store.load(ctx, loaded); err != nil || !found {
+			b.Fatalf("Error loading session: %v, found %t", err, found)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&finds))/float64(b.N), "finds/op")
+}
+
+func BenchmarkLoadUncached(b *testing.B) {
+	benchmarkLoadFinds(b, CacheConfig{})
+}
+
+func BenchmarkLoadCached(b *testing.B) {
+	benchmarkLoadFinds(b, CacheConfig{Enabled: true})
+}
@@ -0,0 +1,118 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrAlreadyClaimed is returned by Claim for a session that was claimed before.
+var ErrAlreadyClaimed = errors.New("mongodbstore: session already claimed")
+
+// Create persists a new pending session with the given name and values
+// without a request, e.g. for QR code or device pairing login flows.
+//
+// The session can not be loaded from a cookie until a client claims it with
+// Claim using session.ID.
+func (mstore *MongoDBStore) Create(ctx context.Context, name string, values map[interface{}]interface{}) (*sessions.Session, error) {
+	session := sessions.NewSession(mstore, name)
+	options := mstore.options
+	options.MaxAge = mstore.maxAge(name)
+	session.Options = &options
+	if values != nil {
+		session.Values = values
+	}
+
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ID interface{}
+	session.ID, ID = mstore.newID()
+	now := time.Now()
+	sessDoc := &sessionDoc{
+		ID:            ID,
+		Modified:      now,
+		Created:       now,
+		SchemaVersion: schemaVersion,
+		Name:          name,
+		Pending:       true,
+		TenantID:      tenant,
+		Writer:        mstore.writerTag,
+	}
+	if err = mstore.storeValues(name, session.Values, sessDoc); err != nil {
+		return nil, err
+	}
+	sessDoc.ExpiresAt = mstore.expiresAt(now, now, options.MaxAge)
+	stored, err := mstore.fieldNames.storedDoc(sessDoc)
+	if err == nil {
+		err = mstore.ops.insertOne(ctx, mstore.coll, stored)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongodbstore: error creating session: %w", err)
+	}
+
+	return session, nil
+}
+
+// Claim atomically marks the pending session id created by Create as
+// claimed, sets its cookie on w and returns it.
+//
+// A session can only be claimed once; later calls return ErrAlreadyClaimed.
+func (mstore *MongoDBStore) Claim(ctx context.Context, id string, w http.ResponseWriter) (*sessions.Session, error) {
+	ID, err := mstore.docID(id)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessDoc := &sessionDoc{}
+	raw, err := mstore.ops.findOneAndUpdate(ctx, mstore.coll,
+		withTenant(bson.M{"_id": ID, "pending": true}, tenant),
+		bson.M{"$set": bson.M{mstore.fieldNames.Modified: time.Now()}, "$unset": bson.M{"pending": ""}},
+	)
+	if err == nil {
+		err = mstore.fieldNames.decodeDoc(raw, sessDoc)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, err := mstore.ops.countDocuments(ctx, mstore.coll, withTenant(bson.M{"_id": ID}, tenant))
+		if err != nil {
+			return nil, fmt.Errorf("mongodbstore: error claiming session: %w", err)
+		}
+		if count > 0 {
+			return nil, ErrAlreadyClaimed
+		}
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongodbstore: error claiming session: %w", err)
+	}
+
+	session := sessions.NewSession(mstore, sessDoc.Name)
+	options := mstore.options
+	options.MaxAge = mstore.maxAge(sessDoc.Name)
+	session.Options = &options
+	session.ID = id
+	if err = mstore.loadValues(sessDoc.Name, sessDoc, &session.Values); err != nil {
+		return nil, err
+	}
+	encodedID, err := securecookie.EncodeMulti(sessDoc.Name, id, mstore.getCodecs()...)
+	if err != nil {
+		return nil, err
+	}
+	if err = mstore.transport.SetToken(w, sessDoc.Name, encodedID, session.Options); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
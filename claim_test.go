@@ -0,0 +1,75 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateAndClaim(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+
+	created, err := store.Create(ctx, "session-key", map[interface{}]interface{}{"device": "tv"})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	resp := httptest.NewRecorder()
+	claimed, err := store.Claim(ctx, created.ID, resp)
+	if err != nil {
+		t.Fatalf("Error claiming session: %v", err)
+	}
+	if claimed.ID != created.ID || claimed.Values["device"] != "tv" {
+		t.Errorf("Expected claimed session %s with device=tv; Got %s with %v", created.ID, claimed.ID, claimed.Values)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatal("Expected claim to set a cookie")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.Get(req, "session-key")
+	if err != nil || session.IsNew || session.Values["device"] != "tv" {
+		t.Errorf("Expected claimed session to load from cookie; Got err %v, IsNew %t, values %v", err, session.IsNew, session.Values)
+	}
+
+	if _, err = store.Claim(ctx, created.ID, httptest.NewRecorder()); !errors.Is(err, ErrAlreadyClaimed) {
+		t.Errorf("Expected ErrAlreadyClaimed; Got %v", err)
+	}
+	if _, err = store.Claim(ctx, primitive.NewObjectID().Hex(), httptest.NewRecorder()); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestCreateExpiresAt(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 0
+	cfg.ServerSideTTL = time.Hour
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if _, err = store.Create(context.Background(), "session-key", nil); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	var doc sessionDoc
+	if err = coll.FindOne(context.Background(), bson.M{}).Decode(&doc); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	if got := doc.ExpiresAt.Sub(doc.Modified); got != time.Hour {
+		t.Errorf("Expected expires_at ServerSideTTL after modified; Got %v", got)
+	}
+}
@@ -0,0 +1,238 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SweepOnce deletes the expired sessions and returns how many documents were
+// removed. With DryRun it only counts them.
+//
+// A session is expired when its own expiry time has passed, or, for
+// documents without one, when its Modified timestamp is older than the store
+// MaxAge.
+//
+// It gives a deterministic cleanup trigger independent of mongoDB's TTL
+// monitor, which may not run reliably for rarely touched collections on small
+// or scale-to-zero clusters, and cleans up for deployments that can not
+// create the TTL index and set IndexTTL to false. Call it from a scheduled
+// function or a shutdown hook; it is safe to run from several instances at
+// the same time.
+func (mstore *MongoDBStore) SweepOnce(ctx context.Context) (int64, error) {
+	return mstore.cleanup(ctx, time.Now())
+}
+
+// DeleteExpired deletes the expired sessions.
+//
+// Deprecated: Use SweepOnce, which does the same.
+func (mstore *MongoDBStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return mstore.SweepOnce(ctx)
+}
+
+// DeleteNeverUsed deletes the sessions that were inserted more than
+// olderThan ago and never saved again, e.g. because the client never got the
+// cookie, and returns how many were removed. With DryRun it only counts them.
+//
+// It matches the documents whose created timestamp equals their modified
+// timestamp, which includes sessions made by Create that were never claimed.
+// Documents saved before the created timestamp was stored are left to
+// SweepOnce. It requires MongoDB 3.6.
+func (mstore *MongoDBStore) DeleteNeverUsed(ctx context.Context, olderThan time.Duration) (int64, error) {
+	names := mstore.fieldNames
+	filter := bson.M{
+		names.Created: bson.M{"$lt": time.Now().Add(-olderThan)},
+		"$expr":       bson.M{"$eq": bson.A{"$" + names.Created, "$" + names.Modified}},
+	}
+	deleted, err := mstore.deleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("mongodbstore: error deleting unused sessions: %w", err)
+	}
+	mstore.logger.Debug("mongodbstore: deleted unused sessions", "op", "delete_never_used", "count", deleted, "dry_run", mstore.dryRun)
+
+	return deleted, nil
+}
+
+// StartCleanup runs SweepOnce every interval in a background goroutine
+// until ctx is cancelled or the returned stop function is called. stop waits
+// for a running cleanup to finish. Errors are reported to the Logger.
+//
+// Like SweepOnce it is safe to run from several instances at the same
+// time; each deletes whatever has expired when it runs.
+func (mstore *MongoDBStore) StartCleanup(ctx context.Context, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// cleanup logs its own errors.
+				mstore.SweepOnce(ctx)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// CleanupBatched deletes the expired sessions like SweepOnce, but in batches
+// of at most batchSize documents with a pause between batches, so that
+// cleaning up a huge collection does not spike I/O. It stops when ctx is
+// cancelled and returns the number of documents deleted so far.
+func (mstore *MongoDBStore) CleanupBatched(ctx context.Context, batchSize int, pause time.Duration) (int64, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("mongodbstore: batch size must be positive")
+	}
+	deleted, err := mstore.deleteBatched(ctx, mstore.expiredFilter(time.Now()), batchSize, pause, "cleanup_batched")
+	if err != nil && ctx.Err() == nil {
+		return deleted, fmt.Errorf("mongodbstore: error deleting expired sessions: %w", err)
+	}
+	return deleted, err
+}
+
+// DefaultPurgeBatchSize is the number of documents PurgeExpired and
+// PurgeOlderThan delete per batch when PurgeBatchSize is not set.
+const DefaultPurgeBatchSize = 1000
+
+// PurgeExpired is CleanupBatched with batches of PurgeBatchSize documents
+// and no pause, so that no single delete runs for long, e.g. for deployments
+// that set IndexTTL to false because the TTL monitor is throttled or too
+// coarse. With DryRun it only counts the expired sessions.
+//
+// It is safe to run from several instances at the same time; each deletes
+// the documents the others have not deleted yet.
+func (mstore *MongoDBStore) PurgeExpired(ctx context.Context) (int64, error) {
+	return mstore.CleanupBatched(ctx, mstore.purgeBatchSize, 0)
+}
+
+// PurgeOlderThan deletes the sessions last modified before cutoff, expired
+// or not, like DeleteModifiedBefore but in batches like PurgeExpired, e.g. to
+// log out everyone who signed in before an incident. Like the other
+// maintenance operations it is not restricted to the tenant of ctx.
+func (mstore *MongoDBStore) PurgeOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := mstore.fieldNames.mapFilter(bson.M{DefaultFieldNames.Modified: bson.M{"$lt": cutoff}})
+	deleted, err := mstore.deleteBatched(ctx, filter, mstore.purgeBatchSize, 0, "purge_older_than")
+	if err != nil && ctx.Err() == nil {
+		return deleted, fmt.Errorf("mongodbstore: error deleting sessions: %w", err)
+	}
+	return deleted, err
+}
+
+// deleteBatched deletes the documents matching filter in batches of at most
+// batchSize, pausing between batches, and returns how many it deleted before
+// it was done, ctx was cancelled or an operation failed. With DryRun it only
+// counts them.
+func (mstore *MongoDBStore) deleteBatched(ctx context.Context, filter bson.M, batchSize int, pause time.Duration, op string) (int64, error) {
+	coll := mstore.collection(ctx)
+	if mstore.dryRun {
+		return mstore.ops.countDocuments(ctx, coll, filter)
+	}
+
+	var total int64
+	for {
+		var ids []interface{}
+		err := mstore.retry(ctx, true, func() (err error) {
+			ids, err = mstore.ops.findIDs(ctx, coll, filter, int64(batchSize))
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+
+		// Re-checking filter keeps sessions saved in the meantime, and
+		// makes the delete safe to retry.
+		var res *mongo.DeleteResult
+		err = mstore.retry(ctx, true, func() (err error) {
+			res, err = mstore.ops.deleteMany(ctx, coll, bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$in": bson.A(ids)}}}})
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		// Any of the cached sessions may have been in the batch.
+		mstore.cache.purge()
+		total += res.DeletedCount
+		mstore.logger.Debug("mongodbstore: deleted sessions", "op", op, "count", res.DeletedCount)
+		mstore.observeEvent(EventCleanedUp, res.DeletedCount)
+		if len(ids) < batchSize {
+			return total, nil
+		}
+
+		if pause > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// cleanup removes every session that is expired at now.
+func (mstore *MongoDBStore) cleanup(ctx context.Context, now time.Time) (deleted int64, err error) {
+	if mstore.tracer != nil {
+		var span Span
+		ctx, span = mstore.startSpan(ctx, OpDeleteExpired, "delete")
+		defer func() {
+			span.SetAttribute("sessions.deleted", deleted)
+			span.End(err)
+		}()
+	}
+	deleted, err = mstore.deleteMany(ctx, mstore.expiredFilter(now))
+	if err != nil {
+		mstore.logger.Error("mongodbstore: error deleting expired sessions", "op", "cleanup", "error", err)
+		return 0, fmt.Errorf("mongodbstore: error deleting expired sessions: %w", err)
+	}
+	mstore.logger.Debug("mongodbstore: deleted expired sessions", "op", "cleanup", "count", deleted, "dry_run", mstore.dryRun)
+	if !mstore.dryRun {
+		mstore.observeEvent(EventCleanedUp, deleted)
+	}
+
+	return deleted, nil
+}
+
+// deleteMany deletes the documents matching filter, or only counts them when
+// the store is in dry-run mode.
+func (mstore *MongoDBStore) deleteMany(ctx context.Context, filter interface{}) (int64, error) {
+	if mstore.dryRun {
+		return mstore.ops.countDocuments(ctx, mstore.coll, filter)
+	}
+	res, err := mstore.ops.deleteMany(ctx, mstore.coll, filter)
+	if err != nil {
+		return 0, err
+	}
+	// Any of the cached sessions may have matched the filter.
+	mstore.cache.purge()
+
+	return res.DeletedCount, nil
+}
+
+// expiredFilter returns the filter matching the sessions expired at now.
+func (mstore *MongoDBStore) expiredFilter(now time.Time) bson.M {
+	names := mstore.fieldNames
+	expired := bson.A{bson.M{names.ExpiresAt: bson.M{"$lt": now}}}
+	cutoff := now.Add(-time.Duration(mstore.retention(mstore.options.MaxAge)) * time.Second)
+	expired = append(expired, bson.M{names.ExpiresAt: bson.M{"$exists": false}, names.Modified: bson.M{"$lt": cutoff}})
+	if mstore.absoluteMaxAge > 0 {
+		cutoff := now.Add(-time.Duration(mstore.absoluteMaxAge) * time.Second)
+		expired = append(expired, bson.M{names.Created: bson.M{"$lt": cutoff}})
+	}
+	return bson.M{"$or": expired}
+}
@@ -0,0 +1,332 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// insertAgedSessions inserts n session documents last modified age ago.
+func insertAgedSessions(t *testing.T, store *MongoDBStore, n int, age time.Duration) {
+	for i := 0; i < n; i++ {
+		doc := &sessionDoc{
+			ID:       primitive.NewObjectID(),
+			Data:     "data",
+			Modified: time.Now().Add(-age),
+		}
+		if _, err := store.coll.InsertOne(context.Background(), doc); err != nil {
+			t.Fatalf("Error inserting session: %v", err)
+		}
+	}
+}
+
+// seedMemorySessions inserts n session documents last modified age ago
+// into the memory store, with the extra fields set.
+func seedMemorySessions(t *testing.T, store *MongoDBStore, n int, age time.Duration, extra bson.M) {
+	for i := 0; i < n; i++ {
+		set := bson.M{store.fieldNames.Data: "data", store.fieldNames.Modified: time.Now().Add(-age)}
+		for key, val := range extra {
+			set[key] = val
+		}
+		_, err := store.ops.updateOne(context.Background(), store.coll, bson.M{"_id": primitive.NewObjectID()}, bson.M{"$set": set}, options.Update().SetUpsert(true))
+		if err != nil {
+			t.Fatalf("Error inserting session: %v", err)
+		}
+	}
+}
+
+func countMemorySessions(t testing.TB, store *MongoDBStore) int64 {
+	count, err := store.ops.countDocuments(context.Background(), store.coll, bson.M{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	return count
+}
+
+func TestSweepOnce(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	insertAgedSessions(t, store, 3, maxAge+time.Hour)
+	insertAgedSessions(t, store, 2, time.Minute)
+
+	deleted, err := store.SweepOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Error sweeping sessions: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected 3 deleted sessions; Got %d", deleted)
+	}
+	count, err := coll.CountDocuments(context.Background(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 remaining sessions; Got %d", count)
+	}
+}
+
+func TestSweepOnceDryRun(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.DryRun = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	insertAgedSessions(t, store, 3, maxAge+time.Hour)
+	insertAgedSessions(t, store, 2, time.Minute)
+
+	deleted, err := store.SweepOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Error sweeping sessions: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected 3 sessions reported; Got %d", deleted)
+	}
+	count, err := coll.CountDocuments(context.Background(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("Expected no sessions removed in dry-run; Got %d remaining", count)
+	}
+}
+
+func TestCleanupBatched(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	insertAgedSessions(t, store, 25, maxAge+time.Hour)
+	insertAgedSessions(t, store, 3, time.Minute)
+
+	deleted, err := store.CleanupBatched(context.Background(), 10, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error cleaning up sessions: %v", err)
+	}
+	if deleted != 25 {
+		t.Errorf("Expected 25 deleted sessions; Got %d", deleted)
+	}
+	count, err := coll.CountDocuments(context.Background(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 remaining sessions; Got %d", count)
+	}
+}
+
+func TestCleanupBatchedCancel(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	insertAgedSessions(t, store, 10, maxAge+time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	deleted, err := store.CleanupBatched(ctx, 2, time.Hour)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded; Got %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 sessions deleted before cancellation; Got %d", deleted)
+	}
+}
+
+func TestCleanupBatchedMemory(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Cache = CacheConfig{Enabled: true}
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	saveTestSession(t, store)
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	seedMemorySessions(t, store, 5, maxAge+time.Hour, nil)
+
+	deleted, err := store.CleanupBatched(context.Background(), 2, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error cleaning up sessions: %v", err)
+	}
+	if deleted != 5 {
+		t.Errorf("Expected 5 deleted sessions; Got %d", deleted)
+	}
+	if count := countMemorySessions(t, store); count != 1 {
+		t.Errorf("Expected 1 remaining session; Got %d", count)
+	}
+	if entries := store.CacheStats().Entries; entries != 0 {
+		t.Errorf("Expected the cache to be purged; Got %d entries", entries)
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.IndexTTL = false
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	insertAgedSessions(t, store, 3, maxAge+time.Hour)
+	insertAgedSessions(t, store, 2, time.Minute)
+
+	stop := store.StartCleanup(context.Background(), 10*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	var count int64
+	for time.Now().Before(deadline) {
+		if count, err = coll.CountDocuments(context.Background(), map[string]interface{}{}); err == nil && count == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	if count != 2 {
+		t.Errorf("Expected the janitor to leave 2 live sessions; Got %d", count)
+	}
+
+	// Nothing runs after stop.
+	insertAgedSessions(t, store, 1, maxAge+time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if deleted, _ := store.DeleteExpired(context.Background()); deleted != 1 {
+		t.Errorf("Expected the janitor to be stopped; Got %d expired sessions left", deleted)
+	}
+}
+
+func TestDeleteNeverUsed(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	insertAgedSessions(t, store, 2, time.Hour)
+	created := time.Now().Add(-time.Hour)
+	for _, doc := range []*sessionDoc{
+		{ID: primitive.NewObjectID(), Data: "data", Created: created, Modified: created},
+		{ID: primitive.NewObjectID(), Data: "data", Created: created, Modified: created.Add(time.Minute)},
+		{ID: primitive.NewObjectID(), Data: "data", Created: time.Now(), Modified: time.Now()},
+	} {
+		if _, err = coll.InsertOne(context.Background(), doc); err != nil {
+			t.Fatalf("Error inserting session: %v", err)
+		}
+	}
+
+	store.dryRun = true
+	if counted, err := store.DeleteNeverUsed(context.Background(), time.Minute); err != nil || counted != 1 {
+		t.Errorf("Expected 1 unused session counted; Got %d, %v", counted, err)
+	}
+	store.dryRun = false
+	deleted, err := store.DeleteNeverUsed(context.Background(), time.Minute)
+	if err != nil {
+		t.Fatalf("Error deleting unused sessions: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted session; Got %d", deleted)
+	}
+	if count, _ := coll.CountDocuments(context.Background(), map[string]interface{}{}); count != 4 {
+		t.Errorf("Expected 4 remaining sessions; Got %d", count)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	cfg := defaultConfig
+	cfg.PurgeBatchSize = 4
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	seedMemorySessions(t, store, 10, maxAge+time.Hour, nil)
+	seedMemorySessions(t, store, 3, time.Minute, nil)
+
+	deleted, err := store.PurgeExpired(context.Background())
+	if err != nil {
+		t.Fatalf("Error purging sessions: %v", err)
+	}
+	if deleted != 10 {
+		t.Errorf("Expected 10 deleted sessions; Got %d", deleted)
+	}
+	if count := countMemorySessions(t, store); count != 3 {
+		t.Errorf("Expected 3 remaining sessions; Got %d", count)
+	}
+}
+
+func TestPurgeExpiredCancel(t *testing.T) {
+	cfg := defaultConfig
+	cfg.PurgeBatchSize = 2
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	maxAge := time.Duration(store.options.MaxAge) * time.Second
+	seedMemorySessions(t, store, 6, maxAge+time.Hour, nil)
+
+	// The first batch is deleted before the cancellation is noticed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deleted, err := store.PurgeExpired(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled; Got %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 sessions deleted before cancellation; Got %d", deleted)
+	}
+}
+
+func TestPurgeOlderThan(t *testing.T) {
+	cfg := defaultConfig
+	cfg.PurgeBatchSize = 3
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	seedMemorySessions(t, store, 5, 2*time.Hour, nil)
+	seedMemorySessions(t, store, 2, time.Minute, nil)
+
+	deleted, err := store.PurgeOlderThan(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Error purging sessions: %v", err)
+	}
+	if deleted != 5 {
+		t.Errorf("Expected 5 deleted sessions; Got %d", deleted)
+	}
+	if count := countMemorySessions(t, store); count != 2 {
+		t.Errorf("Expected 2 remaining sessions; Got %d", count)
+	}
+}
+
+func TestPurgeErrors(t *testing.T) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	errFail := errors.New("fail")
+	failOps(store, errFail, 100)
+	ctx := context.Background()
+	cutoff := time.Now()
+	for name, purge := range map[string]func() (int64, error){
+		"CleanupBatched": func() (int64, error) { return store.CleanupBatched(ctx, 10, 0) },
+		"PurgeExpired":   func() (int64, error) { return store.PurgeExpired(ctx) },
+		"PurgeOlderThan": func() (int64, error) { return store.PurgeOlderThan(ctx, cutoff) },
+	} {
+		_, err := purge()
+		if !errors.Is(err, errFail) || !strings.HasPrefix(err.Error(), "mongodbstore: error deleting ") {
+			t.Errorf("%s: Expected a wrapped error; Got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultStartupTimeout bounds the ping of NewMongoDBStoreFromClient when
+// its context has no deadline.
+const DefaultStartupTimeout = 10 * time.Second
+
+// NewMongoDBStoreFromClient is like NewMongoDBStoreFromDatabase for the
+// collection collectionName of the database db of client. A zero cfg means
+// the configuration of NewMongoDBStore.
+//
+// The ping validating the connection is bounded by DefaultStartupTimeout
+// unless ctx has a deadline.
+func NewMongoDBStoreFromClient(ctx context.Context, client *mongo.Client, db, collectionName string, cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
+	if client == nil {
+		return nil, errors.New("mongodbstore: no client")
+	}
+	if db == "" {
+		return nil, errors.New("mongodbstore: empty database name")
+	}
+	if collectionName == "" {
+		return nil, errors.New("mongodbstore: empty collection name")
+	}
+	if reflect.DeepEqual(cfg, MongoDBStoreConfig{}) {
+		cfg = defaultConfig
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultStartupTimeout)
+		defer cancel()
+	}
+
+	return NewMongoDBStoreFromDatabase(ctx, client.Database(db), collectionName, cfg, keyPairs...)
+}
+
+// CollectionOptions are the options of the collection created with
+// CreateCollection.
+type CollectionOptions struct {
+	// default collation of the collection, nil for none
+	Collation *options.Collation
+	// document validator, e.g. a $jsonSchema query, nil for none
+	Validator interface{}
+}
+
+// namespaceExists is the error code of creating a collection that exists.
+const namespaceExists = 48
+
+// createCollection creates the collection of the store with opts, unless it
+// exists already.
+func (mstore *MongoDBStore) createCollection(ctx context.Context, opts *CollectionOptions) error {
+	cmd := bson.D{{Key: "create", Value: mstore.coll.Name()}}
+	if opts.Collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: opts.Collation.ToDocument()})
+	}
+	if opts.Validator != nil {
+		cmd = append(cmd, bson.E{Key: "validator", Value: opts.Validator})
+	}
+	err := mstore.coll.Database().RunCommand(ctx, cmd).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceExists {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("mongodbstore: error creating collection: %w", err)
+	}
+
+	return nil
+}
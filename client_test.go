@@ -0,0 +1,54 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewMongoDBStoreFromClient(t *testing.T) {
+	ctx := context.Background()
+	coll := newTestCollection(t)
+	client := coll.Database().Client()
+
+	store, err := NewMongoDBStoreFromClient(ctx, client, "test", coll.Name(), MongoDBStoreConfig{}, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if store.options.MaxAge != defaultConfig.SessionOptions.MaxAge {
+		t.Errorf("Expected the default configuration for a zero config; Got MaxAge %d", store.options.MaxAge)
+	}
+	if _, ok := listTestIndexes(t, coll)[ttlIndexName]; !ok {
+		t.Error("Expected the TTL index to be created")
+	}
+
+	for _, names := range [][2]string{{"", coll.Name()}, {"test", ""}} {
+		if _, err = NewMongoDBStoreFromClient(ctx, client, names[0], names[1], defaultConfig, []byte("secret")); err == nil {
+			t.Errorf("Expected an error for database %q and collection %q", names[0], names[1])
+		}
+	}
+	if _, err = NewMongoDBStoreFromClient(ctx, nil, "test", coll.Name(), defaultConfig, []byte("secret")); err == nil {
+		t.Error("Expected an error for a nil client")
+	}
+}
+
+func TestCreateCollection(t *testing.T) {
+	ctx := context.Background()
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.CreateCollection = &CollectionOptions{}
+	// The second store finds the collection created.
+	for i := 0; i < 2; i++ {
+		if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+			t.Fatalf("Error initializing mongodb store: %v", err)
+		}
+	}
+	names, err := coll.Database().ListCollectionNames(ctx, bson.M{"name": coll.Name()})
+	if err != nil {
+		t.Fatalf("Error listing collections: %v", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("Expected the collection to be created; Got %v", names)
+	}
+}
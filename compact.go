@@ -0,0 +1,76 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Compact upgrades every session document written with an older schema to
+// the current one and returns how many documents were rewritten. With DryRun
+// it only counts them.
+//
+// Documents without a created timestamp get the time of their ObjectID, or
+// their modified timestamp, so that AbsoluteMaxAge and DeleteNeverUsed
+// apply to them, and documents without an expiry get one like Save sets.
+//
+// Documents that are already up to date are skipped, so an interrupted
+// Compact can simply be run again to resume.
+func (mstore *MongoDBStore) Compact(ctx context.Context) (int, error) {
+	return mstore.CompactWithProgress(ctx, nil)
+}
+
+// CompactWithProgress is like Compact but calls progress with the number of
+// documents rewritten so far after each document.
+func (mstore *MongoDBStore) CompactWithProgress(ctx context.Context, progress func(done int)) (int, error) {
+	// Save keeps the created timestamp of a document it updates, so an
+	// upgraded document may still lack one.
+	names := mstore.fieldNames
+	outdated := bson.A{
+		bson.M{names.SchemaVersion: bson.M{"$ne": schemaVersion}},
+		bson.M{names.Created: bson.M{"$exists": false}},
+	}
+	filter := bson.M{"$or": outdated}
+	if mstore.dryRun {
+		count, err := mstore.ops.countDocuments(ctx, mstore.coll, filter)
+		if err != nil {
+			return 0, fmt.Errorf("mongodbstore: error counting outdated sessions: %w", err)
+		}
+		return int(count), nil
+	}
+
+	done := 0
+	err := mstore.eachDoc(ctx, mstore.coll, filter, func(raw bson.Raw) error {
+		sessDoc := &sessionDoc{}
+		if err := mstore.fieldNames.decodeDoc(raw, sessDoc); err != nil {
+			return fmt.Errorf("mongodbstore: error decoding session document: %w", err)
+		}
+
+		set := bson.M{names.SchemaVersion: schemaVersion}
+		createdAt := created(sessDoc)
+		if sessDoc.Created.IsZero() {
+			set[names.Created] = createdAt
+		}
+		if sessDoc.ExpiresAt.IsZero() {
+			set[names.ExpiresAt] = mstore.expiresAt(createdAt, sessDoc.Modified, mstore.maxAge(sessDoc.Name))
+		}
+		// The conditions keep a concurrent Save from being overwritten.
+		res, err := mstore.updateOne(ctx, mstore.coll, bson.M{"_id": sessDoc.ID, "$or": outdated, names.Modified: sessDoc.Modified}, bson.M{"$set": set})
+		if err != nil {
+			return fmt.Errorf("mongodbstore: error upgrading session document: %w", err)
+		}
+		if res.ModifiedCount == 0 {
+			// A concurrent Save or Compact got there first.
+			return nil
+		}
+
+		done++
+		if progress != nil {
+			progress(done)
+		}
+		return nil
+	})
+
+	return done, err
+}
@@ -0,0 +1,100 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCompact(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+	modified := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err = coll.InsertOne(ctx, bson.M{"data": "data", "modified": modified}); err != nil {
+			t.Fatalf("Error inserting legacy session: %v", err)
+		}
+	}
+	// A version 1 document without an ObjectID.
+	if _, err = coll.InsertOne(ctx, bson.M{"_id": "legacy", "data": "data", "modified": modified, "schema_version": 1}); err != nil {
+		t.Fatalf("Error inserting legacy session: %v", err)
+	}
+
+	var reported []int
+	done, err := store.CompactWithProgress(ctx, func(done int) { reported = append(reported, done) })
+	if err != nil {
+		t.Fatalf("Error compacting sessions: %v", err)
+	}
+	if done != 3 || len(reported) != 3 || reported[2] != 3 {
+		t.Errorf("Expected 3 rewritten sessions; Got %d, progress %v", done, reported)
+	}
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("Error listing sessions: %v", err)
+	}
+	var docs []sessionDoc
+	if err = cursor.All(ctx, &docs); err != nil {
+		t.Fatalf("Error decoding sessions: %v", err)
+	}
+	wantExpiresAt := modified.Add(time.Duration(store.options.MaxAge) * time.Second)
+	for _, doc := range docs {
+		if doc.SchemaVersion != schemaVersion {
+			t.Errorf("Expected schema version %d; Got %d", schemaVersion, doc.SchemaVersion)
+		}
+		if !doc.ExpiresAt.Equal(wantExpiresAt) {
+			t.Errorf("Expected expires_at %v; Got %v", wantExpiresAt, doc.ExpiresAt)
+		}
+		if !doc.Created.Equal(created(&sessionDoc{ID: doc.ID, Modified: modified})) {
+			t.Errorf("Expected created to be backfilled; Got %v", doc.Created)
+		}
+	}
+	var legacy sessionDoc
+	if err = coll.FindOne(ctx, bson.M{"_id": "legacy"}).Decode(&legacy); err != nil || !legacy.Created.Equal(modified) {
+		t.Errorf("Expected created backfilled from modified; Got %v, %v", legacy.Created, err)
+	}
+
+	if done, err = store.Compact(ctx); err != nil || done != 0 {
+		t.Errorf("Expected second Compact to rewrite nothing; Got %d, %v", done, err)
+	}
+}
+
+func TestCompactFieldNames(t *testing.T) {
+	cfg := defaultConfig
+	cfg.FieldNames = customFieldNames
+	cfg.FieldNames.SchemaVersion = "layout"
+	cfg.PerNameMaxAge = map[string]int{"flash": 60}
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	ctx := context.Background()
+	modified := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	seedMemorySessions(t, store, 1, time.Hour, bson.M{"updatedAt": modified, "name": "flash", "layout": 1})
+
+	if done, err := store.Compact(ctx); err != nil || done != 1 {
+		t.Fatalf("Expected 1 rewritten session; Got %d, %v", done, err)
+	}
+	raw, err := store.ops.findOne(ctx, store.coll, bson.M{})
+	if err != nil {
+		t.Fatalf("Error reading session: %v", err)
+	}
+	if version, ok := raw.Lookup("layout").Int32OK(); !ok || version != schemaVersion {
+		t.Errorf("Expected layout %d; Got %v", schemaVersion, raw.Lookup("layout"))
+	}
+	if _, err := raw.LookupErr("schema_version"); err == nil {
+		t.Errorf("Expected no schema_version field; Got %v", raw)
+	}
+	if expiresAt := raw.Lookup("expiresAt").Time(); !expiresAt.Equal(modified.Add(time.Minute)) {
+		t.Errorf("Expected the flash MaxAge to set expiresAt %v; Got %v", modified.Add(time.Minute), expiresAt)
+	}
+	if done, err := store.Compact(ctx); err != nil || done != 0 {
+		t.Errorf("Expected second Compact to rewrite nothing; Got %d, %v", done, err)
+	}
+}
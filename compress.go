@@ -0,0 +1,105 @@
+package mongodbstoregorilla
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Compression configures the compression of stored session data.
+type Compression struct {
+	// compresses the data, nil for GzipCompressor
+	Compressor Compressor
+	// size in bytes of the serialized values below which they are stored
+	// uncompressed
+	MinSize int
+}
+
+// Compressor compresses session data. Its name is stored with every
+// compressed document, so it must not change.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor compresses with compress/gzip at Level, or the default
+// level when 0.
+type GzipCompressor struct {
+	Level int
+}
+
+// Name implements Compressor.
+func (GzipCompressor) Name() string {
+	return "gzip"
+}
+
+// Compress implements Compressor.
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements Compressor.
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (c *Compression) compressor() Compressor {
+	if c.Compressor == nil {
+		return GzipCompressor{}
+	}
+	return c.Compressor
+}
+
+// lookup returns the compressor for data compressed with the named
+// algorithm. gzip data stays readable after switching to another one.
+func (c *Compression) lookup(name string) Compressor {
+	if c != nil && c.compressor().Name() == name {
+		return c.compressor()
+	}
+	if name == (GzipCompressor{}).Name() {
+		return GzipCompressor{}
+	}
+	return nil
+}
+
+// payloadUpdate returns the update replacing the encoded payload of a
+// document with the one of sessDoc.
+func (names FieldNames) payloadUpdate(sessDoc *sessionDoc) bson.M {
+	set := bson.M{names.Data: sessDoc.Data}
+	if sessDoc.Encrypted != nil {
+		set = bson.M{"encrypted": sessDoc.Encrypted}
+	}
+	if sessDoc.Compression != "" {
+		set["compression"] = sessDoc.Compression
+	}
+	unset := bson.M{}
+	for _, field := range names.unusedPayloadFields(sessDoc) {
+		if _, ok := set[field]; !ok {
+			unset[field] = ""
+		}
+	}
+	return bson.M{"$set": set, "$unset": unset}
+}
@@ -0,0 +1,126 @@
+package mongodbstoregorilla
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compressedConfig() MongoDBStoreConfig {
+	cfg := defaultConfig
+	cfg.Compression = &Compression{MinSize: 256}
+	return cfg
+}
+
+func TestCompression(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStoreWithConfig(coll, compressedConfig(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	large := map[interface{}]interface{}{"blob": strings.Repeat("compressible ", 500)}
+	if got := roundTrip(t, store, large); !reflect.DeepEqual(got, large) {
+		t.Errorf("Expected %v; Got %v", large, got)
+	}
+	sessDoc := readTestDoc(t, coll)
+	if sessDoc.Compression != "gzip" {
+		t.Errorf("Expected a gzip compressed document; Got %q", sessDoc.Compression)
+	}
+	plain, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	uncompressed := &sessionDoc{}
+	if err = plain.encodeValues("session-key", large, uncompressed); err != nil {
+		t.Fatalf("Error encoding values: %v", err)
+	}
+	if len(sessDoc.Data) >= len(uncompressed.Data) {
+		t.Errorf("Expected compressed data smaller than %d bytes; Got %d", len(uncompressed.Data), len(sessDoc.Data))
+	}
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStoreWithConfig(coll, compressedConfig(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	small := map[interface{}]interface{}{"foo": "bar"}
+	if got := roundTrip(t, store, small); !reflect.DeepEqual(got, small) {
+		t.Errorf("Expected %v; Got %v", small, got)
+	}
+	if sessDoc := readTestDoc(t, coll); sessDoc.Compression != "" {
+		t.Errorf("Expected small data to be stored uncompressed; Got %q", sessDoc.Compression)
+	}
+}
+
+func TestCompressionLegacyDocument(t *testing.T) {
+	coll := newTestCollection(t)
+	legacy, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := legacy.New(req, "session-key")
+	session.Values["blob"] = strings.Repeat("legacy ", 500)
+	if err = legacy.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	store, err := NewMongoDBStoreWithConfig(coll, compressedConfig(), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || !reflect.DeepEqual(loaded.Values, session.Values) {
+		t.Fatalf("Expected the uncompressed document to load; Got %v, %v", loaded.Values, err)
+	}
+
+	// Saving again compresses it.
+	if err = store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if sessDoc := readTestDoc(t, coll); sessDoc.Compression != "gzip" {
+		t.Errorf("Expected the document to be compressed on Save; Got %q", sessDoc.Compression)
+	}
+}
+
+func benchmarkSave(b *testing.B, cfg MongoDBStoreConfig) {
+	coll := newTestCollection(b)
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		b.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values = benchmarkValues(500)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			b.Fatalf("Error saving session: %v", err)
+		}
+	}
+	b.StopTimer()
+
+	var sessDoc sessionDoc
+	if err = coll.FindOne(req.Context(), map[string]interface{}{}).Decode(&sessDoc); err != nil {
+		b.Fatalf("Error reading session document: %v", err)
+	}
+	b.ReportMetric(float64(len(sessDoc.Data)), "data-bytes")
+}
+
+func BenchmarkSaveUncompressed(b *testing.B) {
+	benchmarkSave(b, defaultConfig)
+}
+
+func BenchmarkSaveCompressed(b *testing.B) {
+	benchmarkSave(b, compressedConfig())
+}
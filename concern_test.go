@@ -0,0 +1,82 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+func TestConcerns(t *testing.T) {
+	var mu sync.Mutex
+	commands := make(map[string]bson.Raw)
+	monitor := &event.CommandMonitor{Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+		mu.Lock()
+		commands[evt.CommandName] = evt.Command
+		mu.Unlock()
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetMonitor(monitor).SetServerSelectionTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Error connecting to mongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+	if err = client.Ping(ctx, nil); err != nil {
+		t.Skipf("mongoDB is not available: %v", err)
+	}
+	coll := client.Database("test").Collection("mongodbstore_" + t.Name())
+	defer coll.Drop(context.Background())
+
+	cfg := defaultConfig
+	cfg.IndexTTL = false
+	cfg.ReadPreference = readpref.Primary()
+	cfg.ReadConcern = readconcern.Majority()
+	cfg.WriteConcern = writeconcern.New(writeconcern.WMajority())
+	cfg.LoadReadPreference = readpref.SecondaryPreferred()
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if session, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if err = store.Delete(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if w, err := commands["update"].LookupErr("writeConcern", "w"); err != nil || w.StringValue() != "majority" {
+		t.Errorf("Expected majority write concern on update; Got %v", commands["update"])
+	}
+	if w, err := commands["delete"].LookupErr("writeConcern", "w"); err != nil || w.StringValue() != "majority" {
+		t.Errorf("Expected majority write concern on delete; Got %v", commands["delete"])
+	}
+	if level, err := commands["find"].LookupErr("readConcern", "level"); err != nil || level.StringValue() != "majority" {
+		t.Errorf("Expected majority read concern on find; Got %v", commands["find"])
+	}
+	// The driver only sends $readPreference to mongos and replica sets, so
+	// for a standalone server check that loads use their own collection.
+	if store.loadColl == store.coll {
+		t.Error("Expected a separate collection for loading sessions")
+	}
+}
@@ -0,0 +1,42 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultDetachedTimeout bounds the mongoDB operations of a Save whose
+// request context is already done when OperationTimeout is not set.
+const DefaultDetachedTimeout = 10 * time.Second
+
+// detachedContext keeps the values of a context, but not its deadline or
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// saveContext derives the context of the mongoDB operations of Save from the
+// request context ctx. Save often runs in middleware after the handler
+// returned and the client went away; its write then goes ahead in a context
+// detached from ctx, bounded by OperationTimeout or DefaultDetachedTimeout.
+func (mstore *MongoDBStore) saveContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return mstore.operationContext(ctx)
+	}
+	mstore.logger.Debug("mongodbstore: request context done, saving in a detached context", "op", "save", "error", ctx.Err())
+	timeout := mstore.operationTimeout
+	if timeout <= 0 {
+		timeout = DefaultDetachedTimeout
+	}
+	ctx, cancel := mstore.operationContext(detachedContext{ctx})
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, timeout)
+	return timeoutCtx, func() {
+		timeoutCancel()
+		cancel()
+	}
+}
@@ -0,0 +1,125 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCancelledRequestContext(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req = req.WithContext(ctx)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if _, err = store.New(req, "session-key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected FindOne to fail with context.Canceled; Got %v", err)
+	}
+
+	// Save runs detached from a request context that is done already.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req = req.WithContext(ctx)
+	session, _ = store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected Save to fall back to a detached context; Got %v", err)
+	}
+	count, err := coll.CountDocuments(context.Background(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 sessions; Got %d", count)
+	}
+}
+
+// cancellingOps cancels the request context when the update starts, like a
+// client disconnecting during Save.
+type cancellingOps struct {
+	driverOps
+	cancel context.CancelFunc
+}
+
+func (ops cancellingOps) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ops.cancel()
+	return ops.driverOps.updateOne(ctx, coll, filter, update, opts...)
+}
+
+func TestCancelDuringSave(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.ops = cancellingOps{cancel: cancel}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req = req.WithContext(ctx)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected UpdateOne to fail with context.Canceled; Got %v", err)
+	}
+	if count, _ := coll.CountDocuments(context.Background(), map[string]interface{}{}); count != 0 {
+		t.Errorf("Expected the cancelled Save to write nothing; Got %d sessions", count)
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OperationTimeout = time.Nanosecond
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded; Got %v", err)
+	}
+}
+
+func TestOperationTimeoutRequestDeadline(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OperationTimeout = time.Nanosecond
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req = req.WithContext(ctx)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected the request deadline to replace OperationTimeout; Got %v", err)
+	}
+}
+
+func TestNewMongoDBStoreWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewMongoDBStoreWithContext(ctx, newTestCollection(t), defaultConfig, []byte("secret"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected index creation to fail with context.Canceled; Got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// DegradedMode defines how New and Save behave while mongoDB is unavailable.
+type DegradedMode int
+
+const (
+	// FailClosed returns the StorageError from New, so that requests with a
+	// session cookie fail while the database is down.
+	FailClosed DegradedMode = iota
+
+	// FailOpenNew makes New return a new empty session instead of a
+	// StorageError, e.g. to serve those requests as anonymous. The error is
+	// reported to the Logger and Instrumenter.
+	FailOpenNew
+
+	// FailOpenStale is FailOpenNew with ServeStaleOnError: New serves the
+	// session from the cache, as long as it was cached within
+	// CacheConfig.MaxStaleness and has not expired, and a new session
+	// otherwise. It requires Cache to be enabled.
+	FailOpenStale
+)
+
+// ErrStorageUnavailable is returned by Save in the fail-open DegradedModes
+// when the session could not be stored, so that handlers can tell an outage
+// from other errors, e.g. to retry. The error also matches ErrStorage and
+// unwraps to the StorageError.
+var ErrStorageUnavailable = errors.New("mongodbstore: storage unavailable")
+
+type storageUnavailableError struct {
+	err *StorageError
+}
+
+func (e *storageUnavailableError) Error() string {
+	return ErrStorageUnavailable.Error() + ": " + e.err.Op + ": " + e.err.Err.Error()
+}
+
+func (e *storageUnavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *storageUnavailableError) Is(target error) bool {
+	return target == ErrStorageUnavailable
+}
+
+// saveError turns the StorageError of a Save into ErrStorageUnavailable in
+// the fail-open modes.
+func (mstore *MongoDBStore) saveError(err error) error {
+	var storageErr *StorageError
+	if mstore.degradedMode == FailClosed || !errors.As(err, &storageErr) {
+		return err
+	}
+	return &storageUnavailableError{storageErr}
+}
+
+// degrades reports whether New recovers from a StorageError, with the
+// fail-open modes or ServeStaleOnError.
+func (mstore *MongoDBStore) degrades() bool {
+	return mstore.degradedMode != FailClosed || mstore.serveStaleOnError
+}
+
+// loadDegraded handles the error err of loading session when New degrades.
+// It returns the document to serve, nil for a new session, or the error
+// when it is not a StorageError or there is nothing to serve with
+// FailClosed.
+func (mstore *MongoDBStore) loadDegraded(ctx context.Context, session *sessions.Session, err error) (*sessionDoc, error) {
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) {
+		return nil, err
+	}
+	if mstore.serveStaleOnError {
+		tenant, _ := mstore.tenant(ctx)
+		sessDoc := mstore.cache.stale(mstore.cacheKey(ctx, session.ID), tenant)
+		if sessDoc != nil && !sessDoc.Pending && !mstore.expired(sessDoc, session.Options.MaxAge, time.Now()) {
+			if mstore.loadValues(session.Name(), sessDoc, &session.Values) == nil {
+				mstore.logger.Warn("mongodbstore: storage unavailable, serving cached session", "op", "load", "session", logID(session.ID), "error", err)
+				sessDoc.servedStale = true
+				return sessDoc, nil
+			}
+			session.Values = make(map[interface{}]interface{})
+		}
+	}
+	if mstore.degradedMode == FailClosed {
+		return nil, err
+	}
+	mstore.logger.Warn("mongodbstore: storage unavailable, serving a new session", "op", "load", "session", logID(session.ID), "error", err)
+	// A new ID keeps Save from overwriting the stored session.
+	session.ID = ""
+
+	return nil, nil
+}
+
+// firstError returns the first of errs that is not nil.
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newDownCollection returns a collection on a server that refuses
+// connections.
+func newDownCollection(t *testing.T) *mongo.Collection {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://"+addr).SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+
+	return client.Database("test").Collection("down")
+}
+
+// saveTestSession saves a session with a value and returns its cookie.
+func saveTestSession(t *testing.T, store *MongoDBStore) string {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	return resp.Header().Get("Set-Cookie")
+}
+
+// goDown points store at a server that refuses connections.
+func goDown(t *testing.T, store *MongoDBStore) {
+	store.coll = newDownCollection(t)
+	store.loadColl = store.coll
+}
+
+func TestDegradedModes(t *testing.T) {
+	for name, mode := range map[string]DegradedMode{"FailClosed": FailClosed, "FailOpenNew": FailOpenNew} {
+		wantErr := mode == FailClosed
+		t.Run(name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			cfg := defaultConfig
+			cfg.DegradedMode = mode
+			cfg.Logger = logger
+			store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+			if err != nil {
+				t.Fatalf("Error initializing mongodb store: %v", err)
+			}
+			cookie := saveTestSession(t, store)
+			goDown(t, store)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			req.Header.Add("Cookie", cookie)
+			session, err := store.New(req, "session-key")
+			if gotErr := errors.Is(err, ErrStorage); gotErr != wantErr {
+				t.Errorf("Expected storage error %v; Got %v", wantErr, err)
+			}
+			if !session.IsNew || len(session.Values) != 0 {
+				t.Errorf("Expected a new empty session; Got %+v", session)
+			}
+			if !wantErr && (session.ID != "" || logger.find("warn", "load") == nil) {
+				t.Errorf("Expected a new ID and a warning; Got ID %q", session.ID)
+			}
+
+			err = store.Save(req, httptest.NewRecorder(), session)
+			if !errors.Is(err, ErrStorage) || errors.Is(err, ErrStorageUnavailable) == wantErr {
+				t.Errorf("Unexpected Save error %v", err)
+			}
+		})
+	}
+}
+
+func TestFailOpenStale(t *testing.T) {
+	cfg := defaultConfig
+	cfg.DegradedMode = FailOpenStale
+	cfg.Cache = CacheConfig{Enabled: true, TTL: time.Millisecond}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cached := saveTestSession(t, store)
+	uncached := saveTestSession(t, store)
+	store.cache.invalidate(store.cache.lru.Front().Value.(*cacheEntry).id)
+	goDown(t, store)
+	// Past the cache TTL, but still served during the outage.
+	time.Sleep(5 * time.Millisecond)
+
+	load := func(cookie string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return req
+	}
+	req := load(cached)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["user"] != "alice" {
+		t.Errorf("Expected the cached session; Got %+v, %v", session, err)
+	}
+	var storageErr *StorageError
+	err = store.Save(req, httptest.NewRecorder(), session)
+	if !errors.Is(err, ErrStorageUnavailable) || !errors.As(err, &storageErr) {
+		t.Errorf("Expected ErrStorageUnavailable wrapping a StorageError; Got %v", err)
+	}
+
+	if session, err = store.New(load(uncached), "session-key"); err != nil || !session.IsNew {
+		t.Errorf("Expected a new session when not cached; Got %+v, %v", session, err)
+	}
+}
+
+func TestServeStaleOnError(t *testing.T) {
+	cfg := defaultConfig
+	cfg.ServeStaleOnError = true
+	cfg.Cache = CacheConfig{Enabled: true, TTL: time.Millisecond}
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	cached := saveTestSession(t, store)
+	uncached := saveTestSession(t, store)
+	store.cache.invalidate(store.cache.lru.Front().Value.(*cacheEntry).id)
+	store.ops = downOps{store.ops.(*memoryOps)}
+	time.Sleep(5 * time.Millisecond)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cached)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["user"] != "alice" {
+		t.Fatalf("Expected the cached session; Got %+v, %v", session, err)
+	}
+	if meta, ok := store.SessionMeta(req, "session-key"); !ok || !meta.Stale {
+		t.Errorf("Expected the session to be marked stale; Got %+v", meta)
+	}
+
+	// Other sessions still fail closed.
+	var storageErr *StorageError
+	if _, err = loadWithCookie(store, uncached); !errors.As(err, &storageErr) {
+		t.Errorf("Expected a StorageError when not cached; Got %v", err)
+	}
+}
+
+// downOps fails every load like an unreachable server.
+type downOps struct {
+	*memoryOps
+}
+
+func (downOps) findOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (bson.Raw, error) {
+	return nil, errNetwork
+}
+
+func TestFailOpenStaleMaxStaleness(t *testing.T) {
+	cfg := defaultConfig
+	cfg.DegradedMode = FailOpenStale
+	cfg.Cache = CacheConfig{Enabled: true, TTL: time.Millisecond, MaxStaleness: 100 * time.Millisecond}
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	store.ops = downOps{store.ops.(*memoryOps)}
+	time.Sleep(5 * time.Millisecond)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["user"] != "alice" {
+		t.Fatalf("Expected the cached session; Got %+v, %v", session, err)
+	}
+	if meta, ok := store.SessionMeta(req, "session-key"); !ok || !meta.Stale {
+		t.Errorf("Expected the session to be marked stale; Got %+v", meta)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if session, err = loadWithCookie(store, cookie); err != nil || !session.IsNew {
+		t.Errorf("Expected a new session past MaxStaleness; Got %+v, %v", session, err)
+	}
+}
@@ -0,0 +1,127 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Delete removes session from the store, expires its cookie and resets it
+// to an empty new session. Deleting a session that was never saved or is
+// already gone only expires the cookie.
+func (mstore *MongoDBStore) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID != "" {
+		options := session.Options
+		expired := *options
+		expired.MaxAge = -1
+		session.Options = &expired
+		err := mstore.Save(r, w, session)
+		session.Options = options
+		if err != nil {
+			return err
+		}
+	} else {
+		expired := *session.Options
+		expired.MaxAge = -1
+		if err := mstore.transport.SetToken(w, session.Name(), "", mstore.cookieOptions(r, &expired)); err != nil {
+			return err
+		}
+	}
+	session.ID, session.IsNew = "", true
+	session.Values = make(map[interface{}]interface{})
+
+	return nil
+}
+
+// DeleteByID removes the session with the given ID, e.g. to revoke it from
+// an admin interface. It returns ErrSessionNotFound when there is no such
+// session. With DryRun it only checks that the session exists.
+//
+// A request that loaded the session before it was deleted can not bring it
+// back: its Save returns ErrSessionNotFound instead.
+func (mstore *MongoDBStore) DeleteByID(ctx context.Context, sessionID string) (err error) {
+	if mstore.instrumenter != nil {
+		start := time.Now()
+		defer func() { mstore.instrumenter.ObserveDelete(time.Since(start), err) }()
+	}
+	if mstore.tracer != nil {
+		var span Span
+		ctx, span = mstore.startSpan(ctx, OpDelete, "delete")
+		setSessionID(span, sessionID)
+		defer func() { span.End(err) }()
+	}
+	ID, err := mstore.docID(sessionID)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	filter, err := mstore.scopeFilter(ctx, bson.M{"_id": ID})
+	if err != nil {
+		return err
+	}
+	deleted, err := mstore.deleteMany(ctx, filter)
+	if err != nil {
+		return &StorageError{"error deleting session", err}
+	}
+	if deleted == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// DeleteMany removes all sessions matching filter and returns how many were
+// removed, e.g. {"user_id": id} to log a user out everywhere. With DryRun it
+// only counts them.
+func (mstore *MongoDBStore) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	scoped := bson.M{}
+	for key, val := range filter {
+		scoped[key] = val
+	}
+	scoped, err := mstore.scopeFilter(ctx, scoped)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := mstore.deleteMany(ctx, scoped)
+	if err != nil {
+		return 0, &StorageError{"error deleting sessions", err}
+	}
+
+	return deleted, nil
+}
+
+// AllSessions is the filter that makes DeleteWhere delete every session.
+var AllSessions = bson.M{"_id": bson.M{"$exists": true}}
+
+// DeleteWhere removes all sessions matching filter and returns how many were
+// removed, e.g. to invalidate the sessions carrying a flag after an
+// incident. With DryRun it only counts them.
+//
+// Unlike DeleteMany, filter refers to the fields by their default names,
+// e.g. "modified", which are mapped to the configured FieldNames. An empty
+// filter returns ErrEmptyFilter, so that a missing condition does not wipe
+// the collection; pass AllSessions to delete every session.
+func (mstore *MongoDBStore) DeleteWhere(ctx context.Context, filter bson.M) (int64, error) {
+	if len(filter) == 0 {
+		return 0, ErrEmptyFilter
+	}
+	return mstore.DeleteMany(ctx, mstore.fieldNames.mapFilter(filter))
+}
+
+// DeleteModifiedBefore removes the sessions last modified before t with
+// DeleteWhere.
+func (mstore *MongoDBStore) DeleteModifiedBefore(ctx context.Context, t time.Time) (int64, error) {
+	return mstore.DeleteWhere(ctx, bson.M{DefaultFieldNames.Modified: bson.M{"$lt": t}})
+}
+
+// DeleteCookie adds an expired cookie for session to the response, so that
+// the browser drops it, or clears the token of another Transport. It does
+// not touch the stored session; see Delete.
+func (mstore *MongoDBStore) DeleteCookie(w http.ResponseWriter, session *sessions.Session) {
+	options := *session.Options
+	options.MaxAge = -1
+	mstore.transport.SetToken(w, session.Name(), "", &options)
+}
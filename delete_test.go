@@ -0,0 +1,233 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDelete(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// A concurrent request holding the loaded session.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew {
+		t.Fatalf("Expected the saved session to load; Got err %v", err)
+	}
+
+	ctx := context.Background()
+	if err = store.DeleteByID(ctx, session.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if err = store.DeleteByID(ctx, session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for a deleted session; Got %v", err)
+	}
+	if err = store.DeleteByID(ctx, "not-an-id"); err == nil {
+		t.Error("Expected an error for an invalid session ID")
+	}
+
+	loaded.Values["foo"] = "bar"
+	if err = store.Save(req, httptest.NewRecorder(), loaded); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected Save of a deleted session to fail with ErrSessionNotFound; Got %v", err)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Expected the deleted session to stay deleted; Got %d documents", n)
+	}
+
+	resp = httptest.NewRecorder()
+	store.DeleteCookie(resp, loaded)
+	if cookie := resp.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Expected an expired cookie; Got %q", cookie)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.UserIDKey = "user_id"
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	for _, userID := range []int{1, 1, 2} {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["user_id"] = userID
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	filter := bson.M{"user_id": "1"}
+	store.dryRun = true
+	if deleted, err := store.DeleteMany(ctx, filter); err != nil || deleted != 2 {
+		t.Errorf("Expected 2 sessions counted in dry run; Got %d, %v", deleted, err)
+	}
+	store.dryRun = false
+	if deleted, err := store.DeleteMany(ctx, filter); err != nil || deleted != 2 {
+		t.Errorf("Expected 2 deleted sessions; Got %d, %v", deleted, err)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("Expected 1 remaining session; Got %d", n)
+	}
+	if err = store.DeleteByID(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for an unknown ID; Got %v", err)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	cfg := defaultConfig
+	cfg.FieldNames = customFieldNames
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	seedMemorySessions(t, store, 3, 2*time.Hour, bson.M{"flagged": true})
+	seedMemorySessions(t, store, 2, 2*time.Hour, nil)
+	seedMemorySessions(t, store, 4, time.Minute, nil)
+
+	ctx := context.Background()
+	if _, err = store.DeleteWhere(ctx, bson.M{}); !errors.Is(err, ErrEmptyFilter) {
+		t.Errorf("Expected ErrEmptyFilter; Got %v", err)
+	}
+	if deleted, err := store.DeleteWhere(ctx, bson.M{"flagged": true}); err != nil || deleted != 3 {
+		t.Errorf("Expected 3 flagged sessions deleted; Got %d, %v", deleted, err)
+	}
+	// "modified" refers to the configured updatedAt field.
+	if deleted, err := store.DeleteModifiedBefore(ctx, time.Now().Add(-time.Hour)); err != nil || deleted != 2 {
+		t.Errorf("Expected 2 old sessions deleted; Got %d, %v", deleted, err)
+	}
+	if count := countMemorySessions(t, store); count != 4 {
+		t.Errorf("Expected 4 remaining sessions; Got %d", count)
+	}
+	if deleted, err := store.DeleteWhere(ctx, AllSessions); err != nil || deleted != 4 {
+		t.Errorf("Expected every session deleted; Got %d, %v", deleted, err)
+	}
+}
+
+func TestDeleteByIDRevokes(t *testing.T) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	session, err := loadWithCookie(store, cookie)
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	revoked := session.ID
+	if err = store.DeleteByID(context.Background(), revoked); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+
+	// The revoked cookie must not bring the session back under its ID.
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if session, err = store.New(req, "session-key"); err != nil || !session.IsNew {
+		t.Fatalf("Expected a new session; Got %+v, %v", session, err)
+	}
+	session.Values["user"] = "mallory"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if session.ID == revoked {
+		t.Errorf("Expected a new ID; Got the revoked %s", revoked)
+	}
+	if _, err = store.GetByID(context.Background(), revoked); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the revoked session to stay deleted; Got %v", err)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	savedID := session.ID
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew {
+		t.Fatalf("Expected the saved session to load; Got err %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		resp = httptest.NewRecorder()
+		if err = store.Delete(req, resp, loaded); err != nil {
+			t.Fatalf("Error deleting session: %v", err)
+		}
+		if cookie := resp.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+			t.Errorf("Expected an expired cookie; Got %q", cookie)
+		}
+	}
+	if loaded.ID != "" || !loaded.IsNew || len(loaded.Values) != 0 {
+		t.Errorf("Expected the session to be reset; Got ID %q and values %v", loaded.ID, loaded.Values)
+	}
+	if loaded.Options.MaxAge != store.options.MaxAge {
+		t.Errorf("Expected the session options to be kept; Got MaxAge %d", loaded.Options.MaxAge)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Expected the session document to be deleted; Got %d documents", n)
+	}
+
+	if err = store.DeleteByID(ctx, savedID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+
+	// A session already deleted by ID is not an error.
+	session, _ = store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if err = store.DeleteByID(ctx, session.ID); err != nil {
+		t.Fatalf("Error deleting session by ID: %v", err)
+	}
+	if err = store.Delete(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected deleting a deleted session to succeed; Got %v", err)
+	}
+
+	// A session that was never saved still gets its cookie expired.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp = httptest.NewRecorder()
+	fresh, _ := store.New(req, "session-key")
+	if err = store.Delete(req, resp, fresh); err != nil {
+		t.Fatalf("Error deleting unsaved session: %v", err)
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Expected an expired cookie for an unsaved session; Got %q", cookie)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Expected no session document; Got %d documents", n)
+	}
+}
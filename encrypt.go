@@ -0,0 +1,59 @@
+package mongodbstoregorilla
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var errNoEncryptionKey = errors.New("mongodbstore: session data is encrypted but no encryption keys are configured")
+
+// newAEADs returns the AES-GCM ciphers for keys, which must be 16, 24 or 32
+// bytes long.
+func newAEADs(keys [][]byte) ([]cipher.AEAD, error) {
+	aeads := make([]cipher.AEAD, 0, len(keys))
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("mongodbstore: invalid encryption key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("mongodbstore: invalid encryption key %d: %w", i, err)
+		}
+		aeads = append(aeads, aead)
+	}
+	return aeads, nil
+}
+
+// encrypt seals data with the first encryption key. The random nonce is
+// prepended to the ciphertext, and the session name is authenticated with
+// it, as the codecs do.
+func (mstore *MongoDBStore) encrypt(name string, data []byte) ([]byte, error) {
+	aead := mstore.encryption[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("mongodbstore: error generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, []byte(name)), nil
+}
+
+// decrypt opens data sealed by encrypt with any of the encryption keys, so
+// that data written before a key was prepended stays readable.
+func (mstore *MongoDBStore) decrypt(name string, data []byte) ([]byte, error) {
+	if len(mstore.encryption) == 0 {
+		return nil, errNoEncryptionKey
+	}
+	for _, aead := range mstore.encryption {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, errors.New("mongodbstore: session data can not be decrypted with any encryption key")
+}
@@ -0,0 +1,105 @@
+package mongodbstoregorilla
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	encryptionKey1 = bytes.Repeat([]byte("1"), 32)
+	encryptionKey2 = bytes.Repeat([]byte("2"), 32)
+)
+
+func newEncryptedStore(t *testing.T, coll *mongo.Collection, keys ...[]byte) *MongoDBStore {
+	cfg := defaultConfig
+	cfg.EncryptionKeys = keys
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	return store
+}
+
+func loadWithCookie(store *MongoDBStore, cookie string) (*sessions.Session, error) {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	return store.New(req, "session-key")
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	coll := newTestCollection(t)
+	store := newEncryptedStore(t, coll, encryptionKey1)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	var raw bson.M
+	if err := coll.FindOne(context.Background(), bson.M{}).Decode(&raw); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	if _, ok := raw["encrypted"].(primitive.Binary); !ok || raw["data"] != nil {
+		t.Errorf("Expected the data to be stored as binary only; Got %v", raw)
+	}
+
+	rotated := newEncryptedStore(t, coll, encryptionKey2, encryptionKey1)
+	loaded, err := loadWithCookie(rotated, cookie)
+	if err != nil || loaded.IsNew || !reflect.DeepEqual(loaded.Values, session.Values) {
+		t.Fatalf("Expected data written with the old key to load; Got %v, %v", loaded.Values, err)
+	}
+	if err = rotated.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// Saved again with the new key, the old key alone no longer opens it.
+	if _, err = loadWithCookie(store, cookie); !errors.Is(err, ErrDataDecode) {
+		t.Errorf("Expected ErrDataDecode with the old key only; Got %v", err)
+	}
+	if _, err = loadWithCookie(newEncryptedStore(t, coll, encryptionKey2), cookie); err != nil {
+		t.Errorf("Expected data written with the new key to load; Got %v", err)
+	}
+}
+
+func TestEncryptionTampered(t *testing.T) {
+	coll := newTestCollection(t)
+	store := newEncryptedStore(t, coll, encryptionKey1)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	encrypted := readTestDoc(t, coll).Encrypted
+	encrypted[len(encrypted)-1] ^= 1
+	_, err := coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"encrypted": encrypted}})
+	if err != nil {
+		t.Fatalf("Error tampering with session: %v", err)
+	}
+	if _, err = loadWithCookie(store, resp.Header().Get("Set-Cookie")); !errors.Is(err, ErrDataDecode) {
+		t.Errorf("Expected ErrDataDecode for tampered ciphertext; Got %v", err)
+	}
+}
+
+func TestEncryptionInvalidKey(t *testing.T) {
+	cfg := defaultConfig
+	cfg.EncryptionKeys = [][]byte{[]byte("too short")}
+	if _, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret")); err == nil {
+		t.Error("Expected an error for an invalid encryption key")
+	}
+}
@@ -0,0 +1,113 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/securecookie"
+)
+
+var (
+	// ErrSessionNotFound is returned when no session document exists for an ID.
+	ErrSessionNotFound = errors.New("mongodbstore: session not found")
+
+	// ErrCookieExpired is returned by New when the session cookie is
+	// authentic but its timestamp is older than the codec MaxAge.
+	ErrCookieExpired = errors.New("mongodbstore: session cookie expired")
+
+	// ErrInvalidCookie is returned by New when the session cookie can not be
+	// decoded or does not carry a valid session ID, e.g. because it was
+	// tampered with, truncated or signed with another key.
+	ErrInvalidCookie = errors.New("mongodbstore: invalid session cookie")
+
+	// ErrCookieDecode is ErrInvalidCookie under the name matching
+	// ErrDataDecode.
+	ErrCookieDecode = ErrInvalidCookie
+
+	// ErrDataDecode is returned when the stored document or values of a
+	// session can not be decoded, e.g. because the data was encoded with a
+	// retired key.
+	ErrDataDecode = errors.New("mongodbstore: session data can not be decoded")
+
+	// ErrInvalidModified is returned by Save with ModifiedValue when the
+	// "modified" session value is not a time.Time.
+	ErrInvalidModified = errors.New("mongodbstore: invalid modified value")
+
+	// ErrConcurrentModification is returned by Save with OptimisticLocking
+	// when the session was saved by another request since it was loaded.
+	// Load it again and retry the change.
+	ErrConcurrentModification = errors.New("mongodbstore: session was modified concurrently")
+
+	// ErrSessionLocked is returned by New with Locking when another request
+	// held the lock of the session for longer than the configured Wait.
+	ErrSessionLocked = errors.New("mongodbstore: session is locked")
+
+	// ErrSessionTooLarge matches every SessionTooLargeError with errors.Is.
+	ErrSessionTooLarge = errors.New("mongodbstore: session too large")
+
+	// ErrEmptyFilter is returned by DeleteWhere for an empty filter, which
+	// would delete every session; pass AllSessions for that.
+	ErrEmptyFilter = errors.New("mongodbstore: empty filter")
+
+	// ErrStorage matches every StorageError with errors.Is.
+	ErrStorage = errors.New("mongodbstore: storage error")
+)
+
+// StorageError is returned when a mongoDB operation fails. Err is the
+// error of the driver, so that errors.As finds e.g. a mongo.CommandError.
+type StorageError struct {
+	// what the store was doing, e.g. "error loading session"
+	Op  string
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return "mongodbstore: " + e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns the driver error.
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrStorage.
+func (e *StorageError) Is(target error) bool {
+	return target == ErrStorage
+}
+
+// SessionTooLargeError is returned by Save when the stored payload of a
+// session exceeds MaxLength.
+type SessionTooLargeError struct {
+	// size of the encoded payload in bytes
+	Size int
+	// MaxLength of the store
+	MaxLength int
+}
+
+func (e *SessionTooLargeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes, maximum %d", ErrSessionTooLarge, e.Size, e.MaxLength)
+}
+
+// Is reports whether target is ErrSessionTooLarge.
+func (e *SessionTooLargeError) Is(target error) bool {
+	return target == ErrSessionTooLarge
+}
+
+// securecookie does not export its expired timestamp error, so it is
+// recognised by its message.
+const expiredTimestampMsg = "securecookie: expired timestamp"
+
+// isCookieExpired reports whether err from securecookie.DecodeMulti is caused
+// by an expired timestamp for any of the codecs.
+func isCookieExpired(err error) bool {
+	var multi securecookie.MultiError
+	if !errors.As(err, &multi) {
+		return err != nil && err.Error() == expiredTimestampMsg
+	}
+	for _, e := range multi {
+		if e != nil && e.Error() == expiredTimestampMsg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestErrCookieExpired(t *testing.T) {
+	codecMaxAge := 1
+	cfg := defaultConfig
+	cfg.CodecMaxAge = &codecMaxAge
+	// The second key makes DecodeMulti return a MultiError for several codecs.
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"), nil, []byte("other-secret"), nil)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Result().Cookies()[0]
+
+	tampered := *cookie
+	value := []byte(cookie.Value)
+	if value[5] == 'A' {
+		value[5] = 'B'
+	} else {
+		value[5] = 'A'
+	}
+	tampered.Value = string(value)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.AddCookie(&tampered)
+	if _, err = store.New(req, "session-key"); err == nil || errors.Is(err, ErrCookieExpired) {
+		t.Errorf("Expected tampered cookie error other than ErrCookieExpired; Got %v", err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.AddCookie(cookie)
+	session, err = store.New(req, "session-key")
+	if !errors.Is(err, ErrCookieExpired) {
+		t.Errorf("Expected ErrCookieExpired; Got %v", err)
+	}
+	if !session.IsNew {
+		t.Error("Expected a new session for an expired cookie")
+	}
+}
+
+func TestErrInvalidCookie(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Result().Cookies()[0]
+
+	otherStore, err := NewMongoDBStore(newTestCollection(t), []byte("other-secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// A validly signed cookie whose value is not a session ID.
+	garbage, err := securecookie.EncodeMulti("session-key", "not-an-id", store.getCodecs()...)
+	if err != nil {
+		t.Fatalf("Error encoding cookie: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		store *MongoDBStore
+		value string
+	}{
+		"truncated":  {store, cookie.Value[:len(cookie.Value)/2]},
+		"wrong key":  {otherStore, cookie.Value},
+		"invalid ID": {store, garbage},
+	} {
+		req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.AddCookie(&http.Cookie{Name: "session-key", Value: tc.value})
+		session, err := tc.store.New(req, "session-key")
+		if !errors.Is(err, ErrInvalidCookie) {
+			t.Errorf("%s: expected ErrInvalidCookie; Got %v", name, err)
+		}
+		if session == nil || !session.IsNew || session.ID != "" {
+			t.Errorf("%s: expected a usable new session; Got %+v", name, session)
+			continue
+		}
+		if err = tc.store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Errorf("%s: error saving the new session: %v", name, err)
+		}
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Result().Cookies()[0]
+	load := func(store *MongoDBStore) (*sessions.Session, error) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.AddCookie(cookie)
+		return store.New(req, "session-key")
+	}
+
+	session.Values["modified"] = "yesterday"
+	store.modifiedValue = true
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrInvalidModified) {
+		t.Errorf("Expected ErrInvalidModified; Got %v", err)
+	}
+	store.modifiedValue = false
+
+	ctx := context.Background()
+	if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"data": "garbage"}}); err != nil {
+		t.Fatalf("Error corrupting session: %v", err)
+	}
+	if _, err = load(store); !errors.Is(err, ErrDataDecode) || errors.Is(err, ErrStorage) {
+		t.Errorf("Expected ErrDataDecode; Got %v", err)
+	}
+
+	// A missing document is a new session, not an error.
+	if _, err = coll.DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatalf("Error deleting sessions: %v", err)
+	}
+	if session, err = load(store); err != nil || !session.IsNew {
+		t.Errorf("Expected a new session without error for a missing document; Got %v", err)
+	}
+
+	// A server that can not be reached is a storage error.
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:1").SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	cfg := defaultConfig
+	cfg.IndexTTL = false
+	down, err := NewMongoDBStoreWithConfig(client.Database("test").Collection("down"), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	_, err = load(down)
+	var storageErr *StorageError
+	if !errors.Is(err, ErrStorage) || !errors.As(err, &storageErr) || errors.Is(err, ErrInvalidCookie) {
+		t.Errorf("Expected a StorageError; Got %v", err)
+	}
+	session, _ = down.New(req, "session-key")
+	if err = down.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrStorage) {
+		t.Errorf("Expected ErrStorage from Save; Got %v", err)
+	}
+	if err = down.DeleteByID(ctx, session.ID); !errors.Is(err, ErrStorage) {
+		t.Errorf("Expected ErrStorage from Delete; Got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+
+	t.Run("no documents", func(t *testing.T) {
+		other, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+		if err != nil {
+			t.Fatalf("Error initializing mongodb store: %v", err)
+		}
+		session, err := loadWithCookie(other, cookie)
+		if err != nil || !session.IsNew {
+			t.Errorf("Expected a new session without error; Got %v", err)
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req = req.WithContext(ctx)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if !errors.Is(err, ErrStorage) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected a StorageError wrapping context.Canceled; Got %v", err)
+		}
+		if session == nil || !session.IsNew {
+			t.Errorf("Expected a usable new session; Got %+v", session)
+		}
+	})
+
+	t.Run("decode failure", func(t *testing.T) {
+		if _, err := coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"modified": "yesterday"}}); err != nil {
+			t.Fatalf("Error corrupting session: %v", err)
+		}
+		_, err := loadWithCookie(store, cookie)
+		if !errors.Is(err, ErrDataDecode) || errors.Is(err, ErrStorage) || errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrDataDecode; Got %v", err)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package mongodbstoregorilla
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FieldNames maps the fields of the session document to the names they are
+// stored under, e.g. to share a collection with sessions written by another
+// service.
+type FieldNames struct {
+	// encoded session values
+	Data string
+	// time of the last Save
+	Modified string
+	// time of the first Save
+	Created string
+	// time the session expires
+	ExpiresAt string
+	// version of the document layout, defaults to "schema_version" when
+	// empty
+	SchemaVersion string
+}
+
+// DefaultFieldNames are the field names used when
+// MongoDBStoreConfig.FieldNames is not set.
+var DefaultFieldNames = FieldNames{
+	Data:          "data",
+	Modified:      "modified",
+	Created:       "created",
+	ExpiresAt:     "expires_at",
+	SchemaVersion: "schema_version",
+}
+
+// documentFields are the fields of the session document whose names are
+// fixed.
+var documentFields = map[string]bool{
+	"_id": true, "values": true, "encrypted": true, "compression": true, "version": true, "name": true, "pending": true,
+	"tenant_id": true, "user_id": true, "writer": true,
+}
+
+// renamed returns the default and configured names of the fields that are
+// not stored under their default name.
+func (names FieldNames) renamed() [][2]string {
+	var renamed [][2]string
+	for _, pair := range [][2]string{
+		{DefaultFieldNames.Data, names.Data},
+		{DefaultFieldNames.Modified, names.Modified},
+		{DefaultFieldNames.Created, names.Created},
+		{DefaultFieldNames.ExpiresAt, names.ExpiresAt},
+		{DefaultFieldNames.SchemaVersion, names.SchemaVersion},
+	} {
+		if pair[0] != pair[1] {
+			renamed = append(renamed, pair)
+		}
+	}
+	return renamed
+}
+
+// validate rejects empty, duplicate and reserved field names.
+func (names FieldNames) validate() error {
+	seen := make(map[string]bool)
+	for _, pair := range [][2]string{
+		{"Data", names.Data},
+		{"Modified", names.Modified},
+		{"Created", names.Created},
+		{"ExpiresAt", names.ExpiresAt},
+		{"SchemaVersion", names.SchemaVersion},
+	} {
+		name := pair[1]
+		if name == "" || name[0] == '$' || strings.Contains(name, ".") || documentFields[name] {
+			return fmt.Errorf("mongodbstore: invalid field name %q for %s", name, pair[0])
+		}
+		if seen[name] {
+			return fmt.Errorf("mongodbstore: duplicate field name %q for %s", name, pair[0])
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// storedDoc returns sessDoc with its fields under the configured names, to
+// be written to the collection.
+func (names FieldNames) storedDoc(sessDoc *sessionDoc) (interface{}, error) {
+	renamed := names.renamed()
+	if len(renamed) == 0 {
+		return sessDoc, nil
+	}
+	raw, err := bson.Marshal(sessDoc)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for i, elem := range doc {
+		for _, pair := range renamed {
+			if elem.Key == pair[0] {
+				doc[i].Key = pair[1]
+				break
+			}
+		}
+	}
+	return doc, nil
+}
+
+// decodeDoc decodes a document read from the collection into sessDoc. Fields
+// under the default name of a renamed field are ignored.
+func (names FieldNames) decodeDoc(raw bson.Raw, sessDoc *sessionDoc) error {
+	renamed := names.renamed()
+	if len(renamed) == 0 {
+		return bson.Unmarshal(raw, sessDoc)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	mapped := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		if key, ok := defaultFieldName(renamed, elem.Key); ok {
+			mapped = append(mapped, bson.E{Key: key, Value: elem.Value})
+		}
+	}
+	raw, err := bson.Marshal(mapped)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, sessDoc)
+}
+
+// defaultFieldName returns the default name of the stored field key, and false
+// when key is the default name of a renamed field.
+func defaultFieldName(renamed [][2]string, key string) (string, bool) {
+	for _, pair := range renamed {
+		if key == pair[1] {
+			return pair[0], true
+		}
+	}
+	for _, pair := range renamed {
+		if key == pair[0] {
+			return "", false
+		}
+	}
+	return key, true
+}
+
+// mapFilter returns filter with the default names of the renamed fields
+// replaced by their configured names, also within $and, $or and $nor.
+func (names FieldNames) mapFilter(filter bson.M) bson.M {
+	renamed := names.renamed()
+	mapped := make(bson.M, len(filter))
+	for key, val := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			if clauses, ok := val.(bson.A); ok {
+				mappedClauses := make(bson.A, len(clauses))
+				for i, clause := range clauses {
+					if c, ok := clause.(bson.M); ok {
+						clause = names.mapFilter(c)
+					}
+					mappedClauses[i] = clause
+				}
+				val = mappedClauses
+			}
+		}
+		for _, pair := range renamed {
+			if key == pair[0] {
+				key = pair[1]
+				break
+			}
+		}
+		mapped[key] = val
+	}
+	return mapped
+}
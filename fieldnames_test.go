@@ -0,0 +1,154 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var customFieldNames = FieldNames{
+	Data:      "payload",
+	Modified:  "updatedAt",
+	Created:   "createdAt",
+	ExpiresAt: "expiresAt",
+}
+
+func TestFieldNames(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.FieldNames = customFieldNames
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+
+	var doc bson.M
+	if err = coll.FindOne(context.Background(), bson.M{}).Decode(&doc); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	for _, field := range []string{"payload", "updatedAt", "createdAt", "expiresAt"} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("Expected field %q; Got %v", field, doc)
+		}
+	}
+	for _, field := range []string{"data", "modified", "created", "expires_at"} {
+		if _, ok := doc[field]; ok {
+			t.Errorf("Expected no field %q; Got %v", field, doc)
+		}
+	}
+	if index, ok := listTestIndexes(t, coll)[ttlIndexName]; !ok || index.Key["updatedAt"] == nil {
+		t.Errorf("Expected the TTL index on updatedAt; Got %v", index)
+	}
+
+	session, err := loadWithCookie(store, cookie)
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if session.IsNew || session.Values["user"] != "alice" {
+		t.Errorf("Expected the saved session; Got new %v with %v", session.IsNew, session.Values)
+	}
+
+	// A session written elsewhere, expired and with stray default fields.
+	_, err = coll.InsertOne(context.Background(), bson.M{
+		"_id":       "expired",
+		"payload":   "",
+		"updatedAt": time.Now().Add(-time.Hour),
+		"expiresAt": time.Now().Add(-time.Minute),
+		"modified":  time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Error inserting session document: %v", err)
+	}
+	deleted, err := store.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatalf("Error deleting expired sessions: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 expired session deleted; Got %d", deleted)
+	}
+}
+
+func TestFieldNamesDecode(t *testing.T) {
+	modified := time.Now().Truncate(time.Millisecond).UTC()
+	raw, err := bson.Marshal(bson.M{"_id": "id", "payload": "encoded", "updatedAt": modified, "data": "stray", "name": "session-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessDoc := &sessionDoc{}
+	if err = customFieldNames.decodeDoc(raw, sessDoc); err != nil {
+		t.Fatalf("Error decoding session document: %v", err)
+	}
+	if sessDoc.Data != "encoded" || !sessDoc.Modified.Equal(modified) || sessDoc.Name != "session-key" {
+		t.Errorf("Expected the mapped fields; Got %+v", sessDoc)
+	}
+	if _, ok := sessDoc.Fields["data"]; ok {
+		t.Errorf("Expected the stray data field to be ignored; Got %v", sessDoc.Fields)
+	}
+
+	// Swapped names map both ways.
+	swapped := DefaultFieldNames
+	swapped.Data, swapped.Modified = "modified", "data"
+	stored, err := swapped.storedDoc(&sessionDoc{ID: "id", Data: "encoded", Modified: modified})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err = bson.Marshal(stored); err != nil {
+		t.Fatal(err)
+	}
+	if val := bson.Raw(raw).Lookup("modified").StringValue(); val != "encoded" {
+		t.Errorf("Expected the data under modified; Got %q", val)
+	}
+	sessDoc = &sessionDoc{}
+	if err = swapped.decodeDoc(raw, sessDoc); err != nil || sessDoc.Data != "encoded" || !sessDoc.Modified.Equal(modified) {
+		t.Errorf("Expected the swapped fields to round trip; Got %+v, %v", sessDoc, err)
+	}
+}
+
+func TestFieldNamesValidation(t *testing.T) {
+	tests := map[string]FieldNames{
+		"empty":     {Data: "payload", Modified: "updatedAt", Created: "createdAt"},
+		"duplicate": {Data: "payload", Modified: "updatedAt", Created: "updatedAt", ExpiresAt: "expiresAt"},
+		"reserved":  {Data: "values", Modified: "updatedAt", Created: "createdAt", ExpiresAt: "expiresAt"},
+		"dotted":    {Data: "session.payload", Modified: "updatedAt", Created: "createdAt", ExpiresAt: "expiresAt"},
+		"operator":  {Data: "$payload", Modified: "updatedAt", Created: "createdAt", ExpiresAt: "expiresAt"},
+	}
+	coll := newTestCollection(t)
+	for name, names := range tests {
+		cfg := defaultConfig
+		cfg.FieldNames = names
+		if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err == nil {
+			t.Errorf("%s: Expected an error for %+v", name, names)
+		}
+	}
+
+	cfg := defaultConfig
+	cfg.FieldNames = customFieldNames
+	cfg.IndexedFields = map[string]string{"user": "updatedAt"}
+	if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err == nil {
+		t.Error("Expected an error for an indexed field named like a document field")
+	}
+}
+
+func TestFieldNamesMapFilter(t *testing.T) {
+	filter := bson.M{
+		"modified": bson.M{"$lt": 1},
+		"user_id":  "alice",
+		"$or":      bson.A{bson.M{"created": 2}, bson.M{"expires_at": 3}},
+	}
+	got := customFieldNames.mapFilter(filter)
+	want := bson.M{
+		"updatedAt": bson.M{"$lt": 1},
+		"user_id":   "alice",
+		"$or":       bson.A{bson.M{"createdAt": 2}, bson.M{"expiresAt": 3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v; Got %v", want, got)
+	}
+	if _, ok := filter["updatedAt"]; ok {
+		t.Error("Expected the filter to be left unchanged")
+	}
+}
@@ -0,0 +1,118 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reservedFields are the fields of the session document that IndexedFields
+// can not use.
+var reservedFields = map[string]bool{
+	"_id": true, "data": true, "values": true, "modified": true, "expires_at": true,
+	"schema_version": true, "name": true, "pending": true, "tenant_id": true,
+	"user_id": true, "writer": true,
+}
+
+// validateIndexedFields checks the IndexedFields configuration against the
+// field names of the session document.
+func validateIndexedFields(fields map[string]string, names FieldNames) error {
+	for key, field := range fields {
+		if field == "" || reservedFields[field] || field[0] == '$' ||
+			field == names.Data || field == names.Modified || field == names.Created || field == names.ExpiresAt ||
+			field == names.SchemaVersion {
+			return fmt.Errorf("mongodbstore: invalid field %q for indexed value %q", field, key)
+		}
+	}
+	return nil
+}
+
+// indexedFields returns the IndexedFields values of session, and the
+// configured fields it has no value for.
+func (mstore *MongoDBStore) indexedFields(session *sessions.Session) (bson.M, []string) {
+	if len(mstore.indexedFieldNames) == 0 {
+		return nil, nil
+	}
+	fields := make(bson.M, len(mstore.indexedFieldNames))
+	var missing []string
+	for key, field := range mstore.indexedFieldNames {
+		val, ok := session.Values[key]
+		if !ok || val == nil {
+			missing = append(missing, field)
+			continue
+		}
+		fields[field] = indexedValue(val)
+	}
+
+	return fields, missing
+}
+
+// indexedValue keeps strings and numbers as they are, so that they can be
+// queried with their own type, and converts anything else to a string.
+func indexedValue(val interface{}) interface{} {
+	switch val.(type) {
+	case string, bool, int, int8, int16, int32, int64, uint8, uint16, uint32, float32, float64:
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// FindByField returns the live sessions whose IndexedFields field has the
+// given value, e.g. to count the sessions of a user.
+func (mstore *MongoDBStore) FindByField(ctx context.Context, field string, value interface{}) ([]SessionInfo, error) {
+	filter, err := mstore.fieldFilter(ctx, field, value)
+	if err != nil {
+		return nil, err
+	}
+	filter["pending"] = bson.M{"$ne": true}
+
+	return mstore.find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}), false)
+}
+
+// DeleteByField deletes all sessions whose IndexedFields field has the
+// given value and returns how many were removed, e.g. to sign a user out
+// everywhere. With DryRun it only counts them.
+func (mstore *MongoDBStore) DeleteByField(ctx context.Context, field string, value interface{}) (int64, error) {
+	filter, err := mstore.fieldFilter(ctx, field, value)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := mstore.deleteMany(ctx, filter)
+	if err != nil {
+		return 0, &StorageError{"error deleting sessions", err}
+	}
+
+	return deleted, nil
+}
+
+func (mstore *MongoDBStore) fieldFilter(ctx context.Context, field string, value interface{}) (bson.M, error) {
+	found := false
+	for _, name := range mstore.indexedFieldNames {
+		found = found || name == field
+	}
+	if !found {
+		return nil, fmt.Errorf("mongodbstore: field %q is not in IndexedFields", field)
+	}
+
+	return mstore.scopeFilter(ctx, bson.M{field: indexedValue(value)})
+}
+
+func (mstore *MongoDBStore) ensureFieldIndexes(ctx context.Context) error {
+	models := make([]mongo.IndexModel, 0, len(mstore.indexedFieldNames))
+	for _, field := range mstore.indexedFieldNames {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.M{field: 1},
+			Options: options.Index().SetName(field).SetSparse(true),
+		})
+	}
+	if _, err := mstore.collection(ctx).Indexes().CreateMany(ctx, models); err != nil {
+		return fmt.Errorf("mongodbstore: error ensuring indexed field indexes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexedFields(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.IndexedFields = map[string]string{"uid": "uid", "org": "org_name"}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if _, ok := listTestIndexes(t, coll)["org_name"]; !ok {
+		t.Error("Expected an index on the org_name field")
+	}
+
+	save := func(values map[interface{}]interface{}) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values = values
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp
+	}
+	resp := save(map[interface{}]interface{}{"uid": 42, "org": "acme"})
+	save(map[interface{}]interface{}{"uid": 42})
+	save(map[interface{}]interface{}{"uid": "42"})
+	save(map[interface{}]interface{}{"uid": 7, "org": "acme"})
+
+	ctx := context.Background()
+	if n, _ := coll.CountDocuments(ctx, bson.M{"org_name": bson.M{"$exists": true}}); n != 2 {
+		t.Errorf("Expected missing values to omit the field; Got %d documents with org_name", n)
+	}
+	found, err := store.FindByField(ctx, "uid", 42)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("Expected 2 sessions with the int uid 42; Got %+v, %v", found, err)
+	}
+	if found[0].Fields["org_name"] != "acme" {
+		t.Errorf("Expected the indexed fields in the session info; Got %+v", found[0].Fields)
+	}
+	if found, err = store.FindByField(ctx, "uid", "42"); err != nil || len(found) != 1 {
+		t.Errorf("Expected 1 session with the string uid 42; Got %+v, %v", found, err)
+	}
+	if _, err = store.FindByField(ctx, "data", "x"); err == nil {
+		t.Error("Expected an error for a field that is not indexed")
+	}
+
+	// Removing the value from the session removes the field.
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, _ := store.New(req, "session-key")
+	delete(session.Values, "org")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if found, _ = store.FindByField(ctx, "org_name", "acme"); len(found) != 1 {
+		t.Errorf("Expected 1 session left in org acme; Got %d", len(found))
+	}
+
+	deleted, err := store.DeleteByField(ctx, "uid", 42)
+	if err != nil || deleted != 2 {
+		t.Errorf("Expected 2 deleted sessions; Got %d, %v", deleted, err)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 2 {
+		t.Errorf("Expected 2 remaining sessions; Got %d", n)
+	}
+
+	cfg.IndexedFields = map[string]string{"id": "_id"}
+	if _, err = NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err == nil {
+		t.Error("Expected an error for a reserved field name")
+	}
+}
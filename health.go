@@ -0,0 +1,83 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrTTLIndexMissing is returned by Ping when the store was created
+	// with IndexTTL but the TTL index no longer exists.
+	ErrTTLIndexMissing = errors.New("mongodbstore: TTL index is missing")
+
+	// ErrAuthentication is returned by Ping and ValidateOnStartup when the
+	// server rejects the credentials of the client.
+	ErrAuthentication = errors.New("mongodbstore: authentication failed")
+
+	// ErrUnreachable is returned by Ping and ValidateOnStartup when the
+	// server can not be reached.
+	ErrUnreachable = errors.New("mongodbstore: server unreachable")
+)
+
+// Ping checks that the server can be reached with the read preference used
+// to load sessions, and that the TTL index still exists when IndexTTL was
+// set, e.g. for a readiness probe. It honours the deadline of ctx and costs
+// two round trips at most.
+func (mstore *MongoDBStore) Ping(ctx context.Context) error {
+	if err := mstore.pingServer(ctx); err != nil {
+		return err
+	}
+	if !mstore.indexTTL {
+		return nil
+	}
+
+	cursor, err := mstore.coll.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: error listing indexes: %w", err)
+	}
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return fmt.Errorf("mongodbstore: error listing indexes: %w", err)
+	}
+	name, _, _ := mstore.ttlIndex()
+	for _, index := range indexes {
+		if index.Name == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrTTLIndexMissing, name)
+}
+
+// pingServer pings the server and tells authentication failures from
+// network failures.
+func (mstore *MongoDBStore) pingServer(ctx context.Context) error {
+	err := mstore.coll.Database().Client().Ping(ctx, mstore.pingReadPreference)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("mongodbstore: error pinging server: %w", err)
+	}
+	if isAuthError(err) {
+		return fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	return fmt.Errorf("%w: %v", ErrUnreachable, err)
+}
+
+// isAuthError reports whether err is an authentication failure. The driver
+// reports failed handshakes as connection errors that do not unwrap, so
+// their message is matched.
+func isAuthError(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 18 || cmdErr.Name == "AuthenticationFailed") {
+		return true
+	}
+	return strings.Contains(err.Error(), "auth error")
+}
@@ -0,0 +1,74 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestPing(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+	if err = store.Ping(ctx); err != nil {
+		t.Errorf("Expected a live server to pass; Got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err = store.Ping(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled; Got %v", err)
+	}
+
+	if _, err = coll.Indexes().DropOne(ctx, ttlIndexName); err != nil {
+		t.Fatalf("Error dropping TTL index: %v", err)
+	}
+	if err = store.Ping(ctx); !errors.Is(err, ErrTTLIndexMissing) {
+		t.Errorf("Expected ErrTTLIndexMissing; Got %v", err)
+	}
+}
+
+func TestNewMongoDBStoreFromDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := newTestCollection(t).Database()
+	store, err := NewMongoDBStoreFromDatabase(ctx, db, "", defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	defer db.Collection(DefaultCollectionName).Drop(ctx)
+	if name := store.coll.Name(); name != DefaultCollectionName {
+		t.Errorf("Expected collection %q; Got %q", DefaultCollectionName, name)
+	}
+	if _, ok := listTestIndexes(t, store.coll)[ttlIndexName]; !ok {
+		t.Error("Expected the TTL index to be created")
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:1").SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	_, err = NewMongoDBStoreFromDatabase(ctx, client.Database("test"), "", defaultConfig, []byte("secret"))
+	if !errors.Is(err, ErrUnreachable) || errors.Is(err, ErrAuthentication) {
+		t.Errorf("Expected ErrUnreachable; Got %v", err)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	if !isAuthError(mongo.CommandError{Code: 18, Name: "AuthenticationFailed"}) {
+		t.Error("Expected AuthenticationFailed to be an authentication error")
+	}
+	if !isAuthError(errors.New("connection(localhost:27017[-1]) auth error: sasl conversation error")) {
+		t.Error("Expected a failed handshake to be an authentication error")
+	}
+	if isAuthError(errors.New("server selection error: connection refused")) {
+		t.Error("Expected a network error not to be an authentication error")
+	}
+}
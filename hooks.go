@@ -0,0 +1,18 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionHook is called by the store with a session it loads or saves, e.g.
+// to strip a deprecated value on load or to stamp a value before every save.
+// It may change session.Values and session.Options; an error is returned to
+// the caller of New, Save or SaveAll.
+//
+// ctx carries the values of the request context. A hook runs while the
+// store is loading or saving session, so it must not call New, Save or
+// SaveAll of the same store for the same request, which could deadlock with
+// Locking or save the session twice.
+type SessionHook func(ctx context.Context, session *sessions.Session) error
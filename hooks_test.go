@@ -0,0 +1,112 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestAfterLoad(t *testing.T) {
+	errRejected := errors.New("rejected")
+	cfg := defaultConfig
+	cfg.AfterLoad = func(ctx context.Context, session *sessions.Session) error {
+		if session.Values["banned"] == true {
+			return errRejected
+		}
+		delete(session.Values, "deprecated")
+		return nil
+	}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	save := func(values map[interface{}]interface{}) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values = values
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}
+
+	session, err := loadWithCookie(store, save(map[interface{}]interface{}{"deprecated": 1, "foo": "bar"}))
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if _, ok := session.Values["deprecated"]; ok || session.Values["foo"] != "bar" {
+		t.Errorf("Expected AfterLoad to strip the deprecated value; Got %v", session.Values)
+	}
+
+	if _, err = loadWithCookie(store, save(map[interface{}]interface{}{"banned": true})); !errors.Is(err, errRejected) {
+		t.Errorf("Expected the AfterLoad error from New; Got %v", err)
+	}
+}
+
+func TestBeforeSave(t *testing.T) {
+	errVetoed := errors.New("vetoed")
+	calls := 0
+	cfg := defaultConfig
+	cfg.BeforeSave = func(ctx context.Context, session *sessions.Session) error {
+		calls++
+		if session.Values["veto"] == true {
+			return errVetoed
+		}
+		session.Values["stamped"] = "user-1"
+		return nil
+	}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	loaded, err := loadWithCookie(store, cookie)
+	if err != nil || loaded.Values["stamped"] != "user-1" {
+		t.Errorf("Expected the value stamped by BeforeSave to be stored; Got %v, %v", loaded.Values, err)
+	}
+
+	// A veto leaves the stored session and the cookie alone.
+	loaded.Values["veto"] = true
+	resp = httptest.NewRecorder()
+	if err = store.Save(req, resp, loaded); !errors.Is(err, errVetoed) {
+		t.Errorf("Expected the BeforeSave error from Save; Got %v", err)
+	}
+	if resp.Header().Get("Set-Cookie") != "" {
+		t.Error("Expected no cookie for a vetoed save")
+	}
+	if reloaded, _ := loadWithCookie(store, cookie); reloaded.Values["veto"] != nil {
+		t.Error("Expected a vetoed session not to be stored")
+	}
+
+	// SaveAll reports the veto for its session only.
+	other, _ := store.New(req, "other-key")
+	if err = store.SaveAll(req, httptest.NewRecorder(), loaded, other); !errors.Is(err, errVetoed) {
+		t.Errorf("Expected the BeforeSave error from SaveAll; Got %v", err)
+	}
+	var saveAllErr *SaveAllError
+	if !errors.As(err, &saveAllErr) || len(saveAllErr.Errors) != 1 || saveAllErr.Errors["session-key"] == nil {
+		t.Errorf("Expected the veto of session-key only; Got %v", err)
+	}
+
+	// Deleting does not call the hook.
+	calls = 0
+	loaded.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected BeforeSave not to be called for a delete; Got %d calls", calls)
+	}
+}
@@ -0,0 +1,59 @@
+package mongodbstoregorilla
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDGenerator generates and validates session IDs.
+//
+// The IDs of a custom IDGenerator are stored as string _id values, e.g.
+// UUIDs:
+//
+//	type uuidGenerator struct{}
+//
+//	func (uuidGenerator) NewID() string { return uuid.NewString() }
+//
+//	func (uuidGenerator) Validate(id string) error {
+//		_, err := uuid.Parse(id)
+//		return err
+//	}
+//
+// Without one, sessions get ObjectIDs stored as ObjectID _id values.
+// Switching an existing collection between the two invalidates its sessions.
+type IDGenerator interface {
+	// NewID returns a new unique, unguessable session ID.
+	NewID() string
+	// Validate returns an error if id was not generated by NewID.
+	Validate(id string) error
+}
+
+// newID returns a new session ID and the _id value to store it under.
+func (mstore *MongoDBStore) newID() (string, interface{}) {
+	if mstore.idGenerator == nil {
+		ID := primitive.NewObjectID()
+		return ID.Hex(), ID
+	}
+	ID := mstore.idGenerator.NewID()
+	return ID, ID
+}
+
+// docID returns the _id value of the session with the given ID.
+func (mstore *MongoDBStore) docID(id string) (interface{}, error) {
+	if mstore.idGenerator == nil {
+		return primitive.ObjectIDFromHex(id)
+	}
+	if err := mstore.idGenerator.Validate(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// idString returns the session ID of a stored _id value.
+func idString(ID interface{}) string {
+	if oid, ok := ID.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(ID)
+}
@@ -0,0 +1,81 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// uuidGenerator generates random version 4 UUIDs.
+type uuidGenerator struct{}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func (uuidGenerator) NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (uuidGenerator) Validate(id string) error {
+	if !uuidPattern.MatchString(id) {
+		return errors.New("invalid UUID")
+	}
+	return nil
+}
+
+func TestIDGenerator(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.IDGenerator = uuidGenerator{}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if !uuidPattern.MatchString(session.ID) {
+		t.Errorf("Expected a UUID session ID; Got %q", session.ID)
+	}
+	if n, _ := coll.CountDocuments(context.Background(), bson.M{"_id": bson.M{"$type": "string"}}); n != 1 {
+		t.Errorf("Expected the session stored under a string _id; Got %d documents", n)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.ID != session.ID || loaded.Values["foo"] != "bar" {
+		t.Fatalf("Expected the session to round-trip; Got err %v, session %+v", err, loaded)
+	}
+	loaded.Values["foo"] = "baz"
+	if err = store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if n, _ := coll.CountDocuments(context.Background(), bson.M{}); n != 1 {
+		t.Errorf("Expected the session to be updated in place; Got %d documents", n)
+	}
+
+	if err = store.DeleteByID(context.Background(), "5ee1ea3e3a1b5a5e1f4c7ab2"); err == nil {
+		t.Error("Expected an ObjectID to be rejected by the UUID generator")
+	}
+	if err = store.DeleteByID(context.Background(), session.ID); err != nil {
+		t.Errorf("Error deleting session: %v", err)
+	}
+}
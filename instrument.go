@@ -0,0 +1,93 @@
+package mongodbstoregorilla
+
+import (
+	"expvar"
+	"time"
+)
+
+// Instrumenter observes the session store operations, e.g. to export
+// metrics. Implementations must be safe for concurrent use.
+type Instrumenter interface {
+	// ObserveLoad is called by New for every request carrying a session
+	// cookie. found reports whether a stored session was loaded.
+	ObserveLoad(d time.Duration, found bool, err error)
+	// ObserveSave is called by Save. size is the size in bytes of the
+	// stored values, 0 when nothing was written.
+	ObserveSave(d time.Duration, size int, err error)
+	// ObserveDelete is called by Save for sessions with a negative MaxAge
+	// and by Delete.
+	ObserveDelete(d time.Duration, err error)
+}
+
+// Event is a session lifecycle event counted by an EventInstrumenter.
+type Event string
+
+const (
+	// Save inserted a new session.
+	EventCreated Event = "created"
+	// New found the stored session expired.
+	EventExpiredOnLoad Event = "expired_on_load"
+	// SweepOnce, CleanupBatched or a purge deleted sessions.
+	EventCleanedUp Event = "cleaned_up"
+	// An operation is retried after a transient error, see RetryConfig.
+	EventRetried Event = "retried"
+)
+
+// EventInstrumenter is an Instrumenter that also counts session lifecycle
+// events. The store calls ObserveEvent when its Instrumenter implements it.
+type EventInstrumenter interface {
+	Instrumenter
+	// ObserveEvent is called when event happened to n sessions.
+	ObserveEvent(event Event, n int64)
+}
+
+// observeEvent reports event to the EventInstrumenter, if any.
+func (mstore *MongoDBStore) observeEvent(event Event, n int64) {
+	if mstore.events != nil {
+		mstore.events.ObserveEvent(event, n)
+	}
+}
+
+// ExpvarInstrumenter is an Instrumenter that counts the operations in an
+// expvar.Map, which expvar serves at /debug/vars when published.
+type ExpvarInstrumenter struct {
+	m *expvar.Map
+}
+
+// NewExpvarInstrumenter returns an ExpvarInstrumenter adding to m, e.g.
+// expvar.NewMap("sessions").
+func NewExpvarInstrumenter(m *expvar.Map) *ExpvarInstrumenter {
+	return &ExpvarInstrumenter{m}
+}
+
+// ObserveLoad implements Instrumenter.
+func (i *ExpvarInstrumenter) ObserveLoad(d time.Duration, found bool, err error) {
+	i.count("load", d, err)
+	if found {
+		i.m.Add("load_hits", 1)
+	}
+}
+
+// ObserveSave implements Instrumenter.
+func (i *ExpvarInstrumenter) ObserveSave(d time.Duration, size int, err error) {
+	i.count("save", d, err)
+	i.m.Add("save_bytes", int64(size))
+}
+
+// ObserveDelete implements Instrumenter.
+func (i *ExpvarInstrumenter) ObserveDelete(d time.Duration, err error) {
+	i.count("delete", d, err)
+}
+
+// ObserveEvent implements EventInstrumenter.
+func (i *ExpvarInstrumenter) ObserveEvent(event Event, n int64) {
+	i.m.Add(string(event), n)
+}
+
+func (i *ExpvarInstrumenter) count(op string, d time.Duration, err error) {
+	i.m.Add(op+"s", 1)
+	i.m.AddFloat(op+"_seconds", d.Seconds())
+	if err != nil {
+		i.m.Add(op+"_errors", 1)
+	}
+}
@@ -0,0 +1,213 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	op    string
+	found bool
+	size  int
+	err   error
+}
+
+type recordingInstrumenter struct {
+	mu     sync.Mutex
+	seen   []observation
+	events map[Event]int64
+}
+
+func (i *recordingInstrumenter) record(o observation) {
+	i.mu.Lock()
+	i.seen = append(i.seen, o)
+	i.mu.Unlock()
+}
+
+func (i *recordingInstrumenter) ObserveLoad(d time.Duration, found bool, err error) {
+	i.record(observation{op: "load", found: found, err: err})
+}
+
+func (i *recordingInstrumenter) ObserveSave(d time.Duration, size int, err error) {
+	i.record(observation{op: "save", size: size, err: err})
+}
+
+func (i *recordingInstrumenter) ObserveDelete(d time.Duration, err error) {
+	i.record(observation{op: "delete", err: err})
+}
+
+func (i *recordingInstrumenter) ObserveEvent(event Event, n int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.events == nil {
+		i.events = make(map[Event]int64)
+	}
+	i.events[event] += n
+}
+
+func (i *recordingInstrumenter) last(t *testing.T, op string) observation {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.seen) == 0 || i.seen[len(i.seen)-1].op != op {
+		t.Fatalf("Expected a %s observation; Got %+v", op, i.seen)
+	}
+	return i.seen[len(i.seen)-1]
+}
+
+func TestInstrumenter(t *testing.T) {
+	instrumenter := &recordingInstrumenter{}
+	cfg := defaultConfig
+	cfg.Instrumenter = instrumenter
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	// No cookie, no load.
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if len(instrumenter.seen) != 0 {
+		t.Errorf("Expected no observation without a cookie; Got %+v", instrumenter.seen)
+	}
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if o := instrumenter.last(t, "save"); o.size == 0 || o.err != nil {
+		t.Errorf("Expected a successful save with a size; Got %+v", o)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if session, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if o := instrumenter.last(t, "load"); !o.found || o.err != nil {
+		t.Errorf("Expected a hit; Got %+v", o)
+	}
+
+	// Early return on a garbage cookie.
+	bad, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	bad.AddCookie(&http.Cookie{Name: "session-key", Value: "garbage"})
+	store.New(bad, "session-key")
+	if o := instrumenter.last(t, "load"); o.found || !errors.Is(o.err, ErrInvalidCookie) {
+		t.Errorf("Expected a failed load; Got %+v", o)
+	}
+
+	session.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if o := instrumenter.last(t, "delete"); o.err != nil {
+		t.Errorf("Expected a successful delete; Got %+v", o)
+	}
+	store.DeleteByID(context.Background(), session.ID)
+	if o := instrumenter.last(t, "delete"); !errors.Is(o.err, ErrSessionNotFound) {
+		t.Errorf("Expected a failed delete; Got %+v", o)
+	}
+}
+
+func TestExpvarInstrumenter(t *testing.T) {
+	m := new(expvar.Map).Init()
+	instrumenter := NewExpvarInstrumenter(m)
+	instrumenter.ObserveLoad(time.Millisecond, true, nil)
+	instrumenter.ObserveLoad(time.Millisecond, false, errors.New("down"))
+	instrumenter.ObserveSave(time.Millisecond, 42, nil)
+	instrumenter.ObserveDelete(time.Millisecond, nil)
+	instrumenter.ObserveEvent(EventCleanedUp, 3)
+
+	for key, want := range map[string]string{
+		"loads":       "2",
+		"load_hits":   "1",
+		"load_errors": "1",
+		"saves":       "1",
+		"save_bytes":  "42",
+		"deletes":     "1",
+		"cleaned_up":  "3",
+	} {
+		if got := m.Get(key); got == nil || got.String() != want {
+			t.Errorf("Expected %s = %s; Got %v", key, want, got)
+		}
+	}
+}
+
+func TestEventInstrumenter(t *testing.T) {
+	instrumenter := &recordingInstrumenter{}
+	cfg := defaultConfig
+	cfg.Instrumenter = instrumenter
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Options.MaxAge = 1
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	// Saving it again creates nothing.
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	store.New(req, "session-key")
+	if _, err = store.DeleteExpired(context.Background()); err != nil {
+		t.Fatalf("Error deleting expired sessions: %v", err)
+	}
+
+	for event, want := range map[Event]int64{EventCreated: 1, EventExpiredOnLoad: 1, EventCleanedUp: 1} {
+		if got := instrumenter.events[event]; got != want {
+			t.Errorf("Expected %d %s events; Got %d", want, event, got)
+		}
+	}
+}
+
+func benchmarkInstrumenter(b *testing.B, instrumenter Instrumenter) {
+	cfg := defaultConfig
+	cfg.Instrumenter = instrumenter
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		b.Fatalf("Error initializing memory store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values = benchmarkValues(20)
+	if err = store.Save(req, resp, session); err != nil {
+		b.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			b.Fatalf("Error loading session: %v", err)
+		}
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			b.Fatalf("Error saving session: %v", err)
+		}
+	}
+}
+
+func BenchmarkInstrumenterUnset(b *testing.B) {
+	benchmarkInstrumenter(b, nil)
+}
+
+func BenchmarkInstrumenterExpvar(b *testing.B) {
+	benchmarkInstrumenter(b, NewExpvarInstrumenter(new(expvar.Map).Init()))
+}
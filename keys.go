@@ -0,0 +1,61 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+
+	"github.com/gorilla/securecookie"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SetKeyPairs replaces the key pairs of the store at runtime. The first pair
+// signs and encrypts from now on; the following pairs are only used to
+// decode cookies and documents written with older keys, as with
+// NewMongoDBStore.
+//
+// Sessions stay encoded with the old keys until their next Save, or until
+// they are loaded with ReencodeOnLoad. RotateKeys re-encodes all of them
+// at once.
+func (mstore *MongoDBStore) SetKeyPairs(keyPairs ...[]byte) {
+	codecs, dataCodecs := mstore.newCodecs(keyPairs...)
+
+	mstore.mu.Lock()
+	mstore.codecs, mstore.dataCodecs = codecs, dataCodecs
+	mstore.mu.Unlock()
+}
+
+// decodeTrackingKey decodes the encoded payload of sessDoc like
+// decodeValues and marks the document as stale when only an older key pair
+// could decode it.
+func (mstore *MongoDBStore) decodeTrackingKey(name string, sessDoc *sessionDoc, values *map[interface{}]interface{}) error {
+	codecs := mstore.getDataCodecs()
+	decode := func(codecs []securecookie.Codec) error {
+		return mstore.decodePayload(name, sessDoc, values, codecs)
+	}
+	if len(codecs) < 2 || decode(codecs[:1]) == nil {
+		return decode(codecs)
+	}
+	if err := decode(codecs[1:]); err != nil {
+		return decode(codecs)
+	}
+	sessDoc.stale = true
+
+	return nil
+}
+
+// reencode writes the values of a stale document back encoded with the
+// newest key pair. A session saved in the meantime is left alone.
+func (mstore *MongoDBStore) reencode(ctx context.Context, sessDoc *sessionDoc, values map[interface{}]interface{}) error {
+	updated := &sessionDoc{}
+	if err := mstore.encodeValues(sessDoc.Name, values, updated); err != nil {
+		return err
+	}
+	filter := withTenant(bson.M{"_id": sessDoc.ID, mstore.fieldNames.Data: sessDoc.Data}, sessDoc.TenantID)
+	mstore.cache.invalidate(mstore.cacheKey(ctx, idString(sessDoc.ID)))
+	if _, err := mstore.updateOne(ctx, mstore.collection(ctx), filter, mstore.fieldNames.payloadUpdate(updated)); err != nil {
+		return &StorageError{"error re-encoding session", err}
+	}
+	sessDoc.Data, sessDoc.Encrypted, sessDoc.Compression = updated.Data, updated.Encrypted, updated.Compression
+	sessDoc.stale = false
+
+	return nil
+}
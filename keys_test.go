@@ -0,0 +1,134 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// saveWithKey saves a session with key A and returns its cookie.
+func saveWithKey(t *testing.T, store *MongoDBStore) string {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	return resp.Header().Get("Set-Cookie")
+}
+
+// encodedWith reports whether the stored session is readable with key alone.
+func encodedWith(t *testing.T, coll *mongo.Collection, key []byte) bool {
+	store, err := NewMongoDBStoreWithConfig(coll, MongoDBStoreConfig{SessionOptions: defaultConfig.SessionOptions}, key)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	sess := sessions.NewSession(store, "session-key")
+	sess.ID = idString(readTestDoc(t, coll).ID)
+	found, err := store.load(context.Background(), sess)
+	return err == nil && found && sess.Values["foo"] == "bar"
+}
+
+func TestReencodeOnLoad(t *testing.T) {
+	keyA, keyB := []byte("key-a"), []byte("key-b")
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.ReencodeOnLoad = true
+	cfg.TouchInterval = time.Hour
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, keyA)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveWithKey(t, store)
+
+	store.SetKeyPairs(keyB, nil, keyA, nil)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.Get(req, "session-key")
+	if err != nil || session.IsNew || session.Values["foo"] != "bar" {
+		t.Fatalf("Expected session written with key A to load; Got err %v, IsNew %t", err, session.IsNew)
+	}
+	if encodedWith(t, coll, keyB) {
+		t.Fatal("Expected the session not to be re-encoded before Save")
+	}
+
+	// The unchanged session is still written back, despite TouchInterval.
+	if err = session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if !encodedWith(t, coll, keyB) {
+		t.Error("Expected the session to be re-encoded with key B on Save")
+	}
+}
+
+func TestReencodeImmediately(t *testing.T) {
+	keyA, keyB := []byte("key-a"), []byte("key-b")
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.ReencodeOnLoad = true
+	cfg.ReencodeImmediately = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, keyA)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveWithKey(t, store)
+
+	store.SetKeyPairs(keyB, nil, keyA, nil)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if session, err := store.New(req, "session-key"); err != nil || session.IsNew {
+		t.Fatalf("Expected session written with key A to load; Got err %v", err)
+	}
+	if !encodedWith(t, coll, keyB) {
+		t.Error("Expected the session to be re-encoded with key B on load")
+	}
+}
+
+func TestSetKeyPairs(t *testing.T) {
+	keyA, keyB := []byte("key-a"), []byte("key-b")
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.KeyPairs = [][]byte{keyA}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveWithKey(t, store)
+
+	// After the rotation the old cookie still loads, and Save signs it with
+	// key B.
+	store.SetKeyPairs(keyB, nil, keyA, nil)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected the session signed with key A to load; Got err %v", err)
+	}
+	resp := httptest.NewRecorder()
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	rotated := resp.Result().Cookies()[0]
+	var id string
+	if err = securecookie.DecodeMulti("session-key", rotated.Value, &id, securecookie.CodecsFromPairs(keyB)...); err != nil || id != session.ID {
+		t.Errorf("Expected the cookie to be signed with key B; Got %q, %v", id, err)
+	}
+	if !encodedWith(t, coll, keyB) {
+		t.Error("Expected the session to be re-encoded with key B on Save")
+	}
+
+	// Without key A the old cookie no longer decodes.
+	store.SetKeyPairs(keyB)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if _, err = store.New(req, "session-key"); err == nil {
+		t.Error("Expected an error for a cookie signed with a retired key")
+	}
+}
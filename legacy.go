@@ -0,0 +1,27 @@
+package mongodbstoregorilla
+
+import (
+	"github.com/gorilla/securecookie"
+)
+
+// decodeLegacy decodes data the way kidstuff/mongostore and the first
+// versions of this store wrote it: the values gob encoded, signed and
+// encrypted with the cookie codecs, regardless of Serializer, UnsignedData
+// and compression. Decoded documents are marked legacy so that Save rewrites
+// them in the current format.
+func (mstore *MongoDBStore) decodeLegacy(name string, sessDoc *sessionDoc, values *map[interface{}]interface{}) error {
+	var decoded map[interface{}]interface{}
+	if err := securecookie.DecodeMulti(name, sessDoc.Data, &decoded, mstore.getCodecs()...); err != nil {
+		return err
+	}
+	mergeValues(values, decoded)
+	sessDoc.legacy = true
+
+	return nil
+}
+
+// isLegacy reports whether sessDoc may have been written in a format
+// decodeLegacy reads.
+func isLegacy(sessDoc *sessionDoc) bool {
+	return sessDoc.Data != "" && sessDoc.Values == nil && sessDoc.Encrypted == nil && sessDoc.Compression == ""
+}
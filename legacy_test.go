@@ -0,0 +1,76 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// seedLegacySession inserts a session the way kidstuff/mongostore saves it
+// and returns its cookie.
+func seedLegacySession(t *testing.T, coll *mongo.Collection, values map[interface{}]interface{}) string {
+	codecs := securecookie.CodecsFromPairs([]byte("secret"))
+	data, err := securecookie.EncodeMulti("session-key", values, codecs...)
+	if err != nil {
+		t.Fatalf("Error encoding values: %v", err)
+	}
+	ID := primitive.NewObjectID()
+	_, err = coll.InsertOne(context.Background(), bson.M{"_id": ID, "data": data, "modified": time.Now()})
+	if err != nil {
+		t.Fatalf("Error inserting legacy session: %v", err)
+	}
+	cookie, err := securecookie.EncodeMulti("session-key", ID.Hex(), codecs...)
+	if err != nil {
+		t.Fatalf("Error encoding cookie: %v", err)
+	}
+	return "session-key=" + cookie
+}
+
+func TestLegacyCompat(t *testing.T) {
+	coll := newTestCollection(t)
+	cookie := seedLegacySession(t, coll, map[interface{}]interface{}{"user": "gopher"})
+	cfg := defaultConfig
+	cfg.Serializer = JSONSerializer{}
+	cfg.UnsignedData = true
+	cfg.SkipUnmodified = true
+
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if _, err = loadWithCookie(store, cookie); !errors.Is(err, ErrDataDecode) {
+		t.Fatalf("Expected ErrDataDecode without LegacyCompat; Got %v", err)
+	}
+
+	cfg.LegacyCompat = true
+	store, err = NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["user"] != "gopher" {
+		t.Fatalf("Expected the legacy session to load; Got %v, %v", session.Values, err)
+	}
+
+	// Unchanged, but rewritten in the current format.
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	sessDoc := readTestDoc(t, coll)
+	if sessDoc.SchemaVersion != schemaVersion || sessDoc.Data != `{"user":"gopher"}` {
+		t.Errorf("Expected the document to be upgraded; Got %+v", sessDoc)
+	}
+	if loaded, err := loadWithCookie(store, cookie); err != nil || loaded.Values["user"] != "gopher" {
+		t.Errorf("Expected the upgraded session to load; Got %v, %v", loaded.Values, err)
+	}
+}
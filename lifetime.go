@@ -0,0 +1,121 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultServerSideTTL is how long the documents of browser session cookies
+// are kept when ServerSideTTL is not set.
+const DefaultServerSideTTL = 24 * time.Hour
+
+// retention returns how long in seconds the document of a session with
+// maxAge is kept after its last Save.
+func retention(maxAge int, serverSideTTL time.Duration) int {
+	if maxAge > 0 {
+		return maxAge
+	}
+	return int(serverSideTTL / time.Second)
+}
+
+// largestRetention returns the longest retention of the sessions with
+// maxAge or one of the perName MaxAges.
+func largestRetention(maxAge int, perName map[string]int, serverSideTTL time.Duration) int {
+	longest := retention(maxAge, serverSideTTL)
+	for _, maxAge := range perName {
+		if maxAge := retention(maxAge, serverSideTTL); maxAge > longest {
+			longest = maxAge
+		}
+	}
+	return longest
+}
+
+func (mstore *MongoDBStore) retention(maxAge int) int {
+	return retention(maxAge, mstore.serverSideTTL)
+}
+
+// expiresAt returns when the document of a session with maxAge, created and
+// last modified at the given times, expires.
+func (mstore *MongoDBStore) expiresAt(created, modified time.Time, maxAge int) time.Time {
+	return mstore.capExpiry(created, modified.Add(time.Duration(mstore.retention(maxAge))*time.Second))
+}
+
+// capExpiry moves expiresAt of a session created at the given time back to
+// its AbsoluteMaxAge, so that the TTL index removes it by then.
+func (mstore *MongoDBStore) capExpiry(created, expiresAt time.Time) time.Time {
+	if mstore.absoluteMaxAge > 0 && !created.IsZero() {
+		if limit := created.Add(time.Duration(mstore.absoluteMaxAge) * time.Second); limit.Before(expiresAt) {
+			return limit
+		}
+	}
+	return expiresAt
+}
+
+// expired reports whether sessDoc, of a session with maxAge, has expired at
+// now. The TTL monitor runs only once a minute and may lag further behind,
+// so expired documents can still be found.
+func (mstore *MongoDBStore) expired(sessDoc *sessionDoc, maxAge int, now time.Time) bool {
+	if !sessDoc.ExpiresAt.IsZero() {
+		return sessDoc.ExpiresAt.Before(now)
+	}
+	// Documents saved before expires_at was stored.
+	return sessDoc.Modified.Add(time.Duration(mstore.retention(maxAge)) * time.Second).Before(now)
+}
+
+// deleteExpiredDoc removes the expired sessDoc without waiting for the
+// result. A session saved under its ID in the meantime is left alone.
+func (mstore *MongoDBStore) deleteExpiredDoc(ctx context.Context, sessDoc *sessionDoc) {
+	if mstore.dryRun {
+		return
+	}
+	id := idString(sessDoc.ID)
+	mstore.cache.invalidate(mstore.cacheKey(ctx, id))
+	coll := mstore.collection(ctx)
+	// Cached documents carry the nanoseconds that BSON drops.
+	modified := sessDoc.Modified.Truncate(time.Millisecond)
+	filter := withTenant(bson.M{"_id": sessDoc.ID, mstore.fieldNames.Modified: modified}, sessDoc.TenantID)
+	go func() {
+		ctx, cancel := mstore.operationContext(context.Background())
+		defer cancel()
+		if _, err := mstore.ops.deleteOne(ctx, coll, filter); err != nil {
+			mstore.logger.Warn("mongodbstore: error deleting expired session", "op", "load", "session", logID(id), "error", err)
+		}
+	}()
+}
+
+// absoluteExpired reports whether a session created at the given time is
+// past AbsoluteMaxAge.
+func (mstore *MongoDBStore) absoluteExpired(created time.Time) bool {
+	return !created.IsZero() && time.Since(created) > time.Duration(mstore.absoluteMaxAge)*time.Second
+}
+
+// pastAbsoluteMaxAge reports whether session, loaded during request r, has
+// passed AbsoluteMaxAge since.
+func (mstore *MongoDBStore) pastAbsoluteMaxAge(r *http.Request, session *sessions.Session) bool {
+	if mstore.absoluteMaxAge <= 0 {
+		return false
+	}
+	meta, ok := mstore.SessionMeta(r, session.Name())
+	return ok && mstore.absoluteExpired(meta.Created)
+}
+
+// deleteAbsoluteExpired removes the document of a session past
+// AbsoluteMaxAge, unless the store is in dry-run mode. Failures are only
+// logged; cleanup removes it later.
+func (mstore *MongoDBStore) deleteAbsoluteExpired(ctx context.Context, id, tenant string) {
+	if mstore.dryRun {
+		return
+	}
+	ID, err := mstore.docID(id)
+	if err != nil {
+		return
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, id))
+	if _, err = mstore.ops.deleteOne(ctx, mstore.collection(ctx), withTenant(bson.M{"_id": ID}, tenant)); err != nil {
+		mstore.logger.Warn("mongodbstore: error deleting session past AbsoluteMaxAge", "op", "absolute_max_age", "session", logID(id), "error", err)
+	}
+}
@@ -0,0 +1,275 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAbsoluteMaxAge(t *testing.T) {
+	coll := newTestCollection(t)
+	week := 7 * 24 * time.Hour
+	cfg := defaultConfig
+	cfg.AbsoluteMaxAge = int(week / time.Second)
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	originalID := session.ID
+
+	ctx := context.Background()
+	// age moves the creation of the session back; the session was touched
+	// an hour ago, well within the idle timeout.
+	age := func(d time.Duration) {
+		now := time.Now()
+		set := bson.M{"created": now.Add(-d), "modified": now.Add(-time.Hour)}
+		if _, err := coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": set}); err != nil {
+			t.Fatalf("Error aging session: %v", err)
+		}
+	}
+	load := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		if session, err = store.New(req, "session-key"); err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return req
+	}
+
+	// Active every hour for almost 7 days.
+	age(week - time.Hour)
+	load()
+	if session.IsNew || session.ID != originalID {
+		t.Fatal("Expected the session to be alive before the 7-day mark")
+	}
+
+	// Expired during the request: Save starts over under a new ID.
+	age(week - 500*time.Millisecond)
+	req = load()
+	time.Sleep(600 * time.Millisecond)
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if !session.IsNew || session.ID == originalID {
+		t.Errorf("Expected Save to replace the session past the 7-day mark; Got IsNew %t, ID %s", session.IsNew, session.ID)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("Expected the old session to be deleted; Got %d documents", n)
+	}
+
+	// Expired before the request: New does not load it.
+	cookie = func() string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		if err := store.setCookie(req, resp, session); err != nil {
+			t.Fatalf("Error setting cookie: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}()
+	age(week + time.Minute)
+	load()
+	if !session.IsNew || session.ID != "" {
+		t.Errorf("Expected a new session past the 7-day mark; Got IsNew %t, ID %q", session.IsNew, session.ID)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Expected the expired session to be deleted; Got %d documents", n)
+	}
+}
+
+func TestAbsoluteMaxAgeDryRun(t *testing.T) {
+	cfg := defaultConfig
+	cfg.AbsoluteMaxAge = 3600
+	cfg.DryRun = true
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	ctx := context.Background()
+	set := bson.M{"created": time.Now().Add(-2 * time.Hour)}
+	if _, err = store.ops.updateOne(ctx, store.coll, bson.M{}, bson.M{"$set": set}); err != nil {
+		t.Fatalf("Error aging session: %v", err)
+	}
+
+	session, err := loadWithCookie(store, cookie)
+	if err != nil || !session.IsNew {
+		t.Errorf("Expected a new session past AbsoluteMaxAge; Got IsNew %t, %v", session.IsNew, err)
+	}
+	if count := countMemorySessions(t, store); count != 1 {
+		t.Errorf("Expected DryRun to keep the document; Got %d documents", count)
+	}
+}
+
+func TestAbsoluteMaxAgeDelete(t *testing.T) {
+	cfg := defaultConfig
+	cfg.AbsoluteMaxAge = 1
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	session, err := store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected the saved session; Got IsNew %t, %v", session.IsNew, err)
+	}
+
+	// Passing AbsoluteMaxAge while the request runs must not keep a
+	// deleted session from being removed.
+	time.Sleep(1100 * time.Millisecond)
+	session.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if count := countMemorySessions(t, store); count != 0 {
+		t.Errorf("Expected the session to be deleted; Got %d documents", count)
+	}
+}
+
+func TestExpiredDocument(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 300
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["step_up"] = true
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	load := func() {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		if !session.IsNew || session.ID != "" || len(session.Values) != 0 {
+			t.Errorf("Expected a fresh session for an expired document; Got %+v", session)
+		}
+	}
+
+	// The TTL monitor has not removed the document yet.
+	ctx := context.Background()
+	past := time.Now().Add(-10 * time.Minute)
+	if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"modified": past, "expires_at": past.Add(5 * time.Minute)}}); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+	load()
+	// Documents without expires_at expire MaxAge after modified.
+	if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$unset": bson.M{"expires_at": ""}}); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+	load()
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("Expected the expired document to be left to the TTL monitor; Got %d documents", n)
+	}
+}
+
+func TestDeleteExpiredOnLoad(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.DeleteExpiredOnLoad = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	ctx := context.Background()
+	if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Second)}}); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if session, err = store.New(req, "session-key"); err != nil || !session.IsNew {
+		t.Fatalf("Expected a new session; Got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n, err := coll.CountDocuments(ctx, bson.M{})
+		if err != nil {
+			t.Fatalf("Error counting sessions: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expired document to be deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAbsoluteMaxAgeExpiresAt(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.AbsoluteMaxAge = 3600
+	cfg.IDGenerator = uuidGenerator{}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	// The TTL index removes the session at AbsoluteMaxAge, long before its
+	// idle timeout of 30 days.
+	sessDoc := readTestDoc(t, coll)
+	if got := sessDoc.ExpiresAt.Sub(sessDoc.Created); got != time.Hour {
+		t.Errorf("Expected expires_at an hour after created; Got %v", got)
+	}
+
+	// A document saved before created was recorded counts from its
+	// modified time, and gets it stored by the next Save.
+	ctx := context.Background()
+	modified := time.Now().Add(-30 * time.Minute).Truncate(time.Millisecond)
+	update := bson.M{"$set": bson.M{"modified": modified}, "$unset": bson.M{"created": "", "expires_at": ""}}
+	if _, err = coll.UpdateOne(ctx, bson.M{}, update); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected the session to load; Got err %v", err)
+	}
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	sessDoc = readTestDoc(t, coll)
+	if !sessDoc.Created.Equal(modified) {
+		t.Errorf("Expected created to be backfilled with %v; Got %v", modified, sessDoc.Created)
+	}
+	if want := modified.Add(time.Hour); !sessDoc.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expires_at %v; Got %v", want, sessDoc.ExpiresAt)
+	}
+}
@@ -0,0 +1,117 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultListLimit is the page size of List when ListOptions.Limit is 0.
+const DefaultListLimit = 100
+
+// ListOptions selects the sessions returned by List.
+type ListOptions struct {
+	// maximum number of sessions, DefaultListLimit when 0
+	Limit int64
+	// number of sessions to skip. Prefer After for deep pages.
+	Skip int64
+	// only return sessions with an ID after this one, the ID of the last
+	// session of the previous page
+	After string
+	// only return sessions modified after this time, when set
+	ModifiedAfter time.Time
+	// decode the values of every session with the store codecs
+	DecodeValues bool
+}
+
+// SessionInfo describes a stored session.
+type SessionInfo struct {
+	ID        string
+	Name      string
+	Created   time.Time
+	Modified  time.Time
+	ExpiresAt time.Time
+	UserID    string
+	// IndexedFields values
+	Fields map[string]interface{}
+
+	// decoded values, nil unless ListOptions.DecodeValues is set
+	Values map[interface{}]interface{}
+	// why the values could not be decoded
+	DecodeErr error
+}
+
+// List returns a page of the live sessions ordered by ID, e.g. for an
+// admin interface. Pass the ID of the last session as ListOptions.After
+// to get the next page; an empty page is the end.
+//
+// At most one page of sessions is held in memory.
+func (mstore *MongoDBStore) List(ctx context.Context, opts ListOptions) ([]SessionInfo, error) {
+	filter := bson.M{
+		"pending": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{mstore.fieldNames.ExpiresAt: bson.M{"$exists": false}},
+			bson.M{mstore.fieldNames.ExpiresAt: bson.M{"$gte": time.Now()}},
+		},
+	}
+	if opts.After != "" {
+		after, err := mstore.docID(opts.After)
+		if err != nil {
+			return nil, fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+		}
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	if !opts.ModifiedAfter.IsZero() {
+		filter[mstore.fieldNames.Modified] = bson.M{"$gt": opts.ModifiedAfter}
+	}
+	filter, err := mstore.scopeFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	findOpts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(limit).SetSkip(opts.Skip)
+
+	return mstore.find(ctx, filter, findOpts, opts.DecodeValues)
+}
+
+// find returns the sessions matching filter, with their values if decode
+// is set.
+func (mstore *MongoDBStore) find(ctx context.Context, filter bson.M, findOpts *options.FindOptions, decode bool) ([]SessionInfo, error) {
+	if !decode {
+		findOpts.SetProjection(bson.M{mstore.fieldNames.Data: 0, "values": 0, "encrypted": 0})
+	}
+	docs, err := mstore.findDocs(ctx, mstore.coll, filter, findOpts)
+	if err != nil {
+		return nil, &StorageError{"error listing sessions", err}
+	}
+
+	var list []SessionInfo
+	for _, raw := range docs {
+		sessDoc := &sessionDoc{}
+		if err = mstore.fieldNames.decodeDoc(raw, sessDoc); err != nil {
+			return list, fmt.Errorf("mongodbstore: error decoding session document: %w", err)
+		}
+		info := SessionInfo{
+			ID:        idString(sessDoc.ID),
+			Name:      sessDoc.Name,
+			Created:   created(sessDoc),
+			Modified:  sessDoc.Modified,
+			ExpiresAt: sessDoc.ExpiresAt,
+			UserID:    sessDoc.UserID,
+			Fields:    sessDoc.Fields,
+		}
+		if decode {
+			info.DecodeErr = mstore.loadValues(sessDoc.Name, sessDoc, &info.Values)
+		}
+		list = append(list, info)
+	}
+
+	return list, nil
+}
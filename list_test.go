@@ -0,0 +1,74 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	var ids []string
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["n"] = i
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+	ctx := context.Background()
+	if _, err = store.Create(ctx, "session-key", nil); err != nil {
+		t.Fatalf("Error creating pending session: %v", err)
+	}
+
+	var listed []string
+	opts := ListOptions{Limit: 2, DecodeValues: true}
+	for {
+		page, err := store.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("Error listing sessions: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 2 {
+			t.Fatalf("Expected pages of at most 2 sessions; Got %d", len(page))
+		}
+		for _, info := range page {
+			if info.DecodeErr != nil || info.Values["n"] != len(listed) {
+				t.Errorf("Expected decoded values n=%d; Got %v, %v", len(listed), info.Values, info.DecodeErr)
+			}
+			listed = append(listed, info.ID)
+		}
+		opts.After = page[len(page)-1].ID
+	}
+	if len(listed) != len(ids) {
+		t.Fatalf("Expected the %d saved sessions without the pending one; Got %v", len(ids), listed)
+	}
+	for i := range ids {
+		if listed[i] != ids[i] {
+			t.Errorf("Expected session %d to be %s; Got %s", i, ids[i], listed[i])
+		}
+	}
+
+	page, err := store.List(ctx, ListOptions{Skip: 4})
+	if err != nil || len(page) != 1 || page[0].Values != nil {
+		t.Errorf("Expected 1 session without values after skipping 4; Got %+v, %v", page, err)
+	}
+	if page, err = store.List(ctx, ListOptions{ModifiedAfter: time.Now().Add(time.Hour)}); err != nil || len(page) != 0 {
+		t.Errorf("Expected no session modified in the future; Got %+v, %v", page, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = store.List(cancelled, ListOptions{}); err == nil {
+		t.Error("Expected an error for a cancelled context")
+	}
+}
@@ -0,0 +1,176 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// DefaultLockTimeout is how long a session lock is held at most when
+	// LockingConfig.Timeout is not set.
+	DefaultLockTimeout = 30 * time.Second
+
+	// DefaultLockWait is how long New waits for a held session lock when
+	// LockingConfig.Wait is not set.
+	DefaultLockWait = 5 * time.Second
+)
+
+// lockBackoff and lockMaxBackoff bound the pause between two attempts to
+// take a held lock.
+var (
+	lockBackoff    = 10 * time.Millisecond
+	lockMaxBackoff = 200 * time.Millisecond
+)
+
+// LockingConfig configures locking sessions for the duration of a request,
+// so that concurrent requests of the same client are served one after the
+// other, as with PHP sessions.
+//
+// New locks the session it loads, waiting while another request holds the
+// lock, and Save and SaveAll release it. A request that only reads the
+// session should release it with Unlock; otherwise the lock lasts until
+// Timeout, after which another request takes it over, e.g. from a handler
+// that crashed. Deleting the session releases its lock along with it. Loads
+// bypass the cache, which could hold values from before the last holder
+// saved.
+type LockingConfig struct {
+	// whether to lock sessions
+	Enabled bool
+	// how long a lock is held at most, 0 for DefaultLockTimeout. It should
+	// exceed the time handlers take.
+	Timeout time.Duration
+	// how long New waits for a held lock before failing with
+	// ErrSessionLocked, 0 for DefaultLockWait
+	Wait time.Duration
+}
+
+// withDefaults returns cfg with the defaults of the unset fields.
+func (cfg LockingConfig) withDefaults() LockingConfig {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultLockTimeout
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultLockWait
+	}
+	return cfg
+}
+
+// sessionLock is a lock New took for a session.
+type sessionLock struct {
+	filter bson.M
+	token  string
+}
+
+// lock takes the lock of the session with the ID of session for request r,
+// waiting up to the configured Wait while another request holds it. It does
+// nothing when there is no such session.
+func (mstore *MongoDBStore) lock(ctx context.Context, r *http.Request, session *sessions.Session) error {
+	ID, err := mstore.docID(session.ID)
+	if err != nil {
+		return err
+	}
+	filter, err := mstore.scopeFilter(ctx, bson.M{"_id": ID})
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(securecookie.GenerateRandomKey(16))
+	deadline := time.Now().Add(mstore.locking.Wait)
+	backoff := lockBackoff
+	for {
+		now := time.Now()
+		free := bson.M{"$or": bson.A{
+			bson.M{"lock_until": bson.M{"$exists": false}},
+			bson.M{"lock_until": bson.M{"$lt": now}},
+			// a retried update that did apply
+			bson.M{"lock_token": token},
+		}}
+		for key, val := range filter {
+			free[key] = val
+		}
+		update := bson.M{"$set": bson.M{"lock_token": token, "lock_until": now.Add(mstore.locking.Timeout)}}
+		res, err := mstore.updateOne(ctx, mstore.collection(ctx), free, update)
+		if err != nil {
+			return &StorageError{"error locking session", err}
+		}
+		if res.MatchedCount > 0 {
+			state := getRequestState(r, true)
+			state.mu.Lock()
+			state.locks[session] = sessionLock{filter: filter, token: token}
+			state.mu.Unlock()
+			return nil
+		}
+		count, err := mstore.ops.countDocuments(ctx, mstore.collection(ctx), filter)
+		if err != nil {
+			return &StorageError{"error locking session", err}
+		}
+		if count == 0 {
+			// New finds no session, and Save inserts it unlocked.
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return ErrSessionLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > lockMaxBackoff {
+			backoff = lockMaxBackoff
+		}
+	}
+}
+
+// Unlock releases the lock New took on session with Locking, e.g. in a
+// handler that reads the session without saving it. It does nothing when
+// the request holds no lock on session.
+func (mstore *MongoDBStore) Unlock(r *http.Request, session *sessions.Session) error {
+	ctx, cancel := mstore.operationContext(r.Context())
+	defer cancel()
+	ctx, err := mstore.routeRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	return mstore.unlock(ctx, r, session)
+}
+
+// unlock releases the lock request r holds on session, unless another
+// request took it over since.
+func (mstore *MongoDBStore) unlock(ctx context.Context, r *http.Request, session *sessions.Session) error {
+	state := getRequestState(r, false)
+	if state == nil {
+		return nil
+	}
+	state.mu.Lock()
+	lock, ok := state.locks[session]
+	delete(state.locks, session)
+	state.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	filter := bson.M{"lock_token": lock.token}
+	for key, val := range lock.filter {
+		filter[key] = val
+	}
+	_, err := mstore.updateOne(ctx, mstore.collection(ctx), filter, bson.M{"$unset": bson.M{"lock_token": "", "lock_until": ""}})
+	if err != nil {
+		return &StorageError{"error unlocking session", err}
+	}
+	return nil
+}
+
+// releaseLock unlocks session after Save, where a failure is only logged:
+// the lock expires after Timeout anyway.
+func (mstore *MongoDBStore) releaseLock(ctx context.Context, r *http.Request, session *sessions.Session) {
+	if err := mstore.unlock(ctx, r, session); err != nil {
+		mstore.logger.Warn("mongodbstore: error unlocking session", "op", "save", "session", logID(session.ID), "error", err)
+	}
+}
@@ -0,0 +1,118 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func newLockingStore(t *testing.T, locking LockingConfig) (*MongoDBStore, func() (*http.Request, *sessions.Session, error)) {
+	cfg := defaultConfig
+	cfg.Locking = locking
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["count"] = 0
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	load := func() (*http.Request, *sessions.Session, error) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		return req, session, err
+	}
+	return store, load
+}
+
+func TestLocking(t *testing.T) {
+	store, load := newLockingStore(t, LockingConfig{Enabled: true, Wait: 5 * time.Second})
+
+	// Two requests increment the same counter concurrently.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, session, err := load()
+			if err != nil {
+				errs <- err
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			session.Values["count"] = session.Values["count"].(int) + 1
+			errs <- store.Save(req, httptest.NewRecorder(), session)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Error incrementing counter: %v", err)
+		}
+	}
+	_, session, err := load()
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if got := session.Values["count"]; got != 2 {
+		t.Errorf("Expected the requests to run one after the other; Got count %v", got)
+	}
+}
+
+func TestLockingWait(t *testing.T) {
+	store, load := newLockingStore(t, LockingConfig{Enabled: true, Wait: 50 * time.Millisecond})
+	req, session, err := load()
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+
+	start := time.Now()
+	if _, _, err = load(); !errors.Is(err, ErrSessionLocked) {
+		t.Errorf("Expected ErrSessionLocked while the lock is held; Got %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Expected to wait about 50ms; Waited %v", waited)
+	}
+
+	if err = store.Unlock(req, session); err != nil {
+		t.Fatalf("Error unlocking session: %v", err)
+	}
+	if _, _, err = load(); err != nil {
+		t.Errorf("Expected the session to load after Unlock; Got %v", err)
+	}
+}
+
+func TestLockingExpired(t *testing.T) {
+	store, load := newLockingStore(t, LockingConfig{Enabled: true, Timeout: 50 * time.Millisecond, Wait: 10 * time.Millisecond})
+	crashedReq, crashed, err := load()
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+
+	// The lock of a request that never saves is taken over after Timeout.
+	time.Sleep(100 * time.Millisecond)
+	req, session, err := load()
+	if err != nil {
+		t.Fatalf("Expected an expired lock to be taken over; Got %v", err)
+	}
+
+	// Releasing the lost lock leaves the new one in place.
+	if err = store.Unlock(crashedReq, crashed); err != nil {
+		t.Fatalf("Error unlocking session: %v", err)
+	}
+	if _, _, err = load(); !errors.Is(err, ErrSessionLocked) {
+		t.Errorf("Expected the lock to stay held by the new request; Got %v", err)
+	}
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if _, _, err = load(); err != nil {
+		t.Errorf("Expected Save to release the lock; Got %v", err)
+	}
+}
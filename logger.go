@@ -0,0 +1,96 @@
+package mongodbstoregorilla
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Logger receives structured log entries about conditions the store handles
+// internally, as a message followed by alternating keys and values. It is
+// satisfied by *slog.Logger.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(string, ...interface{}) {}
+func (noopLogger) Warn(string, ...interface{})  {}
+func (noopLogger) Error(string, ...interface{}) {}
+
+// RateLimitedLogger passes each message on to its Logger at most once per
+// interval, e.g. to keep the decode failures of every request during a key
+// rotation from flooding the logs. The first entry after a quiet interval
+// carries the number of entries dropped since as "suppressed".
+type RateLimitedLogger struct {
+	logger   Logger
+	interval time.Duration
+
+	mu       sync.Mutex
+	messages map[string]*limitedMessage
+}
+
+type limitedMessage struct {
+	last       time.Time
+	suppressed int
+}
+
+// NewRateLimitedLogger returns a RateLimitedLogger passing entries on to
+// logger.
+func NewRateLimitedLogger(logger Logger, interval time.Duration) *RateLimitedLogger {
+	return &RateLimitedLogger{logger: logger, interval: interval, messages: make(map[string]*limitedMessage)}
+}
+
+// Debug implements Logger.
+func (l *RateLimitedLogger) Debug(msg string, keysAndValues ...interface{}) {
+	if keysAndValues, ok := l.allow(msg, keysAndValues); ok {
+		l.logger.Debug(msg, keysAndValues...)
+	}
+}
+
+// Warn implements Logger.
+func (l *RateLimitedLogger) Warn(msg string, keysAndValues ...interface{}) {
+	if keysAndValues, ok := l.allow(msg, keysAndValues); ok {
+		l.logger.Warn(msg, keysAndValues...)
+	}
+}
+
+// Error implements Logger.
+func (l *RateLimitedLogger) Error(msg string, keysAndValues ...interface{}) {
+	if keysAndValues, ok := l.allow(msg, keysAndValues); ok {
+		l.logger.Error(msg, keysAndValues...)
+	}
+}
+
+// allow reports whether msg is due, returning keysAndValues with the
+// suppressed count when entries were dropped.
+func (l *RateLimitedLogger) allow(msg string, keysAndValues []interface{}) ([]interface{}, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.messages[msg]
+	if !ok {
+		state = &limitedMessage{}
+		l.messages[msg] = state
+	} else if now.Sub(state.last) < l.interval {
+		state.suppressed++
+		return nil, false
+	}
+	state.last = now
+	if state.suppressed > 0 {
+		keysAndValues = append(keysAndValues[:len(keysAndValues):len(keysAndValues)], "suppressed", state.suppressed)
+		state.suppressed = 0
+	}
+	return keysAndValues, true
+}
+
+// logID returns a short hash of a session ID for log entries, so that logs
+// never carry IDs that could be used to hijack a session.
+func logID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,126 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type logEntry struct {
+	level, msg string
+	fields     map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) log(level, msg string, keysAndValues []interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, logEntry{level, msg, fields})
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) Debug(msg string, kv ...interface{}) { l.log("debug", msg, kv) }
+func (l *recordingLogger) Warn(msg string, kv ...interface{})  { l.log("warn", msg, kv) }
+func (l *recordingLogger) Error(msg string, kv ...interface{}) { l.log("error", msg, kv) }
+
+func (l *recordingLogger) find(level, op string) *logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.entries {
+		if l.entries[i].level == level && l.entries[i].fields["op"] == op {
+			return &l.entries[i]
+		}
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	coll := newTestCollection(t)
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.Logger = logger
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if entry := logger.find("debug", "ensure_ttl_index"); entry == nil || entry.fields["index"] == nil {
+		t.Errorf("Expected a debug entry for the TTL index; Got %+v", logger.entries)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	ctx := context.Background()
+	if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"data": "corrupt"}}); err != nil {
+		t.Fatalf("Error corrupting session: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	store.New(req, "session-key")
+
+	entry := logger.find("warn", "load")
+	if entry == nil {
+		t.Fatalf("Expected a warning for undecodable data; Got %+v", logger.entries)
+	}
+	if entry.fields["session"] != logID(session.ID) || entry.fields["name"] != "session-key" || entry.fields["error"] == nil {
+		t.Errorf("Expected the session name, the hashed session ID and the error; Got %+v", entry.fields)
+	}
+	for _, val := range entry.fields {
+		if strings.Contains(fmt.Sprint(val), session.ID) {
+			t.Errorf("Expected no raw session ID in the log entry; Got %+v", entry.fields)
+		}
+	}
+
+	if _, err = store.SweepOnce(ctx); err != nil {
+		t.Fatalf("Error sweeping sessions: %v", err)
+	}
+	if entry = logger.find("debug", "cleanup"); entry == nil || entry.fields["count"] != int64(0) {
+		t.Errorf("Expected a debug entry with the deleted count; Got %+v", entry)
+	}
+
+	session.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if entry = logger.find("debug", "save"); entry == nil || entry.fields["session"] != logID(session.ID) {
+		t.Errorf("Expected a debug entry for the deleted session; Got %+v", entry)
+	}
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	recorder := &recordingLogger{}
+	logger := NewRateLimitedLogger(recorder, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		logger.Warn("decode failure", "op", "load")
+	}
+	logger.Error("other failure", "op", "save")
+	if len(recorder.entries) != 2 {
+		t.Fatalf("Expected one entry per message; Got %+v", recorder.entries)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	logger.Warn("decode failure", "op", "load")
+	if len(recorder.entries) != 3 {
+		t.Fatalf("Expected the message again after the interval; Got %+v", recorder.entries)
+	}
+	if entry := recorder.entries[2]; entry.fields["suppressed"] != 2 || entry.fields["op"] != "load" {
+		t.Errorf("Expected 2 suppressed entries; Got %+v", entry.fields)
+	}
+}
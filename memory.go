@@ -0,0 +1,519 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemoryStore returns a store that keeps its sessions in memory instead
+// of mongoDB, for tests of handlers that use the store without a server.
+//
+// New, Save, SaveAll, Delete, DeleteByID, Touch, RegenerateID, GetByID,
+// SaveByID, List, Create, Claim, CleanupBatched, PurgeExpired,
+// PurgeOlderThan and DeleteWhere behave as with mongoDB, expiry included.
+// Stats only returns the partial count, methods that index or watch the
+// collection fail, as the store has no connection, and CollectionSelector
+// is not supported.
+func NewMemoryStore(cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
+	if cfg.CollectionSelector != nil {
+		return nil, errors.New("mongodbstore: CollectionSelector is not supported in memory")
+	}
+	// The client is never connected.
+	client, err := mongo.NewClient(options.Client())
+	if err != nil {
+		return nil, err
+	}
+	store, err := newStore(client.Database("memory").Collection(DefaultCollectionName), cfg, keyPairs...)
+	if err != nil {
+		return nil, err
+	}
+	store.ops = &memoryOps{colls: make(map[string]map[string]bson.M)}
+
+	return store, nil
+}
+
+// memoryOps implements the collection operations on documents in memory.
+// Filters support equality, $exists, $ne, $lt, $gt, $in, $and, $or and $nor;
+// updates support $set, $unset, $setOnInsert and $inc. Aggregations fail.
+type memoryOps struct {
+	mu    sync.Mutex
+	colls map[string]map[string]bson.M
+}
+
+// docs returns the documents of coll by _id.
+func (ops *memoryOps) docs(coll *mongo.Collection) map[string]bson.M {
+	ns := coll.Database().Name() + "." + coll.Name()
+	docs, ok := ops.colls[ns]
+	if !ok {
+		docs = make(map[string]bson.M)
+		ops.colls[ns] = docs
+	}
+	return docs
+}
+
+// matching returns the keys of the documents matching filter.
+func (ops *memoryOps) matching(coll *mongo.Collection, filter interface{}) ([]string, error) {
+	f, err := normalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key, doc := range ops.docs(coll) {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (ops *memoryOps) findOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (bson.Raw, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return bson.Marshal(ops.docs(coll)[keys[0]])
+}
+
+// find supports sorting by a single field, Skip and Limit; projections are
+// ignored.
+func (ops *memoryOps) find(ctx context.Context, coll *mongo.Collection, filter interface{}, opts *options.FindOptions) ([]bson.Raw, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	docs := ops.docs(coll)
+	matched := make([]bson.M, len(keys))
+	for i, key := range keys {
+		matched[i] = docs[key]
+	}
+	if opts == nil {
+		opts = options.Find()
+	}
+	if opts.Sort != nil {
+		sortBy, err := normalize(opts.Sort)
+		if err != nil {
+			return nil, err
+		}
+		if len(sortBy) != 1 {
+			return nil, errors.New("mongodbstore: only sorting by one field is supported in memory")
+		}
+		for field, dir := range sortBy {
+			desc := compare(dir, int32(0)) < 0
+			sort.SliceStable(matched, func(i, j int) bool {
+				if desc {
+					return compare(matched[i][field], matched[j][field]) > 0
+				}
+				return compare(matched[i][field], matched[j][field]) < 0
+			})
+		}
+	}
+	if opts.Skip != nil {
+		if *opts.Skip >= int64(len(matched)) {
+			matched = nil
+		} else {
+			matched = matched[*opts.Skip:]
+		}
+	}
+	if opts.Limit != nil && *opts.Limit > 0 && *opts.Limit < int64(len(matched)) {
+		matched = matched[:*opts.Limit]
+	}
+	raws := make([]bson.Raw, len(matched))
+	for i, doc := range matched {
+		if raws[i], err = bson.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+	return raws, nil
+}
+
+func (ops *memoryOps) findOneAndUpdate(ctx context.Context, coll *mongo.Collection, filter, update interface{}) (bson.Raw, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	doc := ops.docs(coll)[keys[0]]
+	before, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	u, err := normalize(update)
+	if err != nil {
+		return nil, err
+	}
+	return before, applyUpdate(doc, u, false)
+}
+
+// insertOne fails with a duplicate key error like the server when a
+// document with the same _id exists.
+func (ops *memoryOps) insertOne(ctx context.Context, coll *mongo.Collection, doc interface{}) error {
+	d, err := normalize(doc)
+	if err != nil {
+		return err
+	}
+	if _, ok := d["_id"]; !ok {
+		d["_id"] = primitive.NewObjectID()
+	}
+	key, err := idKey(d["_id"])
+	if err != nil {
+		return err
+	}
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	docs := ops.docs(coll)
+	if _, ok := docs[key]; ok {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "duplicate key"}}}
+	}
+	docs[key] = d
+	return nil
+}
+
+func (ops *memoryOps) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	upsert := false
+	for _, opt := range opts {
+		if opt != nil && opt.Upsert != nil {
+			upsert = *opt.Upsert
+		}
+	}
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	return ops.update(coll, filter, update, upsert)
+}
+
+func (ops *memoryOps) update(coll *mongo.Collection, filter, update interface{}, upsert bool) (*mongo.UpdateResult, error) {
+	u, err := normalize(update)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	docs := ops.docs(coll)
+	if len(keys) > 0 {
+		if err = applyUpdate(docs[keys[0]], u, false); err != nil {
+			return nil, err
+		}
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	if !upsert {
+		return &mongo.UpdateResult{}, nil
+	}
+
+	// An upserted document starts out with the equality fields of filter.
+	f, err := normalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	for key, val := range f {
+		if cond, ok := val.(bson.M); key[0] != '$' && (!ok || !isOperator(cond)) {
+			doc[key] = val
+		}
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	if err = applyUpdate(doc, u, true); err != nil {
+		return nil, err
+	}
+	key, err := idKey(doc["_id"])
+	if err != nil {
+		return nil, err
+	}
+	docs[key] = doc
+
+	return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: doc["_id"]}, nil
+}
+
+func (ops *memoryOps) deleteOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	return ops.delete(coll, filter, 1)
+}
+
+func (ops *memoryOps) deleteMany(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	return ops.delete(coll, filter, -1)
+}
+
+// delete removes up to limit documents matching filter, all for a negative
+// limit.
+func (ops *memoryOps) delete(coll *mongo.Collection, filter interface{}, limit int) (*mongo.DeleteResult, error) {
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	for _, key := range keys {
+		delete(ops.docs(coll), key)
+	}
+	return &mongo.DeleteResult{DeletedCount: int64(len(keys))}, nil
+}
+
+func (ops *memoryOps) countDocuments(ctx context.Context, coll *mongo.Collection, filter interface{}) (int64, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	keys, err := ops.matching(coll, filter)
+	return int64(len(keys)), err
+}
+
+func (ops *memoryOps) findIDs(ctx context.Context, coll *mongo.Collection, filter interface{}, limit int64) ([]interface{}, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	keys, err := ops.matching(coll, filter)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(keys)) > limit {
+		keys = keys[:limit]
+	}
+	ids := make([]interface{}, len(keys))
+	for i, key := range keys {
+		ids[i] = ops.docs(coll)[key]["_id"]
+	}
+	return ids, nil
+}
+
+func (ops *memoryOps) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	res := &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+	for i, model := range models {
+		switch m := model.(type) {
+		case *mongo.UpdateOneModel:
+			updated, err := ops.update(coll, m.Filter, m.Update, m.Upsert != nil && *m.Upsert)
+			if err != nil {
+				return res, err
+			}
+			res.MatchedCount += updated.MatchedCount
+			res.ModifiedCount += updated.ModifiedCount
+			if updated.UpsertedCount > 0 {
+				res.UpsertedCount++
+				res.UpsertedIDs[int64(i)] = updated.UpsertedID
+			}
+		case *mongo.DeleteOneModel:
+			deleted, err := ops.delete(coll, m.Filter, 1)
+			if err != nil {
+				return res, err
+			}
+			res.DeletedCount += deleted.DeletedCount
+		default:
+			return res, fmt.Errorf("mongodbstore: %T is not supported in memory", model)
+		}
+	}
+	return res, nil
+}
+
+func (ops *memoryOps) aggregate(ctx context.Context, coll *mongo.Collection, pipeline interface{}, opts *options.AggregateOptions) ([]bson.Raw, error) {
+	return nil, errors.New("mongodbstore: aggregations are not supported in memory")
+}
+
+// normalize converts v to the types it has when decoded from BSON, so that
+// filters compare with stored documents.
+func normalize(v interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	err = bson.Unmarshal(raw, &m)
+	return m, err
+}
+
+// idKey returns the key of the document with _id ID.
+func idKey(ID interface{}) (string, error) {
+	raw, err := bson.Marshal(bson.M{"_id": ID})
+	return string(raw), err
+}
+
+func isOperator(cond bson.M) bool {
+	for key := range cond {
+		if len(key) > 0 && key[0] == '$' {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether doc matches the normalized filter.
+func matches(doc, filter bson.M) (bool, error) {
+	for key, want := range filter {
+		if key == "$or" || key == "$and" || key == "$nor" {
+			clauses, ok := want.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("mongodbstore: %s must be an array", key)
+			}
+			some, all := false, true
+			for _, clause := range clauses {
+				c, ok := clause.(bson.M)
+				if !ok {
+					return false, fmt.Errorf("mongodbstore: %s clause must be a document", key)
+				}
+				ok, err := matches(doc, c)
+				if err != nil {
+					return false, err
+				}
+				some, all = some || ok, all && ok
+			}
+			if key == "$or" && !some || key == "$and" && !all || key == "$nor" && some {
+				return false, nil
+			}
+			continue
+		}
+		got, exists := doc[key]
+		cond, ok := want.(bson.M)
+		if !ok || !isOperator(cond) {
+			if !exists || !valuesEqual(got, want) {
+				return false, nil
+			}
+			continue
+		}
+		for op, arg := range cond {
+			var ok bool
+			switch op {
+			case "$exists":
+				ok = exists == (arg == true)
+			case "$ne":
+				ok = !exists || !valuesEqual(got, arg)
+			case "$lt":
+				ok = exists && compare(got, arg) < 0
+			case "$gt":
+				ok = exists && compare(got, arg) > 0
+			case "$in":
+				values, isArray := arg.(bson.A)
+				if !isArray {
+					return false, errors.New("mongodbstore: $in must be an array")
+				}
+				for _, v := range values {
+					ok = ok || exists && valuesEqual(got, v)
+				}
+			default:
+				return false, fmt.Errorf("mongodbstore: %s is not supported in memory", op)
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// applyUpdate applies the normalized update to doc, including $setOnInsert
+// for an inserted document.
+func applyUpdate(doc, update bson.M, insert bool) error {
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("mongodbstore: %s must be a document", op)
+		}
+		switch op {
+		case "$set":
+			for key, val := range fields {
+				doc[key] = val
+			}
+		case "$setOnInsert":
+			if insert {
+				for key, val := range fields {
+					doc[key] = val
+				}
+			}
+		case "$unset":
+			for key := range fields {
+				delete(doc, key)
+			}
+		case "$inc":
+			for key, val := range fields {
+				n, _ := toFloat(doc[key])
+				inc, ok := toFloat(val)
+				if !ok {
+					return fmt.Errorf("mongodbstore: $inc of %s by %T", key, val)
+				}
+				doc[key] = int64(n + inc)
+			}
+		default:
+			return fmt.Errorf("mongodbstore: %s is not supported in memory", op)
+		}
+	}
+	return nil
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders numbers and datetimes, strings and ObjectIDs, returning 0
+// for other values.
+func compare(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(x.Hex(), y.Hex())
+		}
+	}
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if !okA || !okB {
+		return 0
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case primitive.DateTime:
+		return float64(n), true
+	case time.Time:
+		return float64(primitive.NewDateTimeFromTime(n)), true
+	}
+	return 0, false
+}
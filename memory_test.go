@@ -0,0 +1,109 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OptimisticLocking = true
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	ctx := context.Background()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	load := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return req
+	}
+	req = load()
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["foo"] != "bar" {
+		t.Fatalf("Expected the saved session to load; Got %v, %v", loaded.Values, err)
+	}
+	meta, _ := store.SessionMeta(req, "session-key")
+	if got := meta.ExpiresAt.Sub(meta.Modified); got != 30*24*time.Hour {
+		t.Errorf("Expected expires_at MaxAge after modified; Got %v", got)
+	}
+
+	// A concurrent save of the same session conflicts.
+	other := load()
+	concurrent, _ := store.New(other, "session-key")
+	loaded.Values["foo"] = "baz"
+	if err = store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	concurrent.Values["foo"] = "qux"
+	if err = store.Save(other, httptest.NewRecorder(), concurrent); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("Expected ErrConcurrentModification; Got %v", err)
+	}
+
+	if err = store.Touch(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Errorf("Error touching session: %v", err)
+	}
+	if byID, err := store.GetByID(ctx, loaded.ID); err != nil || byID.Values["foo"] != "baz" {
+		t.Errorf("Expected the session by ID; Got %v", err)
+	}
+
+	resp = httptest.NewRecorder()
+	if err = store.Delete(req, resp, loaded); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if got := resp.Header().Get("Set-Cookie"); !strings.Contains(got, "Max-Age=0") {
+		t.Errorf("Expected an expired cookie; Got %q", got)
+	}
+	req = load()
+	if session, err = store.New(req, "session-key"); err != nil || !session.IsNew {
+		t.Errorf("Expected a new session after Delete; Got IsNew %t, err %v", session.IsNew, err)
+	}
+	if err = store.DeleteByID(ctx, session.ID); err == nil {
+		t.Error("Expected an error for a deleted session")
+	}
+
+	if list, err := store.List(ctx, ListOptions{}); err != nil || len(list) != 0 {
+		t.Errorf("Expected no sessions; Got %v, %v", list, err)
+	}
+	if stats, err := store.Stats(ctx); err == nil || !stats.Partial {
+		t.Errorf("Expected aggregations to fail; Got %+v, %v", stats, err)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 1
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.SaveAll(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	// The codec rejects the cookie along with the expired document.
+	if session, _ = store.New(req, "session-key"); !session.IsNew {
+		t.Error("Expected the expired session not to load")
+	}
+}
@@ -0,0 +1,62 @@
+package mongodbstoregorilla
+
+import (
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SessionMeta holds the timestamps of a stored session.
+type SessionMeta struct {
+	// when the session was first saved, kept across saves
+	Created time.Time
+	// when the session was last saved or touched
+	Modified time.Time
+	// when the session expires, zero for none
+	ExpiresAt time.Time
+	// whether the session was served from the cache by ServeStaleOnError
+	// while mongoDB was unavailable, so that it may be out of date
+	Stale bool
+}
+
+// SessionMeta returns the timestamps of the session with the given name
+// that was loaded or saved during request r. It reports false when there is
+// none, e.g. for a new session before its first Save.
+func (mstore *MongoDBStore) SessionMeta(r *http.Request, name string) (SessionMeta, bool) {
+	state := getRequestState(r, false)
+	if state == nil {
+		return SessionMeta{}, false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	meta, ok := state.meta[name]
+
+	return meta, ok
+}
+
+// setSessionMeta records the timestamps of sessDoc for SessionMeta.
+func setSessionMeta(r *http.Request, name string, sessDoc *sessionDoc) {
+	state := getRequestState(r, true)
+	state.mu.Lock()
+	state.meta[name] = SessionMeta{
+		Created:   created(sessDoc),
+		Modified:  sessDoc.Modified,
+		ExpiresAt: sessDoc.ExpiresAt,
+		Stale:     sessDoc.servedStale,
+	}
+	state.mu.Unlock()
+}
+
+// created returns the creation time of sessDoc. Documents saved before it
+// was recorded fall back to the timestamp of their ObjectID, if any, or to
+// their modified time.
+func created(sessDoc *sessionDoc) time.Time {
+	if !sessDoc.Created.IsZero() {
+		return sessDoc.Created
+	}
+	if oid, ok := sessDoc.ID.(primitive.ObjectID); ok {
+		return oid.Timestamp()
+	}
+	return sessDoc.Modified
+}
@@ -0,0 +1,92 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreated(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if _, ok := store.SessionMeta(req, "session-key"); ok {
+		t.Error("Expected no meta for a new session")
+	}
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	first := readTestDoc(t, coll)
+	if first.Created.IsZero() {
+		t.Fatal("Expected created to be set on insert")
+	}
+	// mongoDB stores milliseconds.
+	sameTime := func(a, b time.Time) bool {
+		d := a.Sub(b)
+		return d > -time.Millisecond && d < time.Millisecond
+	}
+	if meta, ok := store.SessionMeta(req, "session-key"); !ok || !sameTime(meta.Created, first.Created) {
+		t.Errorf("Expected meta with the created time %v; Got %+v", first.Created, meta)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, _ = store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	second := readTestDoc(t, coll)
+	if !second.Created.Equal(first.Created) || !second.Modified.After(first.Modified) {
+		t.Errorf("Expected created %v to be kept and modified to advance; Got %+v", first.Created, second)
+	}
+	meta, ok := store.SessionMeta(req, "session-key")
+	if !ok || !meta.Created.Equal(first.Created) || !sameTime(meta.Modified, second.Modified) {
+		t.Errorf("Expected meta of the saved session; Got %+v", meta)
+	}
+}
+
+func TestCreatedLegacyDocument(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	encoded := &sessionDoc{}
+	if err = store.encodeValues("session-key", nil, encoded); err != nil {
+		t.Fatalf("Error encoding values: %v", err)
+	}
+	ID := primitive.NewObjectID()
+	_, err = coll.InsertOne(context.Background(), bson.M{"_id": ID, "data": encoded.Data, "modified": time.Now()})
+	if err != nil {
+		t.Fatalf("Error inserting legacy session: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.ID = ID.Hex()
+	resp := httptest.NewRecorder()
+	if err = store.setCookie(req, resp, session); err != nil {
+		t.Fatalf("Error setting cookie: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if session, err = store.New(req, "session-key"); err != nil || session.IsNew {
+		t.Fatalf("Expected the legacy session to load; Got %v", err)
+	}
+	if meta, _ := store.SessionMeta(req, "session-key"); !meta.Created.Equal(ID.Timestamp()) {
+		t.Errorf("Expected the ObjectID timestamp as created; Got %v", meta.Created)
+	}
+}
@@ -0,0 +1,50 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoSessionKey struct{}
+
+// mongoSessionContext is the context of a request, extended with the values
+// of a mongo.SessionContext so that the driver runs operations in its
+// session.
+type mongoSessionContext struct {
+	context.Context
+	sc mongo.SessionContext
+}
+
+func (c *mongoSessionContext) Value(key interface{}) interface{} {
+	if key == (mongoSessionKey{}) {
+		return c.sc
+	}
+	if val := c.Context.Value(key); val != nil {
+		return val
+	}
+	return c.sc.Value(key)
+}
+
+// WithSessionContext returns a shallow copy of r whose store operations run
+// in the mongo session of sc, e.g. in the transaction of a
+// mongo.Session.WithTransaction callback, so that an aborted transaction
+// leaves no session document behind. r keeps its values, such as the
+// sessions registry, and its cancellation.
+//
+// Within a transaction the store bypasses its cache. With MongoDB before
+// 4.4, Save can only insert a new session in a transaction when the
+// collection already exists, so create it up front.
+func WithSessionContext(r *http.Request, sc mongo.SessionContext) *http.Request {
+	return r.WithContext(&mongoSessionContext{Context: r.Context(), sc: sc})
+}
+
+// inMongoSession reports whether the operations of ctx run in a mongo session
+// passed with WithSessionContext or as the request context itself.
+func inMongoSession(ctx context.Context) bool {
+	if _, ok := ctx.(mongo.SessionContext); ok {
+		return true
+	}
+	return ctx.Value(mongoSessionKey{}) != nil
+}
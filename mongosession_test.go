@@ -0,0 +1,112 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type testRequestKey struct{}
+
+func TestWithSessionContext(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Cache = CacheConfig{Enabled: true}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+
+	var cookie string
+	err = coll.Database().Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), testRequestKey{}, "value"))
+		req = WithSessionContext(req, sc)
+		if req.Context().Value(testRequestKey{}) != "value" {
+			t.Error("Expected the request to keep its context values")
+		}
+		if !inMongoSession(req.Context()) {
+			t.Error("Expected the request to carry the mongo session")
+		}
+
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["foo"] = "bar"
+		if err := store.Save(req, resp, session); err != nil {
+			return err
+		}
+		cookie = resp.Header().Get("Set-Cookie")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error saving session in a mongo session: %v", err)
+	}
+	// Sessions saved in a mongo session are not cached, as the transaction
+	// may still be aborted.
+	if store.cache.lru.Len() != 0 {
+		t.Errorf("Expected no cached sessions; Got %d", store.cache.lru.Len())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if session, err := store.New(req, "session-key"); err != nil || session.Values["foo"] != "bar" {
+		t.Errorf("Expected the saved session to load; Got %v", err)
+	}
+}
+
+func TestWithSessionContextAbort(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	if err = coll.Database().RunCommand(ctx, bson.M{"isMaster": 1}).Decode(&hello); err != nil {
+		t.Fatalf("Error checking the server: %v", err)
+	}
+	if hello.SetName == "" {
+		t.Skip("Transactions require a replica set")
+	}
+	// Transactions can not create the collection before MongoDB 4.4.
+	if err = coll.Database().RunCommand(ctx, bson.M{"create": coll.Name()}).Err(); err != nil && !isNamespaceExists(err) {
+		t.Fatalf("Error creating collection: %v", err)
+	}
+	sess, err := coll.Database().Client().StartSession()
+	if err != nil {
+		t.Fatalf("Error starting mongo session: %v", err)
+	}
+	defer sess.EndSession(ctx)
+	if err = sess.StartTransaction(); err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+
+	err = mongo.WithSession(ctx, sess, func(sc mongo.SessionContext) error {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req = WithSessionContext(req, sc)
+		session, _ := store.New(req, "session-key")
+		return store.Save(req, httptest.NewRecorder(), session)
+	})
+	if err != nil {
+		t.Fatalf("Error saving session in a transaction: %v", err)
+	}
+	if err = sess.AbortTransaction(ctx); err != nil {
+		t.Fatalf("Error aborting transaction: %v", err)
+	}
+	if n, err := coll.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Errorf("Expected no session document after the rollback; Got %d (%v)", n, err)
+	}
+}
+
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 48
+}
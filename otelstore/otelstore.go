@@ -0,0 +1,68 @@
+// Package otelstore traces the operations of a mongodbstoregorilla store
+// with OpenTelemetry. It lives in its own module, so that the store itself
+// does not depend on OpenTelemetry.
+//
+//	cfg.Tracer = otelstore.NewTracer(otel.GetTracerProvider())
+package otelstore
+
+import (
+	"context"
+	"fmt"
+
+	mongodbstore "github.com/2-72/gorilla-sessions-mongodb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer of the store spans.
+const instrumentationName = "github.com/2-72/gorilla-sessions-mongodb"
+
+// SpanPrefix prefixes the store operation in the span names, e.g.
+// "mongodbstore.Save".
+const SpanPrefix = "mongodbstore."
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a mongodbstoregorilla.Tracer creating client spans with
+// provider.
+func NewTracer(provider trace.TracerProvider) mongodbstore.Tracer {
+	return tracer{provider.Tracer(instrumentationName)}
+}
+
+// Start implements mongodbstoregorilla.Tracer.
+func (t tracer) Start(ctx context.Context, op string) (context.Context, mongodbstore.Span) {
+	ctx, span := t.tracer.Start(ctx, SpanPrefix+op, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+// SetAttribute implements mongodbstoregorilla.Span.
+func (s otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// End implements mongodbstoregorilla.Span.
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,121 @@
+package otelstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mongodbstore "github.com/2-72/gorilla-sessions-mongodb"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestCollection(t *testing.T) *mongo.Collection {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetServerSelectionTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Error connecting to mongoDB: %v", err)
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		t.Skipf("mongoDB is not available: %v", err)
+	}
+	coll := client.Database("test").Collection("otelstore_" + t.Name())
+	t.Cleanup(func() {
+		coll.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return coll
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store, err := mongodbstore.NewMongoDBStoreWithConfig(newTestCollection(t), mongodbstore.MongoDBStoreConfig{
+		SessionOptions: sessions.Options{Path: "/", MaxAge: 3600},
+		Tracer:         NewTracer(provider),
+	}, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	// The store spans are children of the request span.
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if _, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if err = store.DeleteByID(ctx, session.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	// Deleting again fails and marks the span as failed.
+	if err = store.DeleteByID(ctx, session.ID); err == nil {
+		t.Fatal("Expected deleting a missing session to fail")
+	}
+	if _, err = store.DeleteExpired(ctx); err != nil {
+		t.Fatalf("Error deleting expired sessions: %v", err)
+	}
+	parent.End()
+
+	// The first span is the index creation of the constructor.
+	spans := recorder.Ended()[1:]
+	var names []string
+	for _, span := range spans[:len(spans)-1] {
+		names = append(names, span.Name())
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected %s to be a child of the request span", span.Name())
+		}
+	}
+	want := []string{"mongodbstore.Save", "mongodbstore.Load", "mongodbstore.Delete", "mongodbstore.Delete", "mongodbstore.DeleteExpired"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected spans %v; Got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Expected spans %v; Got %v", want, names)
+		}
+	}
+
+	save, load := attributes(spans[0]), attributes(spans[1])
+	if save["db.operation"].AsString() != "update" || !save["session.is_new"].AsBool() || save["session.payload_size"].AsInt64() == 0 {
+		t.Errorf("Expected update of a new session with its payload size; Got %v", save)
+	}
+	if load["db.operation"].AsString() != "find" || load["session.is_new"].AsBool() {
+		t.Errorf("Expected find of a stored session; Got %v", load)
+	}
+	if hash := load["session.id_hash"].AsString(); hash == "" || hash == session.ID {
+		t.Errorf("Expected the hash of the session ID; Got %q", hash)
+	}
+	if spans[2].Status().Code != codes.Unset {
+		t.Errorf("Expected the first delete to succeed; Got %v", spans[2].Status())
+	}
+	if status := spans[3].Status(); status.Code != codes.Error || len(spans[3].Events()) == 0 {
+		t.Errorf("Expected the second delete to record its error; Got %v", status)
+	}
+}
@@ -0,0 +1,142 @@
+package mongodbstoregorilla
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/gorilla/securecookie"
+)
+
+// encodeValues encodes session values into the payload fields of sessDoc.
+// nil values are encoded as an empty map.
+func (mstore *MongoDBStore) encodeValues(name string, values map[interface{}]interface{}, sessDoc *sessionDoc) error {
+	return mstore.encodePayload(name, values, mstore.getDataCodecs(), sessDoc)
+}
+
+// encodePayload encodes values with codecs into the payload fields of
+// sessDoc.
+//
+// Every transformation of the stored payload belongs here so that Save and
+// load apply them in a fixed order and its exact inverse: serialize,
+// compress, then encrypt, or sign and encrypt with the codecs.
+func (mstore *MongoDBStore) encodePayload(name string, values map[interface{}]interface{}, codecs []securecookie.Codec, sessDoc *sessionDoc) error {
+	sessDoc.Data, sessDoc.Encrypted, sessDoc.Compression = "", nil, ""
+	if values == nil {
+		values = make(map[interface{}]interface{})
+	}
+	if mstore.compression == nil && mstore.encryption == nil && !mstore.unsigned {
+		data, err := securecookie.EncodeMulti(name, values, codecs...)
+		sessDoc.Data = data
+		return err
+	}
+
+	raw, err := mstore.payloadSerializer().Serialize(values)
+	if err != nil {
+		return err
+	}
+	if c := mstore.compression; c != nil && len(raw) >= c.MinSize {
+		if raw, err = c.compressor().Compress(raw); err != nil {
+			return fmt.Errorf("mongodbstore: error compressing session data: %w", err)
+		}
+		sessDoc.Compression = c.compressor().Name()
+	}
+	switch {
+	case mstore.encryption != nil:
+		sessDoc.Encrypted, err = mstore.encrypt(name, raw)
+	case mstore.unsigned && sessDoc.Compression != "":
+		sessDoc.Data = base64.StdEncoding.EncodeToString(raw)
+	case mstore.unsigned:
+		sessDoc.Data = string(raw)
+	default:
+		// The serialized bytes pass through the codec serializer unchanged,
+		// so uncompressed data is the same as encoding the values directly.
+		sessDoc.Data, err = securecookie.EncodeMulti(name, serializedPayload(raw), codecs...)
+	}
+
+	return err
+}
+
+// decodeValues decodes the payload fields of sessDoc written by
+// encodeValues into values. values is never left nil on success.
+func (mstore *MongoDBStore) decodeValues(name string, sessDoc *sessionDoc, values *map[interface{}]interface{}) error {
+	return mstore.decodePayload(name, sessDoc, values, mstore.getDataCodecs())
+}
+
+// decodePayload decodes the payload fields of sessDoc written by
+// encodePayload with codecs.
+func (mstore *MongoDBStore) decodePayload(name string, sessDoc *sessionDoc, values *map[interface{}]interface{}, codecs []securecookie.Codec) error {
+	// Decoding into a nil map lets gob allocate it with the final size
+	// instead of growing the session's empty map entry by entry.
+	var decoded map[interface{}]interface{}
+	encrypted := sessDoc.Encrypted != nil
+	if !encrypted && sessDoc.Compression == "" && !mstore.unsigned {
+		if err := securecookie.DecodeMulti(name, sessDoc.Data, &decoded, codecs...); err != nil {
+			return err
+		}
+		mergeValues(values, decoded)
+		return nil
+	}
+
+	var raw []byte
+	switch {
+	case encrypted:
+		b, err := mstore.decrypt(name, sessDoc.Encrypted)
+		if err != nil {
+			return err
+		}
+		raw = b
+	case mstore.unsigned && sessDoc.Compression != "":
+		b, err := base64.StdEncoding.DecodeString(sessDoc.Data)
+		if err != nil {
+			return err
+		}
+		raw = b
+	case mstore.unsigned:
+		raw = []byte(sessDoc.Data)
+	default:
+		// The codecs verify the data before it is decompressed.
+		var payload serializedPayload
+		if err := securecookie.DecodeMulti(name, sessDoc.Data, &payload, codecs...); err != nil {
+			return err
+		}
+		raw = payload
+	}
+	if sessDoc.Compression != "" {
+		compressor := mstore.compression.lookup(sessDoc.Compression)
+		if compressor == nil {
+			return fmt.Errorf("mongodbstore: unknown session data compression %q", sessDoc.Compression)
+		}
+		b, err := compressor.Decompress(raw)
+		if err != nil {
+			return fmt.Errorf("mongodbstore: error decompressing session data: %w", err)
+		}
+		raw = b
+	}
+	if err := mstore.payloadSerializer().Deserialize(raw, &decoded); err != nil {
+		return err
+	}
+	mergeValues(values, decoded)
+
+	return nil
+}
+
+// mergeValues stores decoded into values.
+func mergeValues(values *map[interface{}]interface{}, decoded map[interface{}]interface{}) {
+	switch {
+	case len(*values) == 0 && decoded != nil:
+		*values = decoded
+	case *values == nil:
+		*values = make(map[interface{}]interface{})
+	default:
+		for k, v := range decoded {
+			(*values)[k] = v
+		}
+	}
+}
+
+func (mstore *MongoDBStore) payloadSerializer() Serializer {
+	if mstore.serializer == nil {
+		return GobSerializer{}
+	}
+	return mstore.serializer
+}
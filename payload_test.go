@@ -0,0 +1,34 @@
+package mongodbstoregorilla
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func benchmarkValues(n int) map[interface{}]interface{} {
+	values := make(map[interface{}]interface{}, n)
+	for i := 0; i < n; i++ {
+		values[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("value-%d", i)
+	}
+	return values
+}
+
+func BenchmarkDecodeValues(b *testing.B) {
+	codecs := securecookie.CodecsFromPairs([]byte("secret"))
+	store := &MongoDBStore{codecs: codecs, dataCodecs: codecs}
+	sessDoc := &sessionDoc{}
+	if err := store.encodeValues("session-key", benchmarkValues(20), sessDoc); err != nil {
+		b.Fatalf("Error encoding values: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		values := make(map[interface{}]interface{})
+		if err := store.decodeValues("session-key", sessDoc, &values); err != nil {
+			b.Fatalf("Error decoding values: %v", err)
+		}
+	}
+}
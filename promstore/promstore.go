@@ -0,0 +1,115 @@
+// Package promstore exports the operations of a mongodbstoregorilla store
+// as Prometheus metrics. It lives in its own module, so that the store
+// itself does not depend on the Prometheus client.
+//
+//	instrumenter, err := promstore.NewInstrumenter(prometheus.DefaultRegisterer)
+//	cfg.Instrumenter = instrumenter
+package promstore
+
+import (
+	"time"
+
+	mongodbstore "github.com/2-72/gorilla-sessions-mongodb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace prefixes the metric names, e.g.
+// "mongodbstore_operation_duration_seconds".
+const Namespace = "mongodbstore"
+
+// Instrumenter is a mongodbstoregorilla.EventInstrumenter updating
+// Prometheus metrics:
+//
+//   - mongodbstore_operation_duration_seconds, a histogram of the load, save
+//     and delete durations by op and result, "ok" or "error"
+//   - mongodbstore_save_payload_bytes, a histogram of the stored sizes
+//   - mongodbstore_sessions_total, a counter of the sessions loaded, missed,
+//     created, deleted, expired on load and cleaned up, by event
+//   - mongodbstore_retries_total, a counter of the operations retried after
+//     a transient error
+type Instrumenter struct {
+	duration *prometheus.HistogramVec
+	payload  prometheus.Histogram
+	sessions *prometheus.CounterVec
+	retries  prometheus.Counter
+}
+
+// NewInstrumenter returns an Instrumenter with its metrics registered with
+// reg, failing when they are registered already.
+func NewInstrumenter(reg prometheus.Registerer) (*Instrumenter, error) {
+	i := &Instrumenter{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the session store operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"op", "result"}),
+		payload: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "save_payload_bytes",
+			Help:      "Size of the session values written by Save.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}),
+		sessions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "sessions_total",
+			Help:      "Number of sessions by lifecycle event.",
+		}, []string{"event"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "retries_total",
+			Help:      "Number of operations retried after a transient error.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{i.duration, i.payload, i.sessions, i.retries} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+// ObserveLoad implements mongodbstoregorilla.Instrumenter.
+func (i *Instrumenter) ObserveLoad(d time.Duration, found bool, err error) {
+	i.observe("load", d, err)
+	switch {
+	case found:
+		i.sessions.WithLabelValues("loaded").Inc()
+	case err == nil:
+		i.sessions.WithLabelValues("missed").Inc()
+	}
+}
+
+// ObserveSave implements mongodbstoregorilla.Instrumenter.
+func (i *Instrumenter) ObserveSave(d time.Duration, size int, err error) {
+	i.observe("save", d, err)
+	if size > 0 {
+		i.payload.Observe(float64(size))
+	}
+}
+
+// ObserveDelete implements mongodbstoregorilla.Instrumenter.
+func (i *Instrumenter) ObserveDelete(d time.Duration, err error) {
+	i.observe("delete", d, err)
+	if err == nil {
+		i.sessions.WithLabelValues("deleted").Inc()
+	}
+}
+
+// ObserveEvent implements mongodbstoregorilla.EventInstrumenter.
+func (i *Instrumenter) ObserveEvent(event mongodbstore.Event, n int64) {
+	if event == mongodbstore.EventRetried {
+		i.retries.Add(float64(n))
+		return
+	}
+	i.sessions.WithLabelValues(string(event)).Add(float64(n))
+}
+
+func (i *Instrumenter) observe(op string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	i.duration.WithLabelValues(op, result).Observe(d.Seconds())
+}
@@ -0,0 +1,83 @@
+package promstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mongodbstore "github.com/2-72/gorilla-sessions-mongodb"
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumenter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	instrumenter, err := NewInstrumenter(reg)
+	if err != nil {
+		t.Fatalf("Error registering metrics: %v", err)
+	}
+	if _, err = NewInstrumenter(reg); err == nil {
+		t.Error("Expected an error registering the metrics twice")
+	}
+	store, err := mongodbstore.NewMemoryStore(mongodbstore.MongoDBStoreConfig{
+		SessionOptions: sessions.Options{Path: "/", MaxAge: 3600},
+		Instrumenter:   instrumenter,
+	}, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if session, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if err = store.Delete(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	// The deleted session is missed.
+	store.New(req, "session-key")
+
+	for event, want := range map[string]float64{"created": 1, "loaded": 1, "deleted": 1, "missed": 1} {
+		if got := testutil.ToFloat64(instrumenter.sessions.WithLabelValues(event)); got != want {
+			t.Errorf("Expected %v %s sessions; Got %v", want, event, got)
+		}
+	}
+	if got := testutil.CollectAndCount(instrumenter.duration); got != 3 {
+		t.Errorf("Expected durations of 3 operations; Got %d", got)
+	}
+	if got := testutil.CollectAndCount(instrumenter.payload); got != 1 {
+		t.Errorf("Expected a payload histogram; Got %d", got)
+	}
+
+	instrumenter.ObserveEvent(mongodbstore.EventRetried, 2)
+	if got := testutil.ToFloat64(instrumenter.retries); got != 2 {
+		t.Errorf("Expected 2 retries; Got %v", got)
+	}
+	if got := testutil.ToFloat64(instrumenter.sessions.WithLabelValues("retried")); got != 0 {
+		t.Errorf("Expected retries not to count as sessions; Got %v", got)
+	}
+}
+
+func TestObserveError(t *testing.T) {
+	instrumenter, err := NewInstrumenter(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("Error registering metrics: %v", err)
+	}
+	instrumenter.ObserveLoad(time.Millisecond, false, mongodbstore.ErrInvalidCookie)
+	if got := testutil.ToFloat64(instrumenter.sessions.WithLabelValues("missed")); got != 0 {
+		t.Errorf("Expected a failed load not to count as missed; Got %v", got)
+	}
+	if got := testutil.CollectAndCount(instrumenter.duration); got != 1 {
+		t.Errorf("Expected the failed load duration; Got %d", got)
+	}
+}
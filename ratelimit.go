@@ -0,0 +1,133 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// ErrRateLimited is returned by RateLimitedStore.Save when the request is not
+// allowed to create another session.
+var ErrRateLimited = errors.New("mongodbstore: session creation rate limit exceeded")
+
+// RateLimiter decides whether the given key may create another session.
+//
+// Implementations must be safe for concurrent use. The state may live in
+// process (see MemoryRateLimiter) or in an external system shared between
+// instances.
+type RateLimiter interface {
+	Allow(key string) bool
+}
+
+// KeyFunc derives the rate limit key from a request.
+type KeyFunc func(r *http.Request) string
+
+// RemoteIPKey is a KeyFunc that uses the IP part of r.RemoteAddr.
+func RemoteIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitedStore wraps a MongoDBStore and limits how many new sessions a
+// key derived from the request may create per interval.
+//
+// Only the creation of a session (the first Save of a session without an ID)
+// is limited; loading and updating existing sessions is never rejected.
+//
+// It only implements sessions.Store, so that no session is created around
+// the limiter. The other methods of the wrapped MongoDBStore, such as
+// SaveAll or RegenerateID, are not limited.
+type RateLimitedStore struct {
+	store   *MongoDBStore
+	limiter RateLimiter
+	keyFunc KeyFunc
+}
+
+// NewRateLimitedStore returns a RateLimitedStore around store. When keyFunc
+// is nil RemoteIPKey is used.
+func NewRateLimitedStore(store *MongoDBStore, limiter RateLimiter, keyFunc KeyFunc) *RateLimitedStore {
+	if keyFunc == nil {
+		keyFunc = RemoteIPKey
+	}
+	return &RateLimitedStore{store, limiter, keyFunc}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (rstore *RateLimitedStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(rstore, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (rstore *RateLimitedStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return rstore.store.newSession(r, name, rstore)
+}
+
+// Save persists the session like MongoDBStore.Save, but returns
+// ErrRateLimited without touching mongoDB when a new session would exceed
+// the limit.
+func (rstore *RateLimitedStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID == "" && session.Options.MaxAge >= 0 && !rstore.limiter.Allow(rstore.keyFunc(r)) {
+		return ErrRateLimited
+	}
+	return rstore.store.Save(r, w, session)
+}
+
+// MemoryRateLimiter is an in-process fixed window RateLimiter.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	interval  time.Duration
+	windows   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewMemoryRateLimiter returns a RateLimiter allowing limit sessions per key
+// in every interval.
+func NewMemoryRateLimiter(limit int, interval time.Duration) *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		limit:     limit,
+		interval:  interval,
+		windows:   make(map[string]*rateWindow),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow reports whether key may create another session and records the attempt.
+func (l *MemoryRateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) >= l.interval {
+		for k, win := range l.windows {
+			if now.Sub(win.start) >= l.interval {
+				delete(l.windows, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.interval {
+		win = &rateWindow{start: now}
+		l.windows[key] = win
+	}
+	if win.count >= l.limit {
+		return false
+	}
+	win.count++
+
+	return true
+}
@@ -0,0 +1,73 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemoryRateLimiter(2, 50*time.Millisecond)
+
+	if !limiter.Allow("a") || !limiter.Allow("a") {
+		t.Fatal("Expected first two attempts to be allowed")
+	}
+	if limiter.Allow("a") {
+		t.Fatal("Expected third attempt to be rejected")
+	}
+	if !limiter.Allow("b") {
+		t.Fatal("Expected other key to be allowed")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow("a") {
+		t.Fatal("Expected attempt in the next interval to be allowed")
+	}
+}
+
+func TestRateLimitedStore(t *testing.T) {
+	coll := newTestCollection(t)
+	mstore, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	store := NewRateLimitedStore(mstore, NewMemoryRateLimiter(1, time.Hour), nil)
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		return req
+	}
+
+	req := newRequest()
+	session, _ := store.Get(req, "session-key")
+	resp := httptest.NewRecorder()
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving first session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	// The existing session can still be updated.
+	req = newRequest()
+	req.Header.Add("Cookie", cookie)
+	session, _ = store.Get(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("Error updating existing session: %v", err)
+	}
+
+	req = newRequest()
+	session, _ = store.Get(req, "session-key")
+	if err = session.Save(req, httptest.NewRecorder()); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected ErrRateLimited; Got %v", err)
+	}
+	count, err := coll.CountDocuments(context.Background(), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Error counting sessions: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 session document; Got %d", count)
+	}
+}
@@ -0,0 +1,50 @@
+package mongodbstoregorilla
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RegenerateID moves session to a new ID, as done on login to prevent
+// session fixation: it saves the values under a new ID, sets the cookie for
+// it and deletes the document of the old ID, so that the old cookie no
+// longer loads the session.
+//
+// When the old document can not be deleted, the new session stays in place
+// and the error is returned.
+func (mstore *MongoDBStore) RegenerateID(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	oldID, isNew := session.ID, session.IsNew
+	session.ID, session.IsNew = "", true
+	if err := mstore.Save(r, w, session); err != nil {
+		session.ID, session.IsNew = oldID, isNew
+		return err
+	}
+	session.IsNew = isNew
+	if oldID == "" {
+		return nil
+	}
+
+	ctx, cancel := mstore.operationContext(r.Context())
+	defer cancel()
+	ctx, err := mstore.routeRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	ID, err := mstore.docID(oldID)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, oldID))
+	if _, err = mstore.deleteOne(ctx, mstore.collection(ctx), withTenant(bson.M{"_id": ID}, tenant)); err != nil {
+		return &StorageError{"error deleting old session", err}
+	}
+
+	return nil
+}
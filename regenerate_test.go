@@ -0,0 +1,88 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestRegenerateID(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["cart"] = "anonymous"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	anonymousCookie := resp.Header().Get("Set-Cookie")
+
+	// Login.
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost:8080/login", nil)
+	req.Header.Add("Cookie", anonymousCookie)
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected the anonymous session to load; Got %v", err)
+	}
+	oldID := session.ID
+	session.Values["user"] = "gopher"
+	resp = httptest.NewRecorder()
+	if err = store.RegenerateID(req, resp, session); err != nil {
+		t.Fatalf("Error regenerating session ID: %v", err)
+	}
+	if session.ID == oldID || session.IsNew {
+		t.Errorf("Expected a new ID for the loaded session; Got %q, IsNew %t", session.ID, session.IsNew)
+	}
+	if n, _ := coll.CountDocuments(context.Background(), bson.M{}); n != 1 {
+		t.Errorf("Expected the old document to be deleted; Got %d documents", n)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew || loaded.Values["user"] != "gopher" || loaded.Values["cart"] != "anonymous" {
+		t.Errorf("Expected the authenticated session under the new ID; Got %v, %v", loaded.Values, err)
+	}
+
+	// Replaying the anonymous cookie yields a fresh session.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", anonymousCookie)
+	if loaded, err = store.New(req, "session-key"); err != nil || !loaded.IsNew || len(loaded.Values) != 0 {
+		t.Errorf("Expected the old ID not to load; Got %v, %v", loaded.Values, err)
+	}
+}
+
+func TestRegenerateIDSaveError(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:1").SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	cfg := defaultConfig
+	cfg.IndexTTL = false
+	store, err := NewMongoDBStoreWithConfig(client.Database("test").Collection("down"), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.ID, session.IsNew = "5f2d6a0e9d8b7c6a5f4e3d2c", false
+	if err = store.RegenerateID(req, httptest.NewRecorder(), session); !errors.Is(err, ErrStorage) {
+		t.Errorf("Expected ErrStorage; Got %v", err)
+	}
+	if session.ID != "5f2d6a0e9d8b7c6a5f4e3d2c" || session.IsNew {
+		t.Errorf("Expected the session to keep its ID; Got %q", session.ID)
+	}
+}
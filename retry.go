@@ -0,0 +1,380 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	// DefaultRetryInitialBackoff is the wait before the first retry when
+	// RetryConfig.InitialBackoff is not set.
+	DefaultRetryInitialBackoff = 50 * time.Millisecond
+
+	// DefaultRetryMaxBackoff is the longest wait between two attempts when
+	// RetryConfig.MaxBackoff is not set.
+	DefaultRetryMaxBackoff = time.Second
+)
+
+// RetryConfig configures retrying the mongoDB operations of New, Save,
+// SaveAll and Touch that fail with a transient error, such as a dropped
+// connection or a primary stepdown. Other errors, including a canceled
+// context and undecodable documents, are returned at once. Each retry is
+// logged and counted as EventRetried.
+//
+// After a network error the server may have applied a write whose
+// acknowledgement was lost, so retried writes must be idempotent. The
+// writes of the store are: they set the same fields again or delete the
+// same document. With OptimisticLocking updates are not retried at all,
+// since a retried update whose first attempt did apply would see its own
+// version bump as a conflict.
+type RetryConfig struct {
+	// number of attempts per operation, 0 or 1 for no retries
+	MaxAttempts int
+	// wait before the first retry, doubled for each further retry, 0 for
+	// DefaultRetryInitialBackoff
+	InitialBackoff time.Duration
+	// longest wait between two attempts, 0 for DefaultRetryMaxBackoff
+	MaxBackoff time.Duration
+	// total time the attempts of an operation may take, 0 for no limit
+	// short of the deadline of its context
+	MaxElapsed time.Duration
+}
+
+// RetryError is the error of the last attempt of an operation that was
+// retried.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// collectionOps are the collection operations that read and write session
+// documents, behind an interface so that tests can inject errors and
+// NewMemoryStore can do without a server. Index management and change
+// streams use the driver directly.
+type collectionOps interface {
+	findOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (bson.Raw, error)
+	find(ctx context.Context, coll *mongo.Collection, filter interface{}, opts *options.FindOptions) ([]bson.Raw, error)
+	findOneAndUpdate(ctx context.Context, coll *mongo.Collection, filter, update interface{}) (bson.Raw, error)
+	insertOne(ctx context.Context, coll *mongo.Collection, doc interface{}) error
+	updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	deleteOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error)
+	bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error)
+	deleteMany(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error)
+	countDocuments(ctx context.Context, coll *mongo.Collection, filter interface{}) (int64, error)
+	findIDs(ctx context.Context, coll *mongo.Collection, filter interface{}, limit int64) ([]interface{}, error)
+	aggregate(ctx context.Context, coll *mongo.Collection, pipeline interface{}, opts *options.AggregateOptions) ([]bson.Raw, error)
+}
+
+// driverOps runs the operations with the driver.
+type driverOps struct{}
+
+func (driverOps) findOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (bson.Raw, error) {
+	return coll.FindOne(ctx, filter).DecodeBytes()
+}
+
+func (driverOps) find(ctx context.Context, coll *mongo.Collection, filter interface{}, opts *options.FindOptions) ([]bson.Raw, error) {
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	err = cursor.All(ctx, &docs)
+	return docs, err
+}
+
+// findOneAndUpdate returns the document matching filter as it was before
+// update.
+func (driverOps) findOneAndUpdate(ctx context.Context, coll *mongo.Collection, filter, update interface{}) (bson.Raw, error) {
+	return coll.FindOneAndUpdate(ctx, filter, update).DecodeBytes()
+}
+
+func (driverOps) insertOne(ctx context.Context, coll *mongo.Collection, doc interface{}) error {
+	_, err := coll.InsertOne(ctx, doc)
+	return err
+}
+
+func (driverOps) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (driverOps) deleteOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error) {
+	return coll.DeleteOne(ctx, filter)
+}
+
+func (driverOps) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	return coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+}
+
+func (driverOps) deleteMany(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error) {
+	return coll.DeleteMany(ctx, filter)
+}
+
+func (driverOps) countDocuments(ctx context.Context, coll *mongo.Collection, filter interface{}) (int64, error) {
+	return coll.CountDocuments(ctx, filter)
+}
+
+func (driverOps) findIDs(ctx context.Context, coll *mongo.Collection, filter interface{}, limit int64) ([]interface{}, error) {
+	cursor, err := coll.Find(ctx, filter, options.Find().SetLimit(limit).SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids, nil
+}
+
+func (driverOps) aggregate(ctx context.Context, coll *mongo.Collection, pipeline interface{}, opts *options.AggregateOptions) ([]bson.Raw, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	err = cursor.All(ctx, &docs)
+	return docs, err
+}
+
+// findOne decodes the document matching filter into sessDoc, retrying
+// transient errors.
+func (mstore *MongoDBStore) findOne(ctx context.Context, coll *mongo.Collection, filter interface{}, sessDoc *sessionDoc) error {
+	var raw bson.Raw
+	err := mstore.retry(ctx, true, func() (err error) {
+		raw, err = mstore.ops.findOne(ctx, coll, filter)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = mstore.fieldNames.decodeDoc(raw, sessDoc); err != nil {
+		return fmt.Errorf("%w: %v", ErrDataDecode, err)
+	}
+	return nil
+}
+
+// findDocs returns the documents matching filter, retrying transient
+// errors.
+func (mstore *MongoDBStore) findDocs(ctx context.Context, coll *mongo.Collection, filter interface{}, opts *options.FindOptions) (docs []bson.Raw, err error) {
+	err = mstore.retry(ctx, true, func() error {
+		docs, err = mstore.ops.find(ctx, coll, filter, opts)
+		return err
+	})
+	return docs, err
+}
+
+// scanBatchSize is the number of documents eachDoc reads at a time.
+const scanBatchSize = 1000
+
+// eachDoc calls fn with each document of coll matching filter in _id
+// order, reading them scanBatchSize at a time so that a scan of the whole
+// collection neither holds it in memory nor keeps a cursor open while fn
+// writes. It stops at the first error of fn.
+func (mstore *MongoDBStore) eachDoc(ctx context.Context, coll *mongo.Collection, filter bson.M, fn func(raw bson.Raw) error) error {
+	page := filter
+	for {
+		docs, err := mstore.findDocs(ctx, coll, page, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(scanBatchSize))
+		if err != nil {
+			return &StorageError{"error listing sessions", err}
+		}
+		for _, raw := range docs {
+			if err = fn(raw); err != nil {
+				return err
+			}
+		}
+		if len(docs) < scanBatchSize {
+			return nil
+		}
+		last := docs[len(docs)-1].Lookup("_id")
+		page = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": last}}}}
+	}
+}
+
+// updateOne updates the document matching filter, retrying transient
+// errors.
+func (mstore *MongoDBStore) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (res *mongo.UpdateResult, err error) {
+	err = mstore.retry(ctx, !mstore.optimisticLocking, func() error {
+		res, err = mstore.ops.updateOne(ctx, coll, filter, update, opts...)
+		return err
+	})
+	return res, err
+}
+
+// deleteOne deletes the document matching filter, retrying transient
+// errors.
+func (mstore *MongoDBStore) deleteOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (res *mongo.DeleteResult, err error) {
+	err = mstore.retry(ctx, true, func() error {
+		res, err = mstore.ops.deleteOne(ctx, coll, filter)
+		return err
+	})
+	return res, err
+}
+
+// bulkWrite runs the unordered bulk write of models, retrying transient
+// errors like updateOne.
+func (mstore *MongoDBStore) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (res *mongo.BulkWriteResult, err error) {
+	err = mstore.retry(ctx, !mstore.optimisticLocking, func() error {
+		res, err = mstore.ops.bulkWrite(ctx, coll, models)
+		return err
+	})
+	return res, err
+}
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or runs out of attempts or time.
+func (mstore *MongoDBStore) retry(ctx context.Context, retryable bool, op func() error) error {
+	cfg := mstore.retryConfig
+	backoff := cfg.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryInitialBackoff
+	}
+	maxBackoff := cfg.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryable || attempt >= cfg.MaxAttempts || ctx.Err() != nil || !isTransient(err) {
+			if err != nil && attempt > 1 {
+				err = &RetryError{Attempts: attempt, Err: err}
+			}
+			return err
+		}
+		// Jitter spreads the retries of concurrent requests.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		if cfg.MaxElapsed > 0 && time.Since(start)+wait > cfg.MaxElapsed {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		mstore.logger.Warn("mongodbstore: transient error, retrying", "op", "retry", "attempt", attempt, "error", err)
+		mstore.observeEvent(EventRetried, 1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &RetryError{Attempts: attempt, Err: err}
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// transientCodes are the server error codes of failovers and shutdowns,
+// after which the operation can succeed on another attempt.
+var transientCodes = map[int32]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	262:   true, // ExceededTimeLimit
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// labeledError is implemented by the errors that carry the error labels of
+// the server; newer drivers add them to write exceptions as well.
+type labeledError interface {
+	HasErrorLabel(label string) bool
+}
+
+// isTransient reports whether err is a network error, a failed server
+// selection or a server error the driver or server classifies as retryable.
+// A write that failed with a network error may have been applied. Write
+// concern errors are not transient, as the write was applied.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var labeled labeledError
+	if errors.As(err, &labeled) && (labeled.HasErrorLabel("NetworkError") || labeled.HasErrorLabel("RetryableWriteError")) {
+		return true
+	}
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return transientCodes[cmdErr.Code]
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		codes := make([]int, len(writeErr.WriteErrors))
+		for i, e := range writeErr.WriteErrors {
+			codes[i] = e.Code
+		}
+		return writeErr.WriteConcernError == nil && transientWrites(codes)
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		codes := make([]int, len(bulkErr.WriteErrors))
+		for i, e := range bulkErr.WriteErrors {
+			codes[i] = e.Code
+		}
+		return bulkErr.WriteConcernError == nil && transientWrites(codes)
+	}
+	// The driver reports a failed server selection as a plain error.
+	return strings.Contains(err.Error(), topology.ErrServerSelectionTimeout.Error())
+}
+
+// transientWrites reports whether the write errors with the given codes all
+// failed for a transient reason, so that none of the writes was applied.
+func transientWrites(codes []int) bool {
+	for _, code := range codes {
+		if !transientCodes[int32(code)] {
+			return false
+		}
+	}
+	return len(codes) > 0
+}
+
+// isDuplicateKey reports whether err is a duplicate key error, as returned by
+// an upsert that races another upsert or insert of the same _id.
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+		return false
+	}
+	// The error of a single write of a bulk write.
+	var bulkErr mongo.WriteError
+	if errors.As(err, &bulkErr) {
+		return bulkErr.Code == 11000
+	}
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 11000
+}
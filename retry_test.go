@@ -0,0 +1,357 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errNetwork = mongo.CommandError{Code: 0, Message: "connection reset", Labels: []string{"NetworkError"}}
+
+// failingOps fails the first attempts of each operation of a memory store
+// with err.
+type failingOps struct {
+	*memoryOps
+	mu    sync.Mutex
+	err   error
+	fails int
+	calls map[string]int
+}
+
+// failOps makes the first fails attempts of each operation of the memory
+// store fail with err.
+func failOps(store *MongoDBStore, err error, fails int) *failingOps {
+	ops := &failingOps{memoryOps: store.ops.(*memoryOps), err: err, fails: fails}
+	store.ops = ops
+	return ops
+}
+
+func (ops *failingOps) fail(op string) error {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	if ops.calls == nil {
+		ops.calls = make(map[string]int)
+	}
+	ops.calls[op]++
+	if ops.calls[op] <= ops.fails {
+		return ops.err
+	}
+	return nil
+}
+
+func (ops *failingOps) findOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (bson.Raw, error) {
+	if err := ops.fail("findOne"); err != nil {
+		return nil, err
+	}
+	return ops.memoryOps.findOne(ctx, coll, filter)
+}
+
+func (ops *failingOps) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if err := ops.fail("updateOne"); err != nil {
+		return nil, err
+	}
+	return ops.memoryOps.updateOne(ctx, coll, filter, update, opts...)
+}
+
+func (ops *failingOps) deleteOne(ctx context.Context, coll *mongo.Collection, filter interface{}) (*mongo.DeleteResult, error) {
+	if err := ops.fail("deleteOne"); err != nil {
+		return nil, err
+	}
+	return ops.memoryOps.deleteOne(ctx, coll, filter)
+}
+
+func (ops *failingOps) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	if err := ops.fail("bulkWrite"); err != nil {
+		return nil, err
+	}
+	return ops.memoryOps.bulkWrite(ctx, coll, models)
+}
+
+func (ops *failingOps) findIDs(ctx context.Context, coll *mongo.Collection, filter interface{}, limit int64) ([]interface{}, error) {
+	if err := ops.fail("findIDs"); err != nil {
+		return nil, err
+	}
+	return ops.memoryOps.findIDs(ctx, coll, filter, limit)
+}
+
+// duplicateOps fails the first dups upserts with a duplicate key error.
+type duplicateOps struct {
+	*memoryOps
+	dups    int
+	upserts int
+}
+
+func (ops *duplicateOps) updateOne(ctx context.Context, coll *mongo.Collection, filter, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if upsert := options.MergeUpdateOptions(opts...).Upsert; upsert != nil && *upsert {
+		if ops.upserts++; ops.upserts <= ops.dups {
+			return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+		}
+	}
+	return ops.memoryOps.updateOne(ctx, coll, filter, update, opts...)
+}
+
+func newRetryStore(t *testing.T, cfg MongoDBStoreConfig) *MongoDBStore {
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	return store
+}
+
+func TestRetry(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	logger := &recordingLogger{}
+	cfg.Logger = logger
+	instrumenter := &recordingInstrumenter{}
+	cfg.Instrumenter = instrumenter
+	store := newRetryStore(t, cfg)
+	ops := failOps(store, errNetwork, 2)
+
+	cookie := saveTestSession(t, store)
+	session, err := loadWithCookie(store, cookie)
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if session.IsNew || session.Values["user"] != "alice" {
+		t.Errorf("Expected the saved session; Got new %v with %v", session.IsNew, session.Values)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	for _, op := range []string{"findOne", "updateOne", "deleteOne"} {
+		if ops.calls[op] != 3 {
+			t.Errorf("Expected 3 attempts of %s; Got %d", op, ops.calls[op])
+		}
+	}
+	if entry := logger.find("warn", "retry"); entry == nil || entry.fields["attempt"] != 1 {
+		t.Errorf("Expected a warning for the retries; Got %+v", entry)
+	}
+	if got := instrumenter.events[EventRetried]; got != 6 {
+		t.Errorf("Expected 6 retried events; Got %d", got)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	store := newRetryStore(t, cfg)
+	failOps(store, errNetwork, 3)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	err := store.Save(req, httptest.NewRecorder(), session)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 {
+		t.Fatalf("Expected a RetryError after 3 attempts; Got %v", err)
+	}
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) {
+		t.Errorf("Expected a StorageError; Got %T", err)
+	}
+	if !isTransient(err) {
+		t.Errorf("Expected the network error to be wrapped; Got %v", err)
+	}
+}
+
+func TestRetryNotTransient(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	store := newRetryStore(t, cfg)
+	ops := failOps(store, mongo.CommandError{Code: 13, Message: "unauthorized"}, 1)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	err := store.Save(req, httptest.NewRecorder(), session)
+	var retryErr *RetryError
+	if err == nil || errors.As(err, &retryErr) {
+		t.Errorf("Expected the error of a single attempt; Got %v", err)
+	}
+	if ops.calls["updateOne"] != 1 {
+		t.Errorf("Expected 1 attempt; Got %d", ops.calls["updateOne"])
+	}
+}
+
+func TestRetryOptimisticLocking(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OptimisticLocking = true
+	cfg.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	store := newRetryStore(t, cfg)
+	ops := failOps(store, errNetwork, 1)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err := store.Save(req, httptest.NewRecorder(), session); !isTransient(err) {
+		t.Errorf("Expected the network error; Got %v", err)
+	}
+	if ops.calls["updateOne"] != 1 {
+		t.Errorf("Expected updates not to be retried; Got %d attempts", ops.calls["updateOne"])
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	store := &MongoDBStore{retryConfig: RetryConfig{MaxAttempts: 5, InitialBackoff: time.Second}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	start := time.Now()
+	err := store.retry(ctx, true, func() error {
+		attempts++
+		return errNetwork
+	})
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected to give up without waiting past the deadline; Took %v", elapsed)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt; Got %d", attempts)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 {
+		t.Errorf("Expected a RetryError after 1 attempt; Got %v", err)
+	}
+}
+
+func TestRetryMaxElapsed(t *testing.T) {
+	store := &MongoDBStore{retryConfig: RetryConfig{MaxAttempts: 10, InitialBackoff: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}, logger: noopLogger{}}
+
+	attempts := 0
+	err := store.retry(context.Background(), true, func() error {
+		attempts++
+		return errNetwork
+	})
+	if attempts < 2 || attempts >= 10 {
+		t.Errorf("Expected MaxElapsed to end the retries early; Got %d attempts", attempts)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != attempts {
+		t.Errorf("Expected a RetryError after %d attempts; Got %v", attempts, err)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	store := &MongoDBStore{retryConfig: RetryConfig{MaxAttempts: 5, InitialBackoff: time.Millisecond}, logger: noopLogger{}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := store.retry(ctx, true, func() error {
+		attempts++
+		cancel()
+		return errNetwork
+	})
+	if attempts != 1 {
+		t.Errorf("Expected no retries after cancellation; Got %d attempts", attempts)
+	}
+	var retryErr *RetryError
+	if !isTransient(err) || errors.As(err, &retryErr) {
+		t.Errorf("Expected the error of the single attempt; Got %v", err)
+	}
+}
+
+func TestRetryTouch(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Retry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	store := newRetryStore(t, cfg)
+	cookie := saveTestSession(t, store)
+	session, err := loadWithCookie(store, cookie)
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	ops := failOps(store, errNetwork, 2)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	if err = store.Touch(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	if ops.calls["updateOne"] != 3 {
+		t.Errorf("Expected 3 attempts of updateOne; Got %d", ops.calls["updateOne"])
+	}
+}
+
+func TestSaveDuplicateKey(t *testing.T) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	ops := &duplicateOps{memoryOps: store.ops.(*memoryOps), dups: 1}
+	store.ops = ops
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Expected the duplicate key error to be retried; Got %v", err)
+	}
+	if ops.upserts != 2 {
+		t.Errorf("Expected 2 upserts; Got %d", ops.upserts)
+	}
+	if byID, err := store.GetByID(context.Background(), session.ID); err != nil || byID.Values["user"] != "alice" {
+		t.Errorf("Expected the saved session; Got %v", err)
+	}
+
+	// RegenerateID inserts the new ID the same way.
+	ops.upserts, ops.dups = 0, 1
+	if err = store.RegenerateID(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error regenerating ID: %v", err)
+	}
+	if ops.upserts != 2 {
+		t.Errorf("Expected 2 upserts; Got %d", ops.upserts)
+	}
+
+	// The retry is bounded.
+	ops.upserts, ops.dups = 0, 2
+	session, _ = store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); !isDuplicateKey(err) {
+		t.Errorf("Expected the duplicate key error; Got %v", err)
+	}
+	if ops.upserts != 2 {
+		t.Errorf("Expected 2 upserts; Got %d", ops.upserts)
+	}
+}
+
+// labeledErr is an error with a label, like the write exceptions of newer
+// drivers.
+type labeledErr struct{ label string }
+
+func (e labeledErr) Error() string                   { return "labeled" }
+func (e labeledErr) HasErrorLabel(label string) bool { return label == e.label }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", errNetwork, true},
+		{"retryable write", mongo.CommandError{Code: 11000, Labels: []string{"RetryableWriteError"}}, true},
+		{"stepdown", mongo.CommandError{Code: 189}, true},
+		{"not master", mongo.CommandError{Code: 10107}, true},
+		{"wrapped", &StorageError{"error saving session", errNetwork}, true},
+		{"write stepdown", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 189}}}, true},
+		{"bulk not master", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 10107}}}}, true},
+		{"labeled", labeledErr{"RetryableWriteError"}, true},
+		{"server selection", errors.New("server selection error: server selection timeout, current topology: { }"), true},
+		{"unauthorized", mongo.CommandError{Code: 13}, false},
+		{"bulk partly duplicate", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 10107}}, {WriteError: mongo.WriteError{Code: 11000}}}}, false},
+		{"duplicate key", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, false},
+		{"write concern", mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: 91}}, false},
+		{"no documents", mongo.ErrNoDocuments, false},
+		{"context", context.DeadlineExceeded, false},
+	}
+	for _, test := range tests {
+		if got := isTransient(test.err); got != test.want {
+			t.Errorf("%s: Expected %v; Got %v", test.name, test.want, got)
+		}
+	}
+}
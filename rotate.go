@@ -0,0 +1,79 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RotateKeysResult reports the outcome of RotateKeys.
+type RotateKeysResult struct {
+	// documents re-encoded with the new keys
+	Rotated int
+	// documents that were already encoded with the new keys
+	Current int
+	// documents that could not be decoded with either set of keys
+	Failed int
+	// one error per failed document
+	Errors []error
+}
+
+// RotateKeys performs a full key rotation: it makes the store sign and
+// encrypt with newKeyPairs from now on, while still accepting cookies issued
+// with oldKeyPairs, and re-encodes every stored session with newKeyPairs.
+//
+// Subsequent requests re-sign cookies with the new keys when their session
+// is saved. Documents already encoded with the new keys are skipped, so
+// RotateKeys can be run again to resume an interrupted rotation. Documents
+// that were last saved before the session name was stored can not be
+// verified and are reported as failed; they are rotated on their next Save.
+func (mstore *MongoDBStore) RotateKeys(ctx context.Context, oldKeyPairs, newKeyPairs [][]byte) (RotateKeysResult, error) {
+	var result RotateKeysResult
+
+	newCookieCodecs, newCodecs := mstore.newCodecs(newKeyPairs...)
+	oldCookieCodecs, oldCodecs := mstore.newCodecs(oldKeyPairs...)
+
+	mstore.mu.Lock()
+	mstore.codecs = append(newCookieCodecs[:len(newCookieCodecs):len(newCookieCodecs)], oldCookieCodecs...)
+	mstore.dataCodecs = append(newCodecs[:len(newCodecs):len(newCodecs)], oldCodecs...)
+	mstore.mu.Unlock()
+
+	err := mstore.eachDoc(ctx, mstore.coll, bson.M{}, func(raw bson.Raw) error {
+		sessDoc := &sessionDoc{}
+		if err := mstore.fieldNames.decodeDoc(raw, sessDoc); err != nil {
+			return fmt.Errorf("mongodbstore: error decoding session document: %w", err)
+		}
+
+		values := make(map[interface{}]interface{})
+		if sessDoc.Data == "" || mstore.unsigned {
+			// Stored as BSON, encrypted or unsigned, not encoded with any keys.
+			result.Current++
+			return nil
+		}
+		if mstore.decodePayload(sessDoc.Name, sessDoc, &values, newCodecs) == nil {
+			result.Current++
+			return nil
+		}
+		if err := mstore.decodePayload(sessDoc.Name, sessDoc, &values, oldCodecs); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Errorf("mongodbstore: session %s: %w", idString(sessDoc.ID), err))
+			return nil
+		}
+		updated := &sessionDoc{}
+		if err := mstore.encodePayload(sessDoc.Name, values, newCodecs, updated); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Errorf("mongodbstore: session %s: %w", idString(sessDoc.ID), err))
+			return nil
+		}
+		// Matching on the old data leaves sessions saved in the meantime alone.
+		_, err := mstore.updateOne(ctx, mstore.coll, bson.M{"_id": sessDoc.ID, mstore.fieldNames.Data: sessDoc.Data}, mstore.fieldNames.payloadUpdate(updated))
+		if err != nil {
+			return fmt.Errorf("mongodbstore: error re-encoding session: %w", err)
+		}
+		result.Rotated++
+		return nil
+	})
+
+	return result, err
+}
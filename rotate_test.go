@@ -0,0 +1,59 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRotateKeys(t *testing.T) {
+	coll := newTestCollection(t)
+	oldKey, newKey := []byte("old-secret"), []byte("new-secret")
+	store, err := NewMongoDBStore(coll, oldKey)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	ctx := context.Background()
+	result, err := store.RotateKeys(ctx, [][]byte{oldKey}, [][]byte{newKey})
+	if err != nil {
+		t.Fatalf("Error rotating keys: %v", err)
+	}
+	if result.Rotated != 1 || result.Current != 0 || result.Failed != 0 {
+		t.Errorf("Expected 1 rotated session; Got %+v", result)
+	}
+
+	// Cookies signed with the old key keep working.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	if session, err = store.New(req, "session-key"); err != nil || session.IsNew {
+		t.Fatalf("Expected old cookie to load after rotation; Got err %v, IsNew %t", err, session.IsNew)
+	}
+
+	// The document is now readable with the new key alone.
+	newStore, err := NewMongoDBStore(coll, newKey)
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	loaded := sessions.NewSession(newStore, "session-key")
+	loaded.ID = session.ID
+	if found, err := newStore.load(ctx, loaded); err != nil || !found || loaded.Values["foo"] != "bar" {
+		t.Errorf("Expected document encoded with new key; Got err %v, found %t, values %v", err, found, loaded.Values)
+	}
+
+	if result, err = store.RotateKeys(ctx, [][]byte{oldKey}, [][]byte{newKey}); err != nil || result.Current != 1 || result.Rotated != 0 {
+		t.Errorf("Expected resumed rotation to skip current session; Got %+v, %v", result, err)
+	}
+}
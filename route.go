@@ -0,0 +1,94 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCollectionSelection is returned by New and Save when CollectionSelector
+// fails for the request.
+var ErrCollectionSelection = errors.New("mongodbstore: error selecting collection")
+
+type routeKey struct{}
+
+// route is a collection picked by CollectionSelector, configured like the
+// collection of the store. Its indexes are ensured on first use.
+type route struct {
+	mu       sync.Mutex
+	ready    bool
+	key      string
+	coll     *mongo.Collection
+	loadColl *mongo.Collection
+}
+
+// routeRequest binds ctx to the collection CollectionSelector picks for r.
+func (mstore *MongoDBStore) routeRequest(ctx context.Context, r *http.Request) (context.Context, error) {
+	if mstore.collectionSelector == nil {
+		return ctx, nil
+	}
+	coll, err := mstore.collectionSelector(r)
+	if err == nil && coll == nil {
+		err = errors.New("no collection")
+	}
+	if err != nil {
+		return ctx, fmt.Errorf("%w: %v", ErrCollectionSelection, err)
+	}
+
+	key := coll.Database().Name() + "." + coll.Name()
+	value, _ := mstore.routes.LoadOrStore(key, &route{key: key})
+	rt := value.(*route)
+	ctx = context.WithValue(ctx, routeKey{}, rt)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.ready {
+		return ctx, nil
+	}
+	if rt.coll, rt.loadColl, err = configureCollection(coll, mstore.concerns); err != nil {
+		return ctx, err
+	}
+	indexCtx := ctx
+	if inMongoSession(ctx) {
+		// Indexes are not built inside transactions.
+		var cancel context.CancelFunc
+		indexCtx, cancel = mstore.operationContext(context.WithValue(context.Background(), routeKey{}, rt))
+		defer cancel()
+	}
+	// A failure is retried by the next request.
+	if err = mstore.ensureIndexes(indexCtx); err != nil {
+		return ctx, err
+	}
+	rt.ready = true
+
+	return ctx, nil
+}
+
+// collection returns the collection the request of ctx writes to.
+func (mstore *MongoDBStore) collection(ctx context.Context) *mongo.Collection {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return rt.coll
+	}
+	return mstore.coll
+}
+
+// loadCollection returns the collection the request of ctx loads from.
+func (mstore *MongoDBStore) loadCollection(ctx context.Context) *mongo.Collection {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return rt.loadColl
+	}
+	return mstore.loadColl
+}
+
+// cacheKey returns the cache key of the session id in the collection of
+// ctx, so that a cookie presented to another tenant is not served from the
+// cache.
+func (mstore *MongoDBStore) cacheKey(ctx context.Context, id string) string {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return rt.key + "\x00" + id
+	}
+	return id
+}
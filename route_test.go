@@ -0,0 +1,85 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCollectionSelector(t *testing.T) {
+	db := newTestCollection(t).Database()
+	tenants := map[string]*mongo.Collection{
+		"a.example.com": db.Collection("mongodbstore_" + t.Name() + "_a"),
+		"b.example.com": db.Collection("mongodbstore_" + t.Name() + "_b"),
+	}
+	for _, coll := range tenants {
+		defer coll.Drop(context.Background())
+	}
+	cfg := defaultConfig
+	cfg.Cache = CacheConfig{Enabled: true}
+	cfg.CollectionSelector = func(r *http.Request) (*mongo.Collection, error) {
+		if coll, ok := tenants[r.Host]; ok {
+			return coll, nil
+		}
+		return nil, errors.New("unknown tenant " + r.Host)
+	}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	save := func(host, value string) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["tenant"] = value
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}
+	load := func(host, cookie string) interface{} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return session.Values["tenant"]
+	}
+	cookieA := save("a.example.com", "a")
+	cookieB := save("b.example.com", "b")
+
+	for host, coll := range tenants {
+		if n, _ := coll.CountDocuments(context.Background(), bson.M{}); n != 1 {
+			t.Errorf("%s: expected 1 session document; Got %d", host, n)
+		}
+		if _, ok := listTestIndexes(t, coll)[ttlIndexName]; !ok {
+			t.Errorf("%s: expected the TTL index to be created", host)
+		}
+	}
+	if got := load("a.example.com", cookieA); got != "a" {
+		t.Errorf("Expected tenant a to load its session; Got %v", got)
+	}
+	if got := load("b.example.com", cookieA); got != nil {
+		t.Errorf("Expected tenant b not to load the session of tenant a; Got %v", got)
+	}
+	if got := load("a.example.com", cookieB); got != nil {
+		t.Errorf("Expected tenant a not to load the session of tenant b; Got %v", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://c.example.com/", nil)
+	req.Header.Add("Cookie", cookieA)
+	if _, err = store.New(req, "session-key"); !errors.Is(err, ErrCollectionSelection) {
+		t.Errorf("Expected ErrCollectionSelection from New; Got %v", err)
+	}
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrCollectionSelection) {
+		t.Errorf("Expected ErrCollectionSelection from Save; Got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SaveAllError is returned by SaveAll when some of the sessions could not
+// be saved. The cookies of the other sessions are set.
+type SaveAllError struct {
+	// errors by session name
+	Errors map[string]error
+}
+
+func (e *SaveAllError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s: %v", name, e.Errors[name])
+	}
+	return "mongodbstore: error saving sessions: " + strings.Join(names, "; ")
+}
+
+// Is reports whether the error of any of the sessions matches target.
+func (e *SaveAllError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveAll saves the sessions of one request like Save, with a single bulk
+// write for all of them, e.g. for separate auth, flash and CSRF sessions.
+// With OptimisticLocking, loaded sessions are updated one by one, so that
+// each conflict is detected.
+//
+// Sessions that fail to save get no cookie and are reported in a
+// SaveAllError by name; the others are saved and get their cookie.
+func (mstore *MongoDBStore) SaveAll(r *http.Request, w http.ResponseWriter, batch ...*sessions.Session) (err error) {
+	ops := make([]*saveOp, len(batch))
+	errs := make(map[string]error)
+	if mstore.instrumenter != nil {
+		start := time.Now()
+		defer func() {
+			for i, op := range ops {
+				size, opErr := 0, err
+				if op != nil {
+					size, opErr = op.size, errs[batch[i].Name()]
+				}
+				if op != nil && op.deleting {
+					mstore.instrumenter.ObserveDelete(time.Since(start), opErr)
+				} else {
+					mstore.instrumenter.ObserveSave(time.Since(start), size, opErr)
+				}
+			}
+		}()
+	}
+	parent := r.Context()
+	if mstore.tracer != nil {
+		var span Span
+		parent, span = mstore.startSpan(parent, OpSaveAll, "bulkWrite")
+		defer func() {
+			span.SetAttribute("sessions.count", len(batch))
+			span.End(err)
+		}()
+	}
+
+	ctx, cancel := mstore.saveContext(parent)
+	defer cancel()
+	if ctx, err = mstore.routeRequest(ctx, r); err != nil {
+		return err
+	}
+	if mstore.locking.Enabled {
+		for _, session := range batch {
+			defer mstore.releaseLock(ctx, r, session)
+		}
+	}
+
+	models := make([]mongo.WriteModel, 0, len(batch))
+	modelOps := make([]*saveOp, 0, len(batch))
+	// updates run on their own rather than in the bulk write
+	var single []*saveOp
+	for i, session := range batch {
+		if mstore.beforeSave != nil && session.Options.MaxAge >= 0 {
+			if err := mstore.beforeSave(ctx, session); err != nil {
+				errs[session.Name()] = err
+				continue
+			}
+		}
+		op, err := mstore.prepareSave(ctx, r, session, time.Time{})
+		ops[i] = op
+		if err != nil {
+			errs[session.Name()] = err
+			continue
+		}
+		var model mongo.WriteModel
+		switch {
+		case op.deleting:
+			model = mongo.NewDeleteOneModel().SetFilter(op.filter)
+		case op.unchanged:
+			continue
+		case op.versioned:
+			// The bulk result can not tell a conflict from a match.
+			single = append(single, op)
+			continue
+		default:
+			// Only new sessions are inserted, as in Save.
+			model = mongo.NewUpdateOneModel().SetFilter(op.updateFilter).SetUpdate(op.update).SetUpsert(session.IsNew)
+		}
+		models = append(models, model)
+		modelOps = append(modelOps, op)
+	}
+
+	failed := make(map[*saveOp]error)
+	matched, upserted := make(map[*saveOp]bool), make(map[*saveOp]bool)
+	if len(models) > 0 {
+		res, err := mstore.bulkWrite(ctx, mstore.collection(ctx), models)
+		writeErrs := bulkWriteErrors(err, models)
+		for i, model := range models {
+			if err, ok := writeErrs[model]; ok {
+				failed[modelOps[i]] = err
+			}
+		}
+		single = append(single, bulkMatched(modelOps, res, failed, matched, upserted)...)
+		for _, op := range modelOps {
+			if op.session.IsNew && isDuplicateKey(failed[op]) {
+				// A concurrent upsert of the same ID inserted first, which
+				// the update matches on its own as in Save.
+				delete(failed, op)
+				single = append(single, op)
+			}
+		}
+	}
+	for _, op := range single {
+		res, err := mstore.updateSession(ctx, op)
+		if err != nil {
+			failed[op] = err
+			continue
+		}
+		matched[op], upserted[op] = res.MatchedCount > 0, res.UpsertedCount > 0
+	}
+
+	for i, op := range ops {
+		if _, ok := errs[batch[i].Name()]; ok {
+			continue
+		}
+		var err error
+		switch {
+		case op.deleting:
+			err = mstore.finishDelete(ctx, r, w, op, failed[op])
+		case op.unchanged:
+			err = mstore.finishUnchanged(r, w, op)
+		case failed[op] != nil:
+			err = &StorageError{"error saving session", failed[op]}
+		default:
+			err = mstore.finishSave(ctx, r, w, op, matched[op], upserted[op])
+		}
+		if err != nil {
+			errs[batch[i].Name()] = err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	for name, err := range errs {
+		errs[name] = mstore.saveError(err)
+	}
+	return &SaveAllError{Errors: errs}
+}
+
+// bulkWriteErrors maps the error of a bulk write of models to the models
+// that failed.
+func bulkWriteErrors(err error, models []mongo.WriteModel) map[mongo.WriteModel]error {
+	errs := make(map[mongo.WriteModel]error)
+	if err == nil {
+		return errs
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			errs[writeErr.Request] = writeErr.WriteError
+		}
+		return errs
+	}
+	// The writes may or may not have been applied.
+	for _, model := range models {
+		errs[model] = err
+	}
+	return errs
+}
+
+// bulkMatched records in matched and upserted which of the updates of
+// modelOps, the operations of the bulk write models by index, that did not
+// fail matched a stored document and which inserted one. The result only
+// counts the matched documents, so when some of the stored sessions were
+// not matched it returns the updates of those to run on their own, which
+// tells them apart as the updates are idempotent.
+func bulkMatched(modelOps []*saveOp, res *mongo.BulkWriteResult, failed map[*saveOp]error, matched, upserted map[*saveOp]bool) []*saveOp {
+	if res == nil {
+		return nil
+	}
+	var updates []*saveOp
+	for i, op := range modelOps {
+		if op.update == nil || failed[op] != nil {
+			continue
+		}
+		if _, ok := res.UpsertedIDs[int64(i)]; ok {
+			upserted[op] = true
+			continue
+		}
+		matched[op] = true
+		updates = append(updates, op)
+	}
+	if res.MatchedCount >= int64(len(updates)) {
+		return nil
+	}
+
+	// New sessions are upserted, so only stored ones can have missed.
+	var unsure []*saveOp
+	for _, op := range updates {
+		if !op.session.IsNew {
+			matched[op] = false
+			unsure = append(unsure, op)
+		}
+	}
+	return unsure
+}
@@ -0,0 +1,253 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var saveAllNames = []string{"auth", "flash", "csrf"}
+
+// newSessions returns the sessions of saveAllNames for req, with a value
+// each.
+func newSessions(t testing.TB, store *MongoDBStore, req *http.Request) []*sessions.Session {
+	list := make([]*sessions.Session, len(saveAllNames))
+	for i, name := range saveAllNames {
+		session, err := store.New(req, name)
+		if err != nil {
+			t.Fatalf("Error loading session %s: %v", name, err)
+		}
+		session.Values["name"] = name
+		list[i] = session
+	}
+	return list
+}
+
+// withCookies returns a request carrying the cookies set on resp.
+func withCookies(resp *httptest.ResponseRecorder) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	for _, cookie := range resp.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+// partialOps fails the bulk write model at index fail.
+type partialOps struct {
+	driverOps
+	fail int
+}
+
+func (ops partialOps) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	rest := append(append([]mongo.WriteModel(nil), models[:ops.fail]...), models[ops.fail+1:]...)
+	res, err := ops.driverOps.bulkWrite(ctx, coll, rest)
+	if err != nil {
+		return res, err
+	}
+	return res, mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{
+		WriteError: mongo.WriteError{Index: ops.fail, Code: 11000, Message: "duplicate key"},
+		Request:    models[ops.fail],
+	}}}
+}
+
+func TestSaveAll(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Retry = RetryConfig{MaxAttempts: 2}
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	ops := failOps(store, errNetwork, 1)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	if err = store.SaveAll(req, resp, newSessions(t, store, req)...); err != nil {
+		t.Fatalf("Error saving sessions: %v", err)
+	}
+	if len(resp.Result().Cookies()) != 3 {
+		t.Errorf("Expected 3 cookies; Got %v", resp.Header()["Set-Cookie"])
+	}
+	if count := countMemorySessions(t, store); count != 3 {
+		t.Errorf("Expected 3 documents; Got %d", count)
+	}
+
+	// Update two of the sessions and delete the third.
+	req = withCookies(resp)
+	list := newSessions(t, store, req)
+	for _, session := range list {
+		if session.IsNew {
+			t.Fatalf("Expected session %s to be loaded", session.Name())
+		}
+		session.Values["updated"] = true
+	}
+	list[2].Options.MaxAge = -1
+	resp = httptest.NewRecorder()
+	if err = store.SaveAll(req, resp, list...); err != nil {
+		t.Fatalf("Error saving sessions: %v", err)
+	}
+	if cookies := resp.Result().Cookies(); len(cookies) != 3 || cookies[2].MaxAge >= 0 {
+		t.Errorf("Expected 2 cookies and a deletion cookie; Got %v", resp.Header()["Set-Cookie"])
+	}
+	if count := countMemorySessions(t, store); count != 2 {
+		t.Errorf("Expected 2 documents; Got %d", count)
+	}
+	session, err := store.New(withCookies(resp), "flash")
+	if err != nil || session.IsNew || session.Values["updated"] != true {
+		t.Errorf("Expected the updated session; Got new %v with %v, %v", session.IsNew, session.Values, err)
+	}
+	if ops.calls["bulkWrite"] != 3 || ops.calls["updateOne"] != 0 || ops.calls["deleteOne"] != 0 {
+		t.Errorf("Expected one bulk write per SaveAll, retried once; Got %v", ops.calls)
+	}
+}
+
+func TestSaveAllPartialFailure(t *testing.T) {
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	store.ops = partialOps{fail: 1}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	err = store.SaveAll(req, resp, newSessions(t, store, req)...)
+	var saveErr *SaveAllError
+	if !errors.As(err, &saveErr) {
+		t.Fatalf("Expected a SaveAllError; Got %v", err)
+	}
+	if _, ok := saveErr.Errors["flash"]; !ok || len(saveErr.Errors) != 1 {
+		t.Errorf("Expected only flash to fail; Got %v", saveErr.Errors)
+	}
+	var storageErr *StorageError
+	if !errors.As(saveErr.Errors["flash"], &storageErr) || !strings.Contains(err.Error(), "flash") {
+		t.Errorf("Expected a StorageError for flash; Got %v", err)
+	}
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "flash" {
+			t.Errorf("Expected no cookie for flash; Got %v", cookie)
+		}
+	}
+	if len(resp.Result().Cookies()) != 2 {
+		t.Errorf("Expected 2 cookies; Got %v", resp.Header()["Set-Cookie"])
+	}
+}
+
+func TestSaveAllNotFound(t *testing.T) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	if err = store.SaveAll(req, resp, newSessions(t, store, req)...); err != nil {
+		t.Fatalf("Error saving sessions: %v", err)
+	}
+
+	req = withCookies(resp)
+	list := newSessions(t, store, req)
+	if _, err = store.ops.deleteOne(context.Background(), store.coll, bson.M{"name": "auth"}); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	resp = httptest.NewRecorder()
+	err = store.SaveAll(req, resp, list...)
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound; Got %v", err)
+	}
+	if saveErr := err.(*SaveAllError); len(saveErr.Errors) != 1 || saveErr.Errors["auth"] == nil {
+		t.Errorf("Expected only auth to fail; Got %v", saveErr.Errors)
+	}
+	if len(resp.Result().Cookies()) != 2 {
+		t.Errorf("Expected 2 cookies; Got %v", resp.Header()["Set-Cookie"])
+	}
+}
+
+// duplicateBulkOps fails the upserts of the first bulk write with a
+// duplicate key error, as when a concurrent request inserted the same IDs,
+// and applies its other writes.
+type duplicateBulkOps struct {
+	*memoryOps
+	failed bool
+}
+
+func (ops *duplicateBulkOps) bulkWrite(ctx context.Context, coll *mongo.Collection, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	if ops.failed {
+		return ops.memoryOps.bulkWrite(ctx, coll, models)
+	}
+	ops.failed = true
+	var rest []mongo.WriteModel
+	var writeErrs []mongo.BulkWriteError
+	for i, model := range models {
+		if update, ok := model.(*mongo.UpdateOneModel); ok && update.Upsert != nil && *update.Upsert {
+			writeErrs = append(writeErrs, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: i, Code: 11000, Message: "duplicate key"},
+				Request:    model,
+			})
+			continue
+		}
+		rest = append(rest, model)
+	}
+	res, err := ops.memoryOps.bulkWrite(ctx, coll, rest)
+	if err != nil {
+		return res, err
+	}
+	return res, mongo.BulkWriteException{WriteErrors: writeErrs}
+}
+
+func TestSaveAllDuplicateKey(t *testing.T) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	store.ops = &duplicateBulkOps{memoryOps: store.ops.(*memoryOps)}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	if err = store.SaveAll(req, resp, newSessions(t, store, req)...); err != nil {
+		t.Fatalf("Expected the duplicate key errors to be retried; Got %v", err)
+	}
+	if len(resp.Result().Cookies()) != 3 {
+		t.Errorf("Expected 3 cookies; Got %v", resp.Header()["Set-Cookie"])
+	}
+	if count := countMemorySessions(t, store); count != 3 {
+		t.Errorf("Expected 3 documents; Got %d", count)
+	}
+}
+
+func BenchmarkSaveAll(b *testing.B) {
+	store, err := NewMemoryStore(defaultConfig, []byte("secret"))
+	if err != nil {
+		b.Fatalf("Error initializing memory store: %v", err)
+	}
+	// The round trips are what SaveAll saves on a remote server.
+	ops := failOps(store, nil, 0)
+	reportRoundTrips := func(b *testing.B) {
+		b.ReportMetric(float64(ops.calls["updateOne"]+ops.calls["bulkWrite"])/float64(b.N), "roundtrips/op")
+		ops.calls = nil
+	}
+	b.Run("Sequential", func(b *testing.B) {
+		defer reportRoundTrips(b)
+		for i := 0; i < b.N; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			for _, session := range newSessions(b, store, req) {
+				if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+					b.Fatalf("Error saving session: %v", err)
+				}
+			}
+		}
+	})
+	b.Run("SaveAll", func(b *testing.B) {
+		defer reportRoundTrips(b)
+		for i := 0; i < b.N; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			if err := store.SaveAll(req, httptest.NewRecorder(), newSessions(b, store, req)...); err != nil {
+				b.Fatalf("Error saving sessions: %v", err)
+			}
+		}
+	})
+}
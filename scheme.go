@@ -0,0 +1,55 @@
+package mongodbstoregorilla
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// SecureMismatchPolicy defines how Save handles a Secure cookie for a request
+// that did not arrive over HTTPS.
+type SecureMismatchPolicy int
+
+const (
+	// SecureMismatchIgnore sets the cookie as configured.
+	SecureMismatchIgnore SecureMismatchPolicy = iota
+	// SecureMismatchWarn sets the cookie as configured and logs a warning to
+	// the configured Logger. The store can not be created with it when no
+	// Logger is set, as the warning would go nowhere.
+	SecureMismatchWarn
+	// SecureMismatchDowngrade sets the cookie without the Secure attribute.
+	SecureMismatchDowngrade
+)
+
+// cookieOptions returns the options used for the cookie of a response to r,
+// applying the SecureMismatch policy.
+func (mstore *MongoDBStore) cookieOptions(r *http.Request, opts *sessions.Options) *sessions.Options {
+	if !opts.Secure || mstore.secureMismatch == SecureMismatchIgnore || mstore.isHTTPS(r) {
+		return opts
+	}
+	if mstore.secureMismatch == SecureMismatchWarn {
+		mstore.logger.Warn("mongodbstore: Secure cookie set for a request that did not arrive over HTTPS; browsers will drop it", "op", "save", "host", r.Host)
+		return opts
+	}
+	downgraded := *opts
+	downgraded.Secure = false
+
+	return &downgraded
+}
+
+// isHTTPS reports whether r arrived over HTTPS.
+func (mstore *MongoDBStore) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !mstore.trustForwardedProto {
+		return false
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if i := strings.IndexByte(proto, ','); i >= 0 {
+		proto = proto[:i]
+	}
+
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
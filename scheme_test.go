@@ -0,0 +1,135 @@
+package mongodbstoregorilla
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSecureMismatch(t *testing.T) {
+	coll := newTestCollection(t)
+	tests := []struct {
+		name          string
+		policy        SecureMismatchPolicy
+		trustForward  bool
+		tls           bool
+		forwardProto  string
+		wantSecureSet bool
+	}{
+		{"ignore over http", SecureMismatchIgnore, false, false, "", true},
+		{"warn over http", SecureMismatchWarn, false, false, "", true},
+		{"downgrade over http", SecureMismatchDowngrade, false, false, "", false},
+		{"downgrade over https", SecureMismatchDowngrade, false, true, "", true},
+		{"downgrade behind proxy", SecureMismatchDowngrade, true, false, "https", true},
+		{"downgrade behind proxy over http", SecureMismatchDowngrade, true, false, "http", false},
+		{"downgrade with untrusted proxy header", SecureMismatchDowngrade, false, false, "https", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig
+			cfg.SessionOptions.Secure = true
+			cfg.SecureMismatch = tt.policy
+			cfg.TrustForwardedProto = tt.trustForward
+			cfg.Logger = &recordingLogger{}
+			store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+			if err != nil {
+				t.Fatalf("Error initializing mongodb store: %v", err)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwardProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardProto)
+			}
+			resp := httptest.NewRecorder()
+			session, _ := store.New(req, "session-key")
+			if err = store.Save(req, resp, session); err != nil {
+				t.Fatalf("Error saving session: %v", err)
+			}
+
+			cookie := resp.Header().Get("Set-Cookie")
+			if secure := strings.Contains(cookie, "; Secure"); secure != tt.wantSecureSet {
+				t.Errorf("Expected Secure %t; Got cookie %q", tt.wantSecureSet, cookie)
+			}
+			if !session.Options.Secure {
+				t.Error("Expected session options to be left untouched")
+			}
+		})
+	}
+}
+
+func TestSecureMismatchWarn(t *testing.T) {
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.SessionOptions.Secure = true
+	cfg.SecureMismatch = SecureMismatchWarn
+	if _, err := NewMemoryStore(cfg, []byte("secret")); err == nil {
+		t.Error("Expected an error for SecureMismatchWarn without a Logger")
+	}
+	cfg.Logger = logger
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	saveTestSession(t, store)
+	if entry := logger.find("warn", "save"); entry == nil || entry.fields["host"] != "localhost:8080" {
+		t.Errorf("Expected the warning to go to the Logger; Got %+v", entry)
+	}
+}
+
+func TestCookieOptions(t *testing.T) {
+	cfg := defaultConfig
+	cfg.CookieOptions = func(r *http.Request, base sessions.Options) sessions.Options {
+		base.Secure = r.TLS != nil
+		if strings.HasPrefix(r.Host, "widget.") {
+			base.SameSite = http.SameSiteNoneMode
+		}
+		return base
+	}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		tls        bool
+		wantSecure bool
+		wantSite   string
+	}{
+		{"http", "http://localhost:8080/", false, false, "SameSite=Lax"},
+		{"https", "https://example.com/", true, true, "SameSite=Lax"},
+		{"widget", "https://widget.example.com/", true, true, "SameSite=None"},
+	}
+	for _, tt := range tests {
+		for _, remove := range []bool{false, true} {
+			req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			resp := httptest.NewRecorder()
+			session, _ := store.New(req, "session-key")
+			if remove {
+				session.Options.MaxAge = -1
+			}
+			if err = store.Save(req, resp, session); err != nil {
+				t.Fatalf("%s: Error saving session: %v", tt.name, err)
+			}
+
+			cookie := resp.Header().Get("Set-Cookie")
+			if secure := strings.Contains(cookie, "; Secure"); secure != tt.wantSecure {
+				t.Errorf("%s: Expected Secure %t; Got cookie %q", tt.name, tt.wantSecure, cookie)
+			}
+			if !strings.Contains(cookie, tt.wantSite) {
+				t.Errorf("%s: Expected %s; Got cookie %q", tt.name, tt.wantSite, cookie)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package mongodbstoregorilla
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Serializer turns session values into bytes and back. The bytes are then
+// signed and encrypted by the securecookie codecs, unless UnsignedData is
+// set.
+type Serializer interface {
+	Serialize(values map[interface{}]interface{}) ([]byte, error)
+	Deserialize(data []byte, values *map[interface{}]interface{}) error
+}
+
+// GobSerializer encodes session values with encoding/gob, like securecookie
+// does by default. Custom types must be registered with gob.Register.
+type GobSerializer struct{}
+
+// Serialize implements Serializer.
+func (GobSerializer) Serialize(values map[interface{}]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Deserialize implements Serializer.
+func (GobSerializer) Deserialize(data []byte, values *map[interface{}]interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(values)
+}
+
+// JSONSerializer encodes session values as a JSON object, readable by
+// services not written in Go.
+//
+// Keys must be strings, in maps nested in the values as well. Values come
+// back as encoding/json decodes them into an interface{}: numbers as
+// float64, structs and maps as map[string]interface{}, slices as
+// []interface{} and time.Time as an RFC 3339 string.
+type JSONSerializer struct{}
+
+// Serialize implements Serializer.
+func (JSONSerializer) Serialize(values map[interface{}]interface{}) ([]byte, error) {
+	obj, err := jsonObject(values)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+// Deserialize implements Serializer.
+func (JSONSerializer) Deserialize(data []byte, values *map[interface{}]interface{}) error {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(obj))
+	}
+	for key, val := range obj {
+		(*values)[key] = val
+	}
+	return nil
+}
+
+// jsonObject converts map[interface{}]interface{} values, which
+// encoding/json can not encode, to map[string]interface{}.
+func jsonObject(values map[interface{}]interface{}) (map[string]interface{}, error) {
+	obj := make(map[string]interface{}, len(values))
+	for key, val := range values {
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("mongodbstore: JSON session value key %v of type %T is not a string", key, key)
+		}
+		if nested, ok := val.(map[interface{}]interface{}); ok {
+			var err error
+			if val, err = jsonObject(nested); err != nil {
+				return nil, err
+			}
+		}
+		obj[k] = val
+	}
+	return obj, nil
+}
+
+// codecSerializer adapts a Serializer to the securecookie codecs.
+type codecSerializer struct {
+	s Serializer
+}
+
+// serializedPayload is data serialized already, which codecSerializer
+// passes through.
+type serializedPayload []byte
+
+func (cs codecSerializer) Serialize(src interface{}) ([]byte, error) {
+	if payload, ok := src.(serializedPayload); ok {
+		return payload, nil
+	}
+	values, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("mongodbstore: can not serialize %T", src)
+	}
+	return cs.s.Serialize(values)
+}
+
+func (cs codecSerializer) Deserialize(src []byte, dst interface{}) error {
+	if payload, ok := dst.(*serializedPayload); ok {
+		*payload = append(serializedPayload(nil), src...)
+		return nil
+	}
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("mongodbstore: can not deserialize into %T", dst)
+	}
+	return cs.s.Deserialize(src, values)
+}
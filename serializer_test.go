@@ -0,0 +1,129 @@
+package mongodbstoregorilla
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testProfile struct {
+	Name  string
+	Roles []string
+	Since time.Time
+}
+
+func init() {
+	gob.Register(testProfile{})
+	gob.Register(time.Time{})
+	gob.Register([]int{})
+	gob.Register(map[string]string{})
+}
+
+// roundTrip saves values with store and loads them back.
+func roundTrip(t *testing.T, store *MongoDBStore, values map[interface{}]interface{}) map[interface{}]interface{} {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values = values
+	if err := store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	loaded, err := store.New(req, "session-key")
+	if err != nil || loaded.IsNew {
+		t.Fatalf("Expected the session to load; Got err %v", err)
+	}
+	return loaded.Values
+}
+
+func TestGobSerializer(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Serializer = GobSerializer{}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	values := map[interface{}]interface{}{
+		"profile": testProfile{"gopher", []string{"admin"}, since},
+		"since":   since,
+		"ids":     []int{1, 2, 3},
+		"prefs":   map[string]string{"theme": "dark"},
+		42:        "int key",
+	}
+	if got := roundTrip(t, store, values); !reflect.DeepEqual(got, values) {
+		t.Errorf("Expected %v; Got %v", values, got)
+	}
+
+	// The data is interchangeable with the securecookie default.
+	defaultStore, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	got := make(map[interface{}]interface{})
+	if err = defaultStore.decodeValues("session-key", readTestDoc(t, coll), &got); err != nil || !reflect.DeepEqual(got, values) {
+		t.Errorf("Expected the default store to decode GobSerializer data; Got %v, %v", got, err)
+	}
+}
+
+func TestJSONSerializer(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Serializer = JSONSerializer{}
+	cfg.UnsignedData = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := roundTrip(t, store, map[interface{}]interface{}{
+		"profile": testProfile{"gopher", []string{"admin"}, since},
+		"prefs":   map[interface{}]interface{}{"theme": "dark"},
+		"count":   3,
+		"ids":     []int{1, 2, 3},
+		"since":   since,
+	})
+	want := map[interface{}]interface{}{
+		"profile": map[string]interface{}{"Name": "gopher", "Roles": []interface{}{"admin"}, "Since": "2020-01-02T03:04:05Z"},
+		"prefs":   map[string]interface{}{"theme": "dark"},
+		"count":   float64(3),
+		"ids":     []interface{}{float64(1), float64(2), float64(3)},
+		"since":   "2020-01-02T03:04:05Z",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v; Got %v", want, got)
+	}
+
+	// Unsigned data is plain JSON.
+	var raw map[string]interface{}
+	if err = json.Unmarshal([]byte(readTestDoc(t, coll).Data), &raw); err != nil {
+		t.Errorf("Expected plain JSON data; Got %v", err)
+	}
+
+	if _, err = (JSONSerializer{}).Serialize(map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Error("Expected an error for a non-string key")
+	}
+	nested := map[interface{}]interface{}{"prefs": map[interface{}]interface{}{1: "one"}}
+	if _, err = (JSONSerializer{}).Serialize(nested); err == nil {
+		t.Error("Expected an error for a nested non-string key")
+	}
+}
+
+func TestJSONSerializerSigned(t *testing.T) {
+	cfg := defaultConfig
+	cfg.Serializer = JSONSerializer{}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	got := roundTrip(t, store, map[interface{}]interface{}{"foo": "bar"})
+	if got["foo"] != "bar" {
+		t.Errorf("Expected foo=bar; Got %v", got)
+	}
+}
@@ -0,0 +1,81 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StaleDataMode defines how New handles a stored session whose data can no
+// longer be decoded, e.g. because it was encoded with a retired key.
+type StaleDataMode int
+
+const (
+	// StaleDataFail returns the ErrDataDecode from New.
+	StaleDataFail StaleDataMode = iota
+
+	// StaleDataNew makes New return a new empty session with a new ID,
+	// leaving the stored document to expire.
+	StaleDataNew
+
+	// StaleDataDelete is StaleDataNew that also deletes the stored document.
+	StaleDataDelete
+)
+
+// ErrStaleSessionData matches every StaleSessionDataError with errors.Is.
+var ErrStaleSessionData = errors.New("mongodbstore: stale session data")
+
+// StaleSessionDataError is returned by New along with a new session when
+// the stored data of the session can not be decoded and StaleSessionData is
+// not StaleDataFail. The returned session is usable: Save stores it under a
+// new ID and replaces the cookie.
+type StaleSessionDataError struct {
+	// the ErrDataDecode of the stored session
+	Err error
+}
+
+func (e *StaleSessionDataError) Error() string {
+	return ErrStaleSessionData.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the decode error.
+func (e *StaleSessionDataError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrStaleSessionData.
+func (e *StaleSessionDataError) Is(target error) bool {
+	return target == ErrStaleSessionData
+}
+
+// resetStale turns session, whose stored data failed to decode with err,
+// into a new session, deleting its document with StaleDataDelete.
+func (mstore *MongoDBStore) resetStale(ctx context.Context, session *sessions.Session, err error) error {
+	if mstore.staleSessionData == StaleDataDelete && !mstore.dryRun {
+		mstore.deleteStale(ctx, session.ID)
+	}
+	session.Values = make(map[interface{}]interface{})
+	// A new ID keeps Save from overwriting the stale document.
+	session.ID = ""
+
+	return &StaleSessionDataError{Err: err}
+}
+
+// deleteStale deletes the document of the session with the given ID, only
+// logging a failure: the document expires anyway.
+func (mstore *MongoDBStore) deleteStale(ctx context.Context, id string) {
+	ID, err := mstore.docID(id)
+	if err != nil {
+		return
+	}
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, id))
+	if _, err = mstore.ops.deleteOne(ctx, mstore.collection(ctx), withTenant(bson.M{"_id": ID}, tenant)); err != nil {
+		mstore.logger.Warn("mongodbstore: error deleting stale session", "op", "load", "session", logID(id), "error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStaleSessionData(t *testing.T) {
+	for name, mode := range map[string]StaleDataMode{"StaleDataNew": StaleDataNew, "StaleDataDelete": StaleDataDelete} {
+		t.Run(name, func(t *testing.T) {
+			coll := newTestCollection(t)
+			cfg := defaultConfig
+			cfg.StaleSessionData = mode
+			store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+			if err != nil {
+				t.Fatalf("Error initializing mongodb store: %v", err)
+			}
+			cookie := saveTestSession(t, store)
+			staleID := readTestDoc(t, coll).ID
+
+			ctx := context.Background()
+			if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"data": "garbage"}}); err != nil {
+				t.Fatalf("Error corrupting session: %v", err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+			req.Header.Add("Cookie", cookie)
+			session, err := store.New(req, "session-key")
+			var staleErr *StaleSessionDataError
+			if !errors.Is(err, ErrStaleSessionData) || !errors.As(err, &staleErr) || !errors.Is(staleErr.Err, ErrDataDecode) {
+				t.Fatalf("Expected a StaleSessionDataError wrapping ErrDataDecode; Got %v", err)
+			}
+			if !session.IsNew || session.ID != "" || len(session.Values) != 0 {
+				t.Errorf("Expected a new empty session; Got %v", session.Values)
+			}
+
+			count, err := coll.CountDocuments(ctx, bson.M{"_id": staleID})
+			if err != nil {
+				t.Fatalf("Error counting sessions: %v", err)
+			}
+			if want := map[StaleDataMode]int64{StaleDataNew: 1, StaleDataDelete: 0}[mode]; count != want {
+				t.Errorf("Expected %d stale documents; Got %d", want, count)
+			}
+
+			// The next Save replaces the cookie.
+			session.Values["user"] = "bob"
+			resp := httptest.NewRecorder()
+			if err = store.Save(req, resp, session); err != nil {
+				t.Fatalf("Error saving session: %v", err)
+			}
+			replaced := resp.Header().Get("Set-Cookie")
+			if replaced == "" || replaced == cookie {
+				t.Fatalf("Expected a replacement cookie; Got %q", replaced)
+			}
+			loaded, err := loadWithCookie(store, replaced)
+			if err != nil || loaded.IsNew || loaded.Values["user"] != "bob" {
+				t.Errorf("Expected the new session to load; Got %v, %v", loaded.Values, err)
+			}
+		})
+	}
+}
+
+func TestStaleSessionDataFail(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStoreWithConfig(coll, defaultConfig, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"data": "garbage"}}); err != nil {
+		t.Fatalf("Error corrupting session: %v", err)
+	}
+	if _, err = loadWithCookie(store, cookie); !errors.Is(err, ErrDataDecode) || errors.Is(err, ErrStaleSessionData) {
+		t.Errorf("Expected ErrDataDecode by default; Got %v", err)
+	}
+}
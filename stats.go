@@ -0,0 +1,92 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StoreStats summarizes the stored sessions, as returned by Stats.
+type StoreStats struct {
+	// number of session documents, including expired ones the TTL monitor
+	// has not removed yet
+	Count int64 `bson:"count"`
+	// sessions saved or touched within the last hour and day
+	ModifiedLastHour int64 `bson:"modified_last_hour"`
+	ModifiedLastDay  int64 `bson:"modified_last_day"`
+	// average and maximum size in bytes of the stored values, whichever
+	// storage mode wrote them
+	AvgPayloadSize float64 `bson:"avg_payload_size"`
+	MaxPayloadSize int64   `bson:"max_payload_size"`
+	// Modified timestamp of the least recently saved session, zero without
+	// sessions
+	OldestModified time.Time `bson:"oldest_modified"`
+	// set when the aggregation failed and only Count is known
+	Partial bool `bson:"-"`
+}
+
+// Stats returns statistics about the sessions in the collection, e.g. for
+// capacity planning, in a single aggregation. It requires MongoDB 4.4.
+//
+// When the aggregation fails, e.g. because it exceeds the server memory
+// limit, Stats returns the Count on its own, marked Partial, along with the
+// error. The deadline of ctx also bounds the aggregation on the server.
+func (mstore *MongoDBStore) Stats(ctx context.Context) (StoreStats, error) {
+	match, err := mstore.scopeFilter(ctx, bson.M{})
+	if err != nil {
+		return StoreStats{}, err
+	}
+	now := time.Now()
+	since := func(d time.Duration) bson.M {
+		return bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$" + mstore.fieldNames.Modified, now.Add(-d)}}, 1, 0}}
+	}
+	payloadSize := bson.M{"$add": bson.A{
+		bson.M{"$strLenBytes": bson.M{"$ifNull": bson.A{"$" + mstore.fieldNames.Data, ""}}},
+		bson.M{"$ifNull": bson.A{bson.M{"$binarySize": "$encrypted"}, 0}},
+		bson.M{"$ifNull": bson.A{bson.M{"$bsonSize": "$values"}, 0}},
+	}}
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":                nil,
+			"count":              bson.M{"$sum": 1},
+			"modified_last_hour": bson.M{"$sum": since(time.Hour)},
+			"modified_last_day":  bson.M{"$sum": since(24 * time.Hour)},
+			"avg_payload_size":   bson.M{"$avg": payloadSize},
+			"max_payload_size":   bson.M{"$max": payloadSize},
+			"oldest_modified":    bson.M{"$min": "$" + mstore.fieldNames.Modified},
+		}},
+	}
+	aggregateOpts := options.Aggregate().SetAllowDiskUse(true)
+	if deadline, ok := ctx.Deadline(); ok {
+		aggregateOpts.SetMaxTime(time.Until(deadline))
+	}
+
+	var stats StoreStats
+	docs, err := mstore.ops.aggregate(ctx, mstore.coll, pipeline, aggregateOpts)
+	if err == nil && len(docs) > 0 {
+		err = bson.Unmarshal(docs[0], &stats)
+	}
+	if err != nil {
+		return mstore.partialStats(ctx, match, err)
+	}
+
+	return stats, nil
+}
+
+// partialStats returns the Count of the sessions matching filter, after
+// the aggregation of Stats failed with err.
+func (mstore *MongoDBStore) partialStats(ctx context.Context, filter bson.M, err error) (StoreStats, error) {
+	aggregateErr := &StorageError{"error aggregating session statistics", err}
+	if ctx.Err() != nil {
+		return StoreStats{}, aggregateErr
+	}
+	count, countErr := mstore.ops.countDocuments(ctx, mstore.coll, filter)
+	if countErr != nil {
+		return StoreStats{}, aggregateErr
+	}
+
+	return StoreStats{Count: count, Partial: true}, aggregateErr
+}
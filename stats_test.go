@@ -0,0 +1,91 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestStats(t *testing.T) {
+	for name, storage := range map[string]StorageMode{"encoded": StorageEncoded, "bson": StorageBSON} {
+		t.Run(name, func(t *testing.T) {
+			coll := newTestCollection(t)
+			cfg := defaultConfig
+			cfg.Storage = storage
+			store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+			if err != nil {
+				t.Fatalf("Error initializing mongodb store: %v", err)
+			}
+			for _, size := range []int{10, 1000} {
+				req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+				session, _ := store.New(req, "session-key")
+				session.Values["blob"] = strings.Repeat("x", size)
+				if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+					t.Fatalf("Error saving session: %v", err)
+				}
+			}
+			ctx := context.Background()
+			old := time.Now().Add(-48 * time.Hour).Truncate(time.Millisecond)
+			if _, err = coll.UpdateOne(ctx, bson.M{}, bson.M{"$set": bson.M{"modified": old}}); err != nil {
+				t.Fatalf("Error updating session: %v", err)
+			}
+
+			stats, err := store.Stats(ctx)
+			var cmdErr mongo.CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Code == 238 {
+				if !stats.Partial || stats.Count != 2 {
+					t.Errorf("Expected the partial count of 2 sessions; Got %+v", stats)
+				}
+				t.Skipf("The server does not support the aggregation: %v", err)
+			}
+			if err != nil {
+				t.Fatalf("Error getting stats: %v", err)
+			}
+			if stats.Count != 2 || stats.ModifiedLastHour != 1 || stats.ModifiedLastDay != 1 || stats.Partial {
+				t.Errorf("Expected 2 sessions, 1 modified recently; Got %+v", stats)
+			}
+			if stats.MaxPayloadSize < 1000 || stats.AvgPayloadSize >= float64(stats.MaxPayloadSize) {
+				t.Errorf("Expected the payload sizes of both sessions; Got %+v", stats)
+			}
+			if !stats.OldestModified.Equal(old) {
+				t.Errorf("Expected oldest modified %v; Got %v", old, stats.OldestModified)
+			}
+		})
+	}
+}
+
+func TestStatsPartial(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// E.g. exceeding the memory limit of $group.
+	memoryErr := mongo.CommandError{Code: 292, Name: "QueryExceededMemoryLimitNoDiskUseAllowed"}
+	stats, err := store.partialStats(context.Background(), bson.M{}, memoryErr)
+	var cmdErr mongo.CommandError
+	if !errors.Is(err, ErrStorage) || !errors.As(err, &cmdErr) || cmdErr.Code != 292 {
+		t.Errorf("Expected the aggregation error; Got %v", err)
+	}
+	if !stats.Partial || stats.Count != 1 {
+		t.Errorf("Expected the partial count of 1 session; Got %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if stats, err = store.Stats(ctx); err == nil || stats.Partial {
+		t.Errorf("Expected a cancelled context to fail without partial stats; Got %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,148 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageMode defines how session values are stored in the session document.
+type StorageMode int
+
+const (
+	// StorageEncoded stores the values as an opaque string encoded with the
+	// securecookie codecs in the data field.
+	StorageEncoded StorageMode = iota
+
+	// StorageBSON stores the values as a native BSON subdocument in the
+	// values field, so they can be queried and indexed, e.g.
+	// {"values.user_id": 42}. The cookie still only carries the signed
+	// session ID, but the values are readable by anyone with access to the
+	// collection.
+	//
+	// Keys must be strings and values must be representable in BSON;
+	// otherwise Save returns ErrUnsupportedBSONValue. Values come back as
+	// the BSON driver decodes them: integers as int32 or int64, nested
+	// documents as map[string]interface{}, arrays as []interface{} and
+	// datetimes as time.Time in UTC.
+	StorageBSON
+)
+
+// ErrUnsupportedBSONValue is returned by Save in StorageBSON mode when the
+// session values can not be represented in BSON.
+var ErrUnsupportedBSONValue = errors.New("mongodbstore: session value can not be stored as BSON")
+
+// storeValues fills the payload field of sessDoc for the storage mode.
+func (mstore *MongoDBStore) storeValues(name string, values map[interface{}]interface{}, sessDoc *sessionDoc) error {
+	if mstore.storage != StorageBSON {
+		return mstore.encodeValues(name, values, sessDoc)
+	}
+
+	doc := make(bson.M, len(values))
+	for key, val := range values {
+		k, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("%w: key %v of type %T is not a string", ErrUnsupportedBSONValue, key, key)
+		}
+		doc[k] = val
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedBSONValue, err)
+	}
+	sessDoc.Values = raw
+
+	return nil
+}
+
+// unusedPayloadFields returns the payload fields that are not set in
+// sessDoc, to be cleared when it is written.
+func (names FieldNames) unusedPayloadFields(sessDoc *sessionDoc) []string {
+	var fields []string
+	if sessDoc.Data == "" {
+		fields = append(fields, names.Data)
+	}
+	if sessDoc.Values == nil {
+		fields = append(fields, "values")
+	}
+	if sessDoc.Encrypted == nil {
+		fields = append(fields, "encrypted")
+	}
+	if sessDoc.Compression == "" {
+		fields = append(fields, "compression")
+	}
+	return fields
+}
+
+// loadValues decodes the payload of sessDoc into values, whichever storage
+// mode wrote it.
+func (mstore *MongoDBStore) loadValues(name string, sessDoc *sessionDoc, values *map[interface{}]interface{}) error {
+	var err error
+	switch {
+	case sessDoc.Values != nil:
+		err = loadBSON(sessDoc.Values, values)
+	case mstore.reencodeOnLoad && !mstore.unsigned:
+		err = mstore.decodeTrackingKey(name, sessDoc, values)
+	default:
+		err = mstore.decodeValues(name, sessDoc, values)
+	}
+	if err != nil && mstore.legacyCompat && isLegacy(sessDoc) {
+		if mstore.decodeLegacy(name, sessDoc, values) == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDataDecode, err)
+	}
+	if mstore.legacyCompat && sessDoc.SchemaVersion == 0 {
+		sessDoc.legacy = true
+	}
+
+	return nil
+}
+
+// loadBSON decodes values stored in StorageBSON mode.
+func loadBSON(raw bson.Raw, values *map[interface{}]interface{}) error {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(doc))
+	}
+	for _, elem := range doc {
+		(*values)[elem.Key] = fromBSON(elem.Value)
+	}
+
+	return nil
+}
+
+// fromBSON converts the driver's generic BSON types into plain Go types.
+func fromBSON(val interface{}) interface{} {
+	switch v := val.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, elem := range v {
+			m[elem.Key] = fromBSON(elem.Value)
+		}
+		return m
+	case primitive.M:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = fromBSON(elem)
+		}
+		return m
+	case primitive.A:
+		a := make([]interface{}, len(v))
+		for i, elem := range v {
+			a[i] = fromBSON(elem)
+		}
+		return a
+	case primitive.DateTime:
+		return v.Time().UTC()
+	default:
+		return val
+	}
+}
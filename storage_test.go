@@ -0,0 +1,123 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStorageBSON(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Storage = StorageBSON
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.Get(req, "session-key")
+	session.Values["user_id"] = 42
+	session.Values["roles"] = []string{"admin"}
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	n, err := coll.CountDocuments(context.Background(), bson.M{"values.user_id": 42})
+	if err != nil {
+		t.Fatalf("Error querying session values: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected the session to be found by its values, got %d documents", n)
+	}
+	if doc := readTestDoc(t, coll); doc.Data != "" {
+		t.Error("Expected no encoded data in BSON storage mode")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.Get(req, "session-key")
+	if err != nil {
+		t.Fatalf("Error getting session: %v", err)
+	}
+	if session.IsNew {
+		t.Fatal("Expected the saved session to be loaded")
+	}
+	if v, ok := session.Values["user_id"].(int32); !ok || v != 42 {
+		t.Errorf("Expected user_id 42, got %#v", session.Values["user_id"])
+	}
+	if v, ok := session.Values["roles"].([]interface{}); !ok || len(v) != 1 || v[0] != "admin" {
+		t.Errorf("Expected roles [admin], got %#v", session.Values["roles"])
+	}
+
+	// Switching back to encoded storage still reads and rewrites the session.
+	store.storage = StorageEncoded
+	session, _ = store.New(req, "session-key")
+	if session.IsNew || session.Values["user_id"] == nil {
+		t.Fatal("Expected the BSON session to be loaded in encoded mode")
+	}
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); doc.Data == "" || doc.Values != nil {
+		t.Error("Expected the session to be rewritten as encoded data")
+	}
+}
+
+func TestStorageBSONUnsupported(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Storage = StorageBSON
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	for name, values := range map[string]map[interface{}]interface{}{
+		"non-string key": {1: "one"},
+		"chan value":     {"ch": make(chan int)},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values = values
+		if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrUnsupportedBSONValue) {
+			t.Errorf("%s: expected ErrUnsupportedBSONValue, got %v", name, err)
+		}
+	}
+}
+
+func TestStorageBSONRollout(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user_id"] = 42
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	// Sessions encoded before the switch keep loading in BSON mode.
+	store.storage = StorageBSON
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["user_id"] != 42 {
+		t.Fatalf("Expected the encoded session to load in BSON mode; Got %v, %v", session.Values, err)
+	}
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); doc.Data != "" || doc.Values == nil {
+		t.Error("Expected the session to be rewritten as BSON values")
+	}
+}
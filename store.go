@@ -2,24 +2,92 @@ package mongodbstoregorilla
 
 import (
 	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // MongoDBStore stores sessions using mongoDB as backend.
 type MongoDBStore struct {
-	coll    *mongo.Collection
-	codecs  []securecookie.Codec
-	options sessions.Options
+	coll     *mongo.Collection
+	loadColl *mongo.Collection
+	options  sessions.Options
+	dryRun   bool
+
+	mu          sync.RWMutex
+	codecs      []securecookie.Codec
+	dataCodecs  []securecookie.Codec
+	codecMaxAge int
+	// whether codecMaxAge was configured with CodecMaxAge
+	codecAgeSet bool
+	serializer  Serializer
+	unsigned    bool
+	compression *Compression
+	encryption  []cipher.AEAD
+
+	perNameMaxAge       map[string]int
+	secureMismatch      SecureMismatchPolicy
+	trustForwardedProto bool
+	tenantFunc          func(ctx context.Context) string
+	userIDKey           string
+	writerTag           string
+	operationTimeout    time.Duration
+	touchInterval       time.Duration
+	storage             StorageMode
+	reencodeOnLoad      bool
+	reencodeImmediately bool
+	idGenerator         IDGenerator
+	expiresAtTTL        bool
+	instrumenter        Instrumenter
+	logger              Logger
+	indexedFieldNames   map[string]string
+	absoluteMaxAge      int
+	serverSideTTL       time.Duration
+	cache               *sessionCache
+	onInvalidate        func(sessionID string)
+	indexTTL            bool
+	pingReadPreference  *readpref.ReadPref
+	skipUnmodified      bool
+	collectionSelector  func(r *http.Request) (*mongo.Collection, error)
+	legacyCompat        bool
+	optimisticLocking   bool
+	tracer              Tracer
+	modifiedValue       bool
+	modifiedWarning     sync.Once
+	maxLength           int
+	deleteExpiredOnLoad bool
+	ttlIndexOptions     TTLIndexOptions
+	degradedMode        DegradedMode
+	serveStaleOnError   bool
+	retryConfig         RetryConfig
+	ops                 collectionOps
+	fieldNames          FieldNames
+	cookieOptionsFunc   func(r *http.Request, base sessions.Options) sessions.Options
+	slidingExpiration   float64
+	transport           TokenTransport
+	watchMu             sync.Mutex
+	watch               *watcher
+	locking             LockingConfig
+	events              EventInstrumenter
+	afterLoad           SessionHook
+	beforeSave          SessionHook
+	staleSessionData    StaleDataMode
+	clearInvalidCookies bool
+	purgeBatchSize      int
+	routes              sync.Map
+	concerns            MongoDBStoreConfig
 }
 
 // MongoDBStoreConfig is a configuration options for MongoDBStore
@@ -31,38 +99,538 @@ type MongoDBStoreConfig struct {
 
 	// gorilla-sessions options
 	SessionOptions sessions.Options
+
+	// MaxAge applied to the securecookie codecs, i.e. how long a cookie
+	// signature stays valid. nil keeps it equal to the largest configured
+	// MaxAge, or ServerSideTTL for MaxAge 0; 0 disables the timestamp check
+	// so that the database alone controls expiry.
+	CodecMaxAge *int
+
+	// MaxAge per session name, overriding SessionOptions.MaxAge for the
+	// sessions created by New. The expiry of every document is computed from
+	// its own MaxAge. The TTL index still uses SessionOptions.MaxAge, so it
+	// should be the largest value.
+	PerNameMaxAge map[string]int
+
+	// report what maintenance operations such as SweepOnce would delete
+	// without modifying any data
+	DryRun bool
+
+	// what Save does when the cookie is Secure but the request did not
+	// arrive over HTTPS, which makes browsers silently drop the cookie
+	SecureMismatch SecureMismatchPolicy
+
+	// whether to trust the X-Forwarded-Proto header set by a TLS terminating
+	// proxy when deciding if a request arrived over HTTPS
+	TrustForwardedProto bool
+
+	// returns the tenant of the request context. When set, every session
+	// is stamped with an indexed tenant_id and only loaded, saved or deleted
+	// for the same tenant. An empty tenant is an ErrMissingTenant error.
+	TenantFunc func(ctx context.Context) string
+
+	// session value key holding the user ID. When set, Save copies the value
+	// into an indexed user_id field so that sessions can be found by user,
+	// e.g. with DeleteOtherSessions.
+	UserIDKey string
+
+	// version or build tag stored in the writer field of every saved
+	// session, to find sessions written by a given deploy. It is never read
+	// back by the store.
+	WriterTag string
+
+	// timeout applied to the mongoDB operations of every New and Save when
+	// the request context has no deadline, 0 for none
+	OperationTimeout time.Duration
+
+	// enables sliding expiration: New bumps the modified timestamp of a
+	// loaded session once it is older than TouchInterval, without
+	// re-encoding its data, and Save skips the write for sessions whose
+	// values and MaxAge did not change. 0 rewrites the session on every Save.
+	TouchInterval time.Duration
+
+	// how session values are stored in the document, StorageEncoded by
+	// default
+	Storage StorageMode
+
+	// re-encode sessions with the newest key pair when they are loaded and
+	// were encoded with an older one. The data is written back by the next
+	// Save, even an unchanged one with TouchInterval, or right away by New
+	// when ReencodeImmediately is set.
+	ReencodeOnLoad      bool
+	ReencodeImmediately bool
+
+	// generates the session IDs, nil for ObjectIDs
+	IDGenerator IDGenerator
+
+	// key the TTL index on the expires_at field with expireAfterSeconds 0,
+	// so that every session expires after its own Options.MaxAge, e.g. long
+	// remember-me sessions next to short ones. Switching replaces the index
+	// on modified. Sessions saved with MaxAge 0 expire after
+	// ServerSideTTL.
+	ExpiresAtTTL bool
+
+	// read preference, read concern and write concern of every operation,
+	// nil to keep those of the collection passed to the constructor
+	ReadPreference *readpref.ReadPref
+	ReadConcern    *readconcern.ReadConcern
+	WriteConcern   *writeconcern.WriteConcern
+
+	// read preference for loading sessions in New, nil for ReadPreference.
+	// Reading from secondaries offloads the primary, but a secondary may lag
+	// behind: a session saved by one request can look missing or stale to
+	// the next, which then starts a new session. Combine it with a majority
+	// WriteConcern and ReadConcern, or keep it for anonymous traffic.
+	LoadReadPreference *readpref.ReadPref
+
+	// observes the latency and outcome of loading, saving and deleting
+	// sessions, nil for none
+	Instrumenter Instrumenter
+
+	// receives warnings such as stored data that fails to decode and TTL
+	// index fallbacks, nil for none; wrap it in a RateLimitedLogger to
+	// bound the entries of frequent events
+	Logger Logger
+
+	// session value keys copied to indexed top-level fields of the document
+	// on Save, keyed by value key, e.g. {"uid": "uid"}, to find or delete
+	// sessions with FindByField and DeleteByField. Strings, booleans and
+	// numbers keep their type, other values are stored as a string.
+	IndexedFields map[string]string
+
+	// maximum lifetime of a session in seconds since it was created,
+	// however active it is, 0 for none. Older sessions are not loaded and
+	// Save stores them under a new ID. Their expires_at is capped at it, so
+	// that the ExpiresAtTTL index removes them by then.
+	AbsoluteMaxAge int
+
+	// serializes the session values before the codecs sign and encrypt
+	// them, nil for the securecookie default, which GobSerializer encodes
+	// identically
+	Serializer Serializer
+
+	// store the serialized values as is, neither signed nor encrypted, so
+	// that other services can read them, e.g. with JSONSerializer. Only
+	// use it when the collection itself is trusted.
+	UnsignedData bool
+
+	// compress stored session data above a minimum size, nil for none.
+	// Documents stored uncompressed keep loading.
+	Compression *Compression
+
+	// encrypt stored session data with AES-GCM using the first of these
+	// keys instead of the codecs, and decrypt it with any of them. Does not
+	// apply to StorageBSON.
+	EncryptionKeys [][]byte
+
+	// how long the document of a session with MaxAge 0, i.e. a browser
+	// session cookie, is kept after its last Save, 0 for
+	// DefaultServerSideTTL. It takes the place of MaxAge for the expiry of
+	// the document, the TTL index and the codecs.
+	ServerSideTTL time.Duration
+
+	// cache loaded sessions in process, see CacheConfig
+	Cache CacheConfig
+
+	// called by WatchInvalidations for every deleted or replaced session
+	OnInvalidate func(sessionID string)
+
+	// Save neither writes a loaded session whose values and options did
+	// not change nor sets its cookie, unless TouchInterval or
+	// SlidingExpiration bumped its modified timestamp. Without them such
+	// sessions expire MaxAge after their last change.
+	SkipUnmodified bool
+
+	// ping the server on construction, failing with ErrAuthentication or
+	// ErrUnreachable
+	ValidateOnStartup bool
+
+	// picks the collection New and Save use for a request, e.g. one per
+	// tenant by Host header, nil for the collection of the store. The
+	// concerns above apply to it and its indexes are created on first use.
+	// Methods that take no request, such as DeleteByID, List or the cleanup,
+	// keep using the collection of the store.
+	CollectionSelector func(r *http.Request) (*mongo.Collection, error)
+
+	// load documents written by kidstuff/mongostore, or by this store with
+	// another Serializer, UnsignedData or compression, whose data the
+	// current settings can not decode. Such documents, and all documents
+	// without a schema version, are rewritten in the current format by the
+	// next Save even when their values did not change. Their cookies need
+	// no conversion: both encode the session ID with the codecs.
+	LegacyCompat bool
+
+	// make Save fail with ErrConcurrentModification instead of overwriting
+	// a session that another request saved since it was loaded
+	OptimisticLocking bool
+
+	// starts a span around loading, saving and deleting sessions, nil for
+	// none, see the otelstore package
+	Tracer Tracer
+
+	// take the modified timestamp of the document from the "modified"
+	// session value, if any, and fail Save with ErrInvalidModified when it
+	// is not a time.Time.
+	//
+	// Deprecated: use SaveWithModified. Without it "modified" is an
+	// ordinary session value.
+	ModifiedValue bool
+
+	// maximum size in bytes of the stored payload of a session, after
+	// encoding, compression and encryption, nil for DefaultMaxLength, 0 for
+	// unlimited. Save returns a SessionTooLargeError for larger sessions.
+	MaxLength *int
+
+	// delete the document of an expired session in the background when it
+	// is loaded, rather than leaving it to the TTL monitor or cleanup
+	DeleteExpiredOnLoad bool
+
+	// name and options of the TTL index created with IndexTTL
+	TTLIndexOptions TTLIndexOptions
+
+	// how New and Save behave while mongoDB is unavailable, FailClosed by
+	// default
+	DegradedMode DegradedMode
+
+	// serve a session from the cache when loading it fails with a
+	// StorageError, as long as it was cached within CacheConfig.MaxStaleness
+	// and has not expired; other sessions fail as DegradedMode defines.
+	// SessionMeta reports such sessions as Stale. It requires Cache to be
+	// enabled, and is implied by FailOpenStale.
+	ServeStaleOnError bool
+
+	// retries of the operations of New, Save, SaveAll and Touch that fail
+	// with a transient error, none by default
+	Retry RetryConfig
+
+	// names of the stored document fields, DefaultFieldNames by default
+	FieldNames FieldNames
+
+	// returns the options of a session New builds for a request, e.g. to
+	// set Secure only over TLS, given the configured SessionOptions with the
+	// MaxAge for the session name
+	CookieOptions func(r *http.Request, base sessions.Options) sessions.Options
+
+	// fraction of the MaxAge of a loaded session after which New bumps its
+	// modified timestamp like TouchInterval, e.g. 0.5, 0 for none. Refresh
+	// re-issues the cookie of such sessions.
+	SlidingExpiration float64
+
+	// key pairs of the codecs, ahead of the ones passed to the constructor,
+	// as for SetKeyPairs
+	KeyPairs [][]byte
+
+	// carries the session tokens to and from clients, nil for cookies,
+	// e.g. HeaderTransport for clients without cookies
+	Transport TokenTransport
+
+	// create the collection of the store with these options on construction
+	// when it does not exist, for deployments that do not allow creating it
+	// implicitly, nil to leave it to the first write
+	CreateCollection *CollectionOptions
+
+	// lock sessions for the duration of a request, see LockingConfig
+	Locking LockingConfig
+
+	// called by New with every session it loaded, its error returned by
+	// New, see SessionHook
+	AfterLoad SessionHook
+	// called by Save and SaveAll before writing a session, its error
+	// vetoing the save, but not for sessions deleted with a negative MaxAge
+	BeforeSave SessionHook
+
+	// how New handles stored data that can not be decoded, StaleDataFail
+	// by default
+	StaleSessionData StaleDataMode
+
+	// make GetWithResponse expire invalid and expired session cookies
+	ClearInvalidCookies bool
+
+	// number of documents PurgeExpired and PurgeOlderThan delete per
+	// batch, 0 for DefaultPurgeBatchSize
+	PurgeBatchSize int
 }
 
 type sessionDoc struct {
-	ID       primitive.ObjectID `bson:"_id"`
-	Data     string             `bson:"data"`
-	Modified time.Time          `bson:"modified"`
+	ID            interface{} `bson:"_id"`
+	Data          string      `bson:"data,omitempty"`
+	Values        bson.Raw    `bson:"values,omitempty"`
+	Modified      time.Time   `bson:"modified"`
+	ExpiresAt     time.Time   `bson:"expires_at,omitempty"`
+	Created       time.Time   `bson:"created,omitempty"`
+	SchemaVersion int         `bson:"schema_version"`
+	Encrypted     []byte      `bson:"encrypted,omitempty"`
+	Compression   string      `bson:"compression,omitempty"`
+
+	// incremented by every Save with OptimisticLocking
+	Version int64 `bson:"version,omitempty"`
+
+	// session name the data was encoded for
+	Name string `bson:"name,omitempty"`
+	// set for sessions created by Create until they are claimed
+	Pending bool `bson:"pending,omitempty"`
+
+	TenantID string `bson:"tenant_id,omitempty"`
+	UserID   string `bson:"user_id,omitempty"`
+	Writer   string `bson:"writer,omitempty"`
+	// IndexedFields values
+	Fields bson.M `bson:",inline"`
+
+	// set by load when the data was encoded with an older key pair
+	stale bool
+	// set by load with LegacyCompat for documents in an older format
+	legacy bool
+	// set by loadDegraded for a document served from the cache while the
+	// database is unavailable
+	servedStale bool
 }
 
+// DefaultMaxLength is the MaxLength of a store when none is configured.
+const DefaultMaxLength = 64 * 1024
+
+// schemaVersion is the version of the session document layout written by
+// Save. Documents with an older version are upgraded by Compact. Version 2
+// added the created and expires_at fields.
+const schemaVersion = 2
+
 var defaultConfig = MongoDBStoreConfig{
 	IndexTTL: true,
 	SessionOptions: sessions.Options{
 		Path:     "/",
 		MaxAge:   3600 * 24 * 30,
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	},
 }
 
 // NewMongoDBStoreWithConfig returns a new NewMongoDBStore with a custom MongoDBStoreConfig
 func NewMongoDBStoreWithConfig(coll *mongo.Collection, cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
-	codecs := securecookie.CodecsFromPairs(keyPairs...)
-	for _, codec := range codecs {
-		if sc, ok := codec.(*securecookie.SecureCookie); ok {
-			sc.MaxAge(cfg.SessionOptions.MaxAge)
+	return NewMongoDBStoreWithContext(context.Background(), coll, cfg, keyPairs...)
+}
+
+// NewMongoDBStoreWithContext is like NewMongoDBStoreWithConfig, but bounds
+// the index creation by ctx.
+func NewMongoDBStoreWithContext(ctx context.Context, coll *mongo.Collection, cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
+	store, err := newStore(coll, cfg, keyPairs...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ValidateOnStartup {
+		if err := store.pingServer(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CreateCollection != nil {
+		if err := store.createCollection(ctx, cfg.CreateCollection); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, store.ensureIndexes(ctx)
+}
+
+// newStore builds the store for cfg without touching the server.
+func newStore(coll *mongo.Collection, cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
+	if cfg.ServerSideTTL <= 0 {
+		cfg.ServerSideTTL = DefaultServerSideTTL
+	}
+	codecMaxAge := largestRetention(cfg.SessionOptions.MaxAge, cfg.PerNameMaxAge, cfg.ServerSideTTL)
+	if cfg.CodecMaxAge != nil {
+		codecMaxAge = *cfg.CodecMaxAge
+	}
+	maxLength := DefaultMaxLength
+	if cfg.MaxLength != nil {
+		maxLength = *cfg.MaxLength
+	}
+	coll, loadColl, err := configureCollection(coll, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var encryption []cipher.AEAD
+	if len(cfg.EncryptionKeys) > 0 {
+		if encryption, err = newAEADs(cfg.EncryptionKeys); err != nil {
+			return nil, err
+		}
+	}
+	store := &MongoDBStore{
+		coll:        coll,
+		loadColl:    loadColl,
+		codecMaxAge: codecMaxAge,
+		codecAgeSet: cfg.CodecMaxAge != nil,
+		options:     cfg.SessionOptions,
+		dryRun:      cfg.DryRun,
+		serializer:  cfg.Serializer,
+		unsigned:    cfg.UnsignedData,
+		compression: cfg.Compression,
+		encryption:  encryption,
+
+		perNameMaxAge:       cfg.PerNameMaxAge,
+		secureMismatch:      cfg.SecureMismatch,
+		trustForwardedProto: cfg.TrustForwardedProto,
+		tenantFunc:          cfg.TenantFunc,
+		userIDKey:           cfg.UserIDKey,
+		writerTag:           cfg.WriterTag,
+		operationTimeout:    cfg.OperationTimeout,
+		touchInterval:       cfg.TouchInterval,
+		storage:             cfg.Storage,
+		reencodeOnLoad:      cfg.ReencodeOnLoad,
+		reencodeImmediately: cfg.ReencodeImmediately,
+		idGenerator:         cfg.IDGenerator,
+		expiresAtTTL:        cfg.ExpiresAtTTL,
+		instrumenter:        cfg.Instrumenter,
+		logger:              cfg.Logger,
+		indexedFieldNames:   cfg.IndexedFields,
+		absoluteMaxAge:      cfg.AbsoluteMaxAge,
+		cache:               newSessionCache(cfg.Cache),
+		onInvalidate:        cfg.OnInvalidate,
+		indexTTL:            cfg.IndexTTL,
+		skipUnmodified:      cfg.SkipUnmodified,
+		collectionSelector:  cfg.CollectionSelector,
+		legacyCompat:        cfg.LegacyCompat,
+		optimisticLocking:   cfg.OptimisticLocking,
+		tracer:              cfg.Tracer,
+		modifiedValue:       cfg.ModifiedValue,
+		maxLength:           maxLength,
+		deleteExpiredOnLoad: cfg.DeleteExpiredOnLoad,
+		ttlIndexOptions:     cfg.TTLIndexOptions,
+		degradedMode:        cfg.DegradedMode,
+		serveStaleOnError:   cfg.ServeStaleOnError || cfg.DegradedMode == FailOpenStale,
+		retryConfig:         cfg.Retry,
+		ops:                 driverOps{},
+		fieldNames:          cfg.FieldNames,
+		cookieOptionsFunc:   cfg.CookieOptions,
+		slidingExpiration:   cfg.SlidingExpiration,
+		transport:           cfg.Transport,
+		locking:             cfg.Locking.withDefaults(),
+		afterLoad:           cfg.AfterLoad,
+		beforeSave:          cfg.BeforeSave,
+		staleSessionData:    cfg.StaleSessionData,
+		clearInvalidCookies: cfg.ClearInvalidCookies,
+		purgeBatchSize:      cfg.PurgeBatchSize,
+		pingReadPreference:  cfg.ReadPreference,
+		serverSideTTL:       cfg.ServerSideTTL,
+		concerns: MongoDBStoreConfig{
+			ReadPreference:     cfg.ReadPreference,
+			ReadConcern:        cfg.ReadConcern,
+			WriteConcern:       cfg.WriteConcern,
+			LoadReadPreference: cfg.LoadReadPreference,
+		},
+	}
+	if store.logger == nil {
+		store.logger = noopLogger{}
+	}
+	if store.transport == nil {
+		store.transport = CookieTransport{}
+	}
+	if store.purgeBatchSize <= 0 {
+		store.purgeBatchSize = DefaultPurgeBatchSize
+	}
+	store.events, _ = cfg.Instrumenter.(EventInstrumenter)
+	if cfg.LoadReadPreference != nil {
+		store.pingReadPreference = cfg.LoadReadPreference
+	}
+	store.SetKeyPairs(append(cfg.KeyPairs[:len(cfg.KeyPairs):len(cfg.KeyPairs)], keyPairs...)...)
+
+	if store.fieldNames == (FieldNames{}) {
+		store.fieldNames = DefaultFieldNames
+	}
+	if store.fieldNames.SchemaVersion == "" {
+		store.fieldNames.SchemaVersion = DefaultFieldNames.SchemaVersion
+	}
+	if err := store.fieldNames.validate(); err != nil {
+		return nil, err
+	}
+	if len(store.indexedFieldNames) > 0 {
+		if err := validateIndexedFields(store.indexedFieldNames, store.fieldNames); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.ttlIndexOptions.validate(); err != nil {
+		return nil, err
+	}
+	if store.slidingExpiration < 0 || store.slidingExpiration > 1 {
+		return nil, errors.New("mongodbstore: SlidingExpiration must be between 0 and 1")
+	}
+	if store.secureMismatch == SecureMismatchWarn && cfg.Logger == nil {
+		return nil, errors.New("mongodbstore: SecureMismatchWarn requires a Logger")
+	}
+
+	return store, nil
+}
+
+// ensureIndexes creates the indexes the configuration of the store needs in
+// the collection of ctx.
+func (mstore *MongoDBStore) ensureIndexes(ctx context.Context) (err error) {
+	if mstore.tracer != nil {
+		var span Span
+		ctx, span = mstore.startSpan(ctx, OpEnsureIndexes, "createIndexes")
+		defer func() { span.End(err) }()
+	}
+	if mstore.tenantFunc != nil {
+		if err := mstore.ensureTenantIndex(ctx); err != nil {
+			return err
+		}
+	}
+	if mstore.userIDKey != "" {
+		if err := mstore.ensureUserIDIndex(ctx); err != nil {
+			return err
+		}
+	}
+	if len(mstore.indexedFieldNames) > 0 {
+		if err := mstore.ensureFieldIndexes(ctx); err != nil {
+			return err
 		}
 	}
-	store := &MongoDBStore{coll, codecs, cfg.SessionOptions}
+	if !mstore.indexTTL {
+		return nil
+	}
+
+	return mstore.ensureIndexTTL(ctx)
+}
+
+// configureCollection applies the concerns of cfg to coll and returns it
+// with the collection used for loading sessions.
+func configureCollection(coll *mongo.Collection, cfg MongoDBStoreConfig) (*mongo.Collection, *mongo.Collection, error) {
+	opts := options.Collection()
+	if cfg.ReadPreference != nil {
+		opts.SetReadPreference(cfg.ReadPreference)
+	}
+	if cfg.ReadConcern != nil {
+		opts.SetReadConcern(cfg.ReadConcern)
+	}
+	if cfg.WriteConcern != nil {
+		opts.SetWriteConcern(cfg.WriteConcern)
+	}
+	coll, err := coll.Clone(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongodbstore: error configuring collection: %w", err)
+	}
+	if cfg.LoadReadPreference == nil {
+		return coll, coll, nil
+	}
+	loadColl, err := coll.Clone(options.Collection().SetReadPreference(cfg.LoadReadPreference))
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongodbstore: error configuring collection: %w", err)
+	}
 
-	if !cfg.IndexTTL {
-		return store, nil
+	return coll, loadColl, nil
+}
+
+// DefaultCollectionName is the collection NewMongoDBStoreFromDatabase uses
+// when given no name.
+const DefaultCollectionName = "sessions"
+
+// NewMongoDBStoreFromDatabase is like NewMongoDBStoreWithContext for the
+// collection collectionName of db, or DefaultCollectionName when empty. It
+// always validates the connection as with ValidateOnStartup, so that a wrong
+// URI or credentials fail at startup rather than on the first request.
+func NewMongoDBStoreFromDatabase(ctx context.Context, db *mongo.Database, collectionName string, cfg MongoDBStoreConfig, keyPairs ...[]byte) (*MongoDBStore, error) {
+	if collectionName == "" {
+		collectionName = DefaultCollectionName
 	}
+	cfg.ValidateOnStartup = true
 
-	return store, store.ensureIndexTTL()
+	return NewMongoDBStoreWithContext(ctx, db.Collection(collectionName), cfg, keyPairs...)
 }
 
 // NewMongoDBStore returns a new NewMongoDBStore with default config
@@ -73,6 +641,7 @@ func NewMongoDBStoreWithConfig(coll *mongo.Collection, cfg MongoDBStoreConfig, k
 // 		Path:     "/",
 // 		MaxAge:   3600 * 24 * 30,
 // 		HttpOnly: true,
+// 		SameSite: http.SameSiteLaxMode,
 // 	},
 // }
 func NewMongoDBStore(col *mongo.Collection, keyPairs ...[]byte) (*MongoDBStore, error) {
@@ -85,36 +654,137 @@ func NewMongoDBStore(col *mongo.Collection, keyPairs ...[]byte) (*MongoDBStore,
 // the session to check if it is an existing session or a new one.
 //
 // It returns a new session and an error if the session exists but could
-// not be decoded.
+// not be decoded. For a cookie that is invalid or expired, the error is
+// ErrInvalidCookie or ErrCookieExpired and the new session can be used as
+// is, e.g. for an anonymous user, and Save replaces the cookie. See
+// GetWithResponse to expire the cookie right away.
 func (mstore *MongoDBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
 	return sessions.GetRegistry(r).Get(mstore, name)
 }
 
+// GetWithResponse returns a session like Get. With ClearInvalidCookies, a
+// cookie that is invalid or expired is also expired in the response w, so
+// that the browser stops sending it even when the request is aborted, and
+// the new session is returned without an error.
+func (mstore *MongoDBStore) GetWithResponse(r *http.Request, w http.ResponseWriter, name string) (*sessions.Session, error) {
+	session, err := mstore.Get(r, name)
+	if !mstore.clearInvalidCookies || !(errors.Is(err, ErrInvalidCookie) || errors.Is(err, ErrCookieExpired)) {
+		return session, err
+	}
+	mstore.logger.Debug("mongodbstore: cleared invalid session cookie", "op", "load", "name", name, "error", err)
+	mstore.DeleteCookie(w, session)
+
+	return session, nil
+}
+
 // New returns a session for the given name without adding it to the registry.
 //
 // The difference between New() and Get() is that calling New() twice will
 // decode the session data twice, while Get() registers and reuses the same
 // decoded session after the first call.
 func (mstore *MongoDBStore) New(r *http.Request, name string) (*sessions.Session, error) {
-	session := sessions.NewSession(mstore, name)
+	return mstore.newSession(r, name, mstore)
+}
+
+// newSession builds the session for New, binding it to store so that
+// session.Save goes through wrappers such as RateLimitedStore.
+func (mstore *MongoDBStore) newSession(r *http.Request, name string, store sessions.Store) (session *sessions.Session, err error) {
+	session = sessions.NewSession(store, name)
 	options := mstore.options
+	options.MaxAge = mstore.maxAge(name)
+	if mstore.cookieOptionsFunc != nil {
+		options = mstore.cookieOptionsFunc(r, options)
+	}
 	session.Options = &options
 	session.IsNew = true
 
-	cookie, err := r.Cookie(name)
+	token, err := mstore.transport.GetToken(r, name)
 	if err != nil {
 		return session, nil
 	}
-	err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, mstore.codecs...)
+	// the storage error New recovered from in a fail-open DegradedMode
+	var degradedErr error
+	if mstore.instrumenter != nil {
+		start := time.Now()
+		defer func() { mstore.instrumenter.ObserveLoad(time.Since(start), !session.IsNew, firstError(err, degradedErr)) }()
+	}
+	parent := r.Context()
+	if mstore.tracer != nil {
+		var span Span
+		parent, span = mstore.startSpan(parent, OpLoad, "find")
+		defer func() {
+			span.SetAttribute("session.is_new", session.IsNew)
+			setSessionID(span, session.ID)
+			span.End(firstError(err, degradedErr))
+		}()
+	}
+	err = securecookie.DecodeMulti(name, token, &session.ID, mstore.getCodecs()...)
+	if err == nil {
+		_, err = mstore.docID(session.ID)
+	}
 	if err != nil {
-		return session, err
+		session.ID = ""
+		if isCookieExpired(err) {
+			return session, fmt.Errorf("%w: %v", ErrCookieExpired, err)
+		}
+		return session, fmt.Errorf("%w: %v", ErrInvalidCookie, err)
 	}
 
-	found, err := mstore.load(session)
+	ctx, cancel := mstore.operationContext(parent)
+	defer cancel()
+	if ctx, err = mstore.routeRequest(ctx, r); err != nil {
+		return session, err
+	}
+	if mstore.locking.Enabled {
+		var storageErr *StorageError
+		if lockErr := mstore.lock(ctx, r, session); lockErr != nil && (!errors.As(lockErr, &storageErr) || !mstore.degrades()) {
+			return session, lockErr
+		}
+		// Loading fails like locking did, and degrades without a lock.
+		defer func() {
+			if err != nil {
+				mstore.unlock(ctx, r, session)
+			}
+		}()
+	}
+	sessDoc, err := mstore.loadDoc(ctx, session)
+	if err != nil && mstore.staleSessionData != StaleDataFail && errors.Is(err, ErrDataDecode) {
+		return session, mstore.resetStale(ctx, session, err)
+	}
+	if err != nil && mstore.degrades() {
+		degradedErr = err
+		sessDoc, err = mstore.loadDegraded(ctx, session, err)
+	}
 	if err != nil {
 		return session, err
 	}
-	session.IsNew = !found
+	session.IsNew = sessDoc == nil
+	if sessDoc != nil {
+		setSessionMeta(r, name, sessDoc)
+		if mstore.optimisticLocking {
+			setLoadedVersion(r, session, sessDoc.Version)
+		}
+	}
+	if sessDoc != nil && mstore.afterLoad != nil {
+		if err = mstore.afterLoad(ctx, session); err != nil {
+			return session, err
+		}
+	}
+	if degradedErr != nil {
+		// Nothing is written while the database is unavailable.
+		return session, nil
+	}
+
+	if sessDoc != nil && sessDoc.stale && mstore.reencodeImmediately {
+		if err = mstore.reencode(ctx, sessDoc, session.Values); err != nil {
+			return session, err
+		}
+	}
+	if sessDoc != nil && mstore.tracksLoaded() {
+		if err = mstore.trackLoaded(ctx, r, session, sessDoc); err != nil {
+			return session, err
+		}
+	}
 
 	return session, nil
 }
@@ -125,120 +795,656 @@ func (mstore *MongoDBStore) New(r *http.Request, name string) (*sessions.Session
 // deleted from the store path. With this process it enforces the properly
 // session cookie handling so no need to trust in the cookie management in the
 // web browser.
+//
+// A new session whose insert races another insert of its ID, failing with a
+// duplicate key error, is saved again once as an update.
 func (mstore *MongoDBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
-	ctx := context.Background()
+	return mstore.saveError(mstore.save(r, w, session, time.Time{}))
+}
 
-	var ID primitive.ObjectID
-	if session.ID == "" {
-		ID = primitive.NewObjectID()
-		session.ID = ID.Hex()
-	} else {
-		newID, err := primitive.ObjectIDFromHex(session.ID)
-		if err != nil {
-			return err
+// SaveWithModified saves session like Save, recording modified as the time
+// it was last modified, e.g. to carry over the timestamp of an imported
+// session.
+func (mstore *MongoDBStore) SaveWithModified(r *http.Request, w http.ResponseWriter, session *sessions.Session, modified time.Time) error {
+	return mstore.saveError(mstore.save(r, w, session, modified))
+}
+
+// save implements Save, with a zero modified for now.
+func (mstore *MongoDBStore) save(r *http.Request, w http.ResponseWriter, session *sessions.Session, modified time.Time) (err error) {
+	var size int
+	if mstore.instrumenter != nil {
+		start, deleting := time.Now(), session.Options.MaxAge < 0
+		defer func() {
+			if deleting {
+				mstore.instrumenter.ObserveDelete(time.Since(start), err)
+			} else {
+				mstore.instrumenter.ObserveSave(time.Since(start), size, err)
+			}
+		}()
+	}
+	parent := r.Context()
+	if mstore.tracer != nil {
+		op, dbOperation := OpSave, "update"
+		if session.Options.MaxAge < 0 {
+			op, dbOperation = OpDelete, "delete"
 		}
-		ID = newID
+		var span Span
+		parent, span = mstore.startSpan(parent, op, dbOperation)
+		isNew := session.IsNew
+		defer func() {
+			span.SetAttribute("session.is_new", isNew)
+			span.SetAttribute("session.payload_size", size)
+			setSessionID(span, session.ID)
+			span.End(err)
+		}()
 	}
 
-	if session.Options.MaxAge < 0 {
-		_, err := mstore.coll.DeleteOne(ctx, bson.M{"_id": ID})
-		if err != nil {
+	ctx, cancel := mstore.saveContext(parent)
+	defer cancel()
+	if ctx, err = mstore.routeRequest(ctx, r); err != nil {
+		return err
+	}
+	if mstore.locking.Enabled {
+		defer mstore.releaseLock(ctx, r, session)
+	}
+	if mstore.beforeSave != nil && session.Options.MaxAge >= 0 {
+		if err = mstore.beforeSave(ctx, session); err != nil {
 			return err
 		}
-		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
-
-		return nil
 	}
 
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, mstore.codecs...)
+	op, err := mstore.prepareSave(ctx, r, session, modified)
+	size = op.size
 	if err != nil {
 		return err
 	}
+	switch {
+	case op.deleting:
+		_, err = mstore.deleteOne(ctx, mstore.collection(ctx), op.filter)
+		return mstore.finishDelete(ctx, r, w, op, err)
+	case op.unchanged:
+		return mstore.finishUnchanged(r, w, op)
+	}
+	res, err := mstore.updateSession(ctx, op)
+	if err != nil {
+		return &StorageError{"error saving session", err}
+	}
+	return mstore.finishSave(ctx, r, w, op, res.MatchedCount > 0, res.UpsertedCount > 0)
+}
+
+// saveOp is the write Save makes for one session.
+type saveOp struct {
+	session *sessions.Session
+	sessDoc *sessionDoc
+	filter  bson.M
+	// filter with the loaded version for OptimisticLocking, and the update
+	updateFilter bson.M
+	update       bson.M
+	// the session is deleted, or left as it is
+	deleting  bool
+	unchanged bool
+
+	version   int64
+	versioned bool
+	// ID of the session replaced for AbsoluteMaxAge
+	oldID     string
+	tenant    string
+	size      int
+	encodedID string
+}
+
+// updateSession runs the update of op on its own. Only new sessions are
+// inserted, so that a loaded session deleted in the meantime stays deleted.
+func (mstore *MongoDBStore) updateSession(ctx context.Context, op *saveOp) (*mongo.UpdateResult, error) {
+	upsert := options.Update().SetUpsert(op.session.IsNew)
+	res, err := mstore.updateOne(ctx, mstore.collection(ctx), op.updateFilter, op.update, upsert)
+	if op.session.IsNew && isDuplicateKey(err) {
+		// A concurrent upsert of the same ID inserted first; the second
+		// attempt matches its document and updates it.
+		res, err = mstore.updateOne(ctx, mstore.collection(ctx), op.updateFilter, op.update, upsert)
+	}
+	return res, err
+}
+
+// prepareSave assigns session an ID and builds its write. The returned op is
+// never nil, so that its size can be reported along with the error.
+func (mstore *MongoDBStore) prepareSave(ctx context.Context, r *http.Request, session *sessions.Session, modified time.Time) (*saveOp, error) {
+	op := &saveOp{session: session}
+	// A session being deleted keeps its ID, so that its document goes.
+	if session.ID != "" && session.Options.MaxAge >= 0 && mstore.pastAbsoluteMaxAge(r, session) {
+		op.oldID, session.ID, session.IsNew = session.ID, "", true
+	}
+
+	var ID interface{}
+	if session.ID == "" {
+		session.ID, ID = mstore.newID()
+	} else {
+		newID, err := mstore.docID(session.ID)
+		if err != nil {
+			return op, fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+		}
+		ID = newID
+	}
+
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return op, err
+	}
+	op.tenant = tenant
+	op.filter = withTenant(bson.M{"_id": ID}, tenant)
+
+	if session.Options.MaxAge < 0 {
+		op.deleting = true
+		return op, nil
+	}
+	// Encoding the cookie first keeps a codec error from leaving behind a
+	// document no cookie refers to.
+	if op.encodedID, err = mstore.encodeCookie(session); err != nil {
+		return op, err
+	}
+	if mstore.tracksLoaded() && mstore.unchanged(r, session) {
+		op.unchanged = true
+		return op, nil
+	}
+
+	fields, missing := mstore.indexedFields(session)
 	sessDoc := &sessionDoc{
-		ID:       ID,
-		Modified: time.Now(),
-		Data:     encoded,
+		ID:            ID,
+		Modified:      time.Now(),
+		SchemaVersion: schemaVersion,
+		Name:          session.Name(),
+		TenantID:      tenant,
+		UserID:        mstore.userID(session),
+		Writer:        mstore.writerTag,
+		Fields:        fields,
+	}
+	op.sessDoc = sessDoc
+	if err = mstore.storeValues(session.Name(), session.Values, sessDoc); err != nil {
+		return op, err
 	}
-	if val, ok := session.Values["modified"]; ok {
-		modified, ok := val.(time.Time)
+	op.size = len(sessDoc.Data) + len(sessDoc.Values) + len(sessDoc.Encrypted)
+	if maxLength := mstore.getMaxLength(); maxLength > 0 && op.size > maxLength {
+		return op, &SessionTooLargeError{Size: op.size, MaxLength: maxLength}
+	}
+	if val, ok := session.Values["modified"]; ok && mstore.modifiedValue && modified.IsZero() {
+		mstore.modifiedWarning.Do(func() {
+			mstore.logger.Warn("mongodbstore: the \"modified\" session value is deprecated, use SaveWithModified", "op", "save")
+		})
+		valModified, ok := val.(time.Time)
 		if !ok {
-			return errors.New("mongodbstore: invalid modified value")
+			return op, fmt.Errorf("%w: %T", ErrInvalidModified, val)
 		}
+		sessDoc.Modified = valModified
+	}
+	if !modified.IsZero() {
 		sessDoc.Modified = modified
 	}
-	_, err = mstore.coll.UpdateOne(ctx, bson.M{"_id": ID}, bson.M{"$set": sessDoc}, options.Update().SetUpsert(true))
+	if mstore.absoluteMaxAge > 0 && !session.IsNew {
+		// Documents saved before the creation time was recorded get the
+		// one New fell back to, so that AbsoluteMaxAge applies to them.
+		if meta, ok := mstore.SessionMeta(r, session.Name()); ok {
+			sessDoc.Created = meta.Created
+		}
+	}
+	created := sessDoc.Created
+	if session.IsNew {
+		created = sessDoc.Modified
+	}
+	sessDoc.ExpiresAt = mstore.expiresAt(created, sessDoc.Modified, session.Options.MaxAge)
+	stored, err := mstore.fieldNames.storedDoc(sessDoc)
 	if err != nil {
-		return err
+		return op, &StorageError{"error saving session", err}
+	}
+	update := bson.M{"$set": stored}
+	unset := bson.M{}
+	for _, field := range mstore.fieldNames.unusedPayloadFields(sessDoc) {
+		unset[field] = ""
+	}
+	if sessDoc.ExpiresAt.IsZero() {
+		unset[mstore.fieldNames.ExpiresAt] = ""
+	}
+	if sessDoc.UserID == "" {
+		unset["user_id"] = ""
+	}
+	for _, field := range missing {
+		unset[field] = ""
+	}
+	update["$unset"] = unset
+	if sessDoc.Created.IsZero() {
+		update["$setOnInsert"] = bson.M{mstore.fieldNames.Created: sessDoc.Modified}
+	}
+	op.updateFilter = op.filter
+	if mstore.optimisticLocking {
+		update["$inc"] = bson.M{"version": 1}
+		if !session.IsNew {
+			if op.version, op.versioned = loadedVersion(r, session); op.versioned {
+				op.updateFilter = withVersion(op.filter, op.version)
+			}
+		}
+	}
+	op.update = update
+
+	return op, nil
+}
+
+// finishDelete completes the deletion op, with the error err of the delete.
+func (mstore *MongoDBStore) finishDelete(ctx context.Context, r *http.Request, w http.ResponseWriter, op *saveOp, err error) error {
+	mstore.cache.invalidate(mstore.cacheKey(ctx, op.session.ID))
+	if err != nil {
+		return &StorageError{"error deleting session", err}
+	}
+	mstore.logger.Debug("mongodbstore: deleted session with a negative MaxAge", "op", "save", "name", op.session.Name(), "session", logID(op.session.ID))
+	return mstore.transport.SetToken(w, op.session.Name(), "", mstore.cookieOptions(r, op.session.Options))
+}
+
+// finishSave completes the update op, given whether it matched a stored
+// document or inserted a new one, and sets the cookie.
+func (mstore *MongoDBStore) finishSave(ctx context.Context, r *http.Request, w http.ResponseWriter, op *saveOp, matched, upserted bool) error {
+	session, sessDoc := op.session, op.sessDoc
+	if !session.IsNew && !matched {
+		mstore.cache.invalidate(mstore.cacheKey(ctx, session.ID))
+		if op.versioned {
+			return mstore.conflictOrNotFound(ctx, op.filter)
+		}
+		return ErrSessionNotFound
+	}
+	if mstore.optimisticLocking {
+		version, versioned := op.version, op.versioned
+		if upserted {
+			version, versioned = 0, true
+		}
+		if versioned {
+			sessDoc.Version = version + 1
+			setLoadedVersion(r, session, sessDoc.Version)
+		}
 	}
-	encodedID, err := securecookie.EncodeMulti(session.Name(), session.ID, mstore.codecs...)
+	if upserted {
+		sessDoc.Created = sessDoc.Modified
+		mstore.observeEvent(EventCreated, 1)
+	} else if meta, ok := mstore.SessionMeta(r, session.Name()); ok {
+		sessDoc.Created = meta.Created
+	}
+	setSessionMeta(r, session.Name(), sessDoc)
+	if (mstore.optimisticLocking && sessDoc.Version == 0) || inMongoSession(ctx) {
+		// The version the update produced is unknown, or the transaction may
+		// still be aborted.
+		mstore.cache.invalidate(mstore.cacheKey(ctx, session.ID))
+	} else {
+		mstore.cache.put(mstore.cacheKey(ctx, session.ID), sessDoc)
+	}
+	if op.oldID != "" {
+		mstore.deleteAbsoluteExpired(ctx, op.oldID, op.tenant)
+	}
+	return mstore.writeCookie(r, w, session, op.encodedID)
+}
+
+// setCookie adds the cookie carrying the encoded session ID to the response.
+func (mstore *MongoDBStore) setCookie(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	encodedID, err := mstore.encodeCookie(session)
 	if err != nil {
 		return err
 	}
+	return mstore.writeCookie(r, w, session, encodedID)
+}
 
-	http.SetCookie(w, sessions.NewCookie(session.Name(), encodedID, session.Options))
+// encodeCookie returns the cookie value carrying the session ID.
+func (mstore *MongoDBStore) encodeCookie(session *sessions.Session) (string, error) {
+	return securecookie.EncodeMulti(session.Name(), session.ID, mstore.getCodecs()...)
+}
 
-	return nil
+// writeCookie sends the encoded session ID to the client through the
+// transport, in a cookie by default.
+func (mstore *MongoDBStore) writeCookie(r *http.Request, w http.ResponseWriter, session *sessions.Session, encodedID string) error {
+	return mstore.transport.SetToken(w, session.Name(), encodedID, mstore.cookieOptions(r, session.Options))
 }
 
-func (mstore *MongoDBStore) ensureIndexTTL() error {
-	ctx := context.Background()
+// newCodecs returns the codecs for keyPairs with the store codec MaxAge,
+// for the cookies, and for the stored data. The data is not bound by the
+// cookie length limit nor by the codec MaxAge: touching a session extends
+// its expiry without re-encoding the data, so the document alone decides
+// whether it expired.
+func (mstore *MongoDBStore) newCodecs(keyPairs ...[]byte) (codecs, dataCodecs []securecookie.Codec) {
+	codecs = mstore.codecsFromPairs(keyPairs...)
+	dataCodecs = mstore.codecsFromPairs(keyPairs...)
+	for _, codec := range dataCodecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+			sc.MaxAge(0)
+			if mstore.serializer != nil || mstore.compression != nil {
+				sc.SetSerializer(codecSerializer{mstore.payloadSerializer()})
+			}
+		}
+	}
+	return codecs, dataCodecs
+}
 
-	indexName := "modified_at_TTL"
+func (mstore *MongoDBStore) codecsFromPairs(keyPairs ...[]byte) []securecookie.Codec {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(mstore.codecMaxAge)
+		}
+	}
+	return codecs
+}
+
+// getCodecs returns the codecs currently used to encode and decode session
+// cookies.
+func (mstore *MongoDBStore) getCodecs() []securecookie.Codec {
+	mstore.mu.RLock()
+	defer mstore.mu.RUnlock()
+	return mstore.codecs
+}
+
+// getDataCodecs returns the codecs currently used to encode and decode the
+// stored session values.
+func (mstore *MongoDBStore) getDataCodecs() []securecookie.Codec {
+	mstore.mu.RLock()
+	defer mstore.mu.RUnlock()
+	return mstore.dataCodecs
+}
+
+// operationContext derives the context of the mongoDB operations of a
+// request, bounded by OperationTimeout unless ctx has a deadline.
+func (mstore *MongoDBStore) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if sc, ok := ctx.(mongo.SessionContext); ok {
+		// Remember the session once ctx is wrapped.
+		ctx = context.WithValue(ctx, mongoSessionKey{}, sc)
+	}
+	if _, ok := ctx.Deadline(); ok || mstore.operationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, mstore.operationTimeout)
+}
+
+// Options sets the options of new sessions, like the Options field of the
+// gorilla stores, and the MaxAge of the codecs with MaxAge. As there, it
+// must not be called while the store serves requests.
+func (mstore *MongoDBStore) Options(opts sessions.Options) {
+	mstore.options = opts
+	mstore.MaxAge(opts.MaxAge)
+}
+
+// MaxAge sets the MaxAge of new sessions and of the codecs, like the MaxAge
+// method of the gorilla stores. As there, it must not be called while the
+// store serves requests. The codecs get the largest MaxAge of the store,
+// PerNameMaxAge included, with ServerSideTTL for MaxAge 0, and keep an
+// explicit CodecMaxAge.
+//
+// The TTL index keeps its expireAfterSeconds until MigrateTTLIndex is
+// called or a store is created with IndexTTL, which MaxAge logs as a
+// warning.
+func (mstore *MongoDBStore) MaxAge(age int) {
+	_, _, previous := mstore.ttlIndex()
+	mstore.options.MaxAge = age
+	if _, _, expireAfterSeconds := mstore.ttlIndex(); mstore.indexTTL && expireAfterSeconds != previous {
+		mstore.logger.Warn("mongodbstore: TTL index still uses the previous MaxAge, call MigrateTTLIndex", "op", "max_age", "max_age", age)
+	}
+
+	if mstore.codecAgeSet {
+		return
+	}
+	mstore.mu.Lock()
+	defer mstore.mu.Unlock()
+	mstore.codecMaxAge = largestRetention(age, mstore.perNameMaxAge, mstore.serverSideTTL)
+	for _, codec := range mstore.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(mstore.codecMaxAge)
+		}
+	}
+}
+
+// MaxLength restricts the stored payload of a session to l bytes, 0 for
+// unlimited, like the MaxLength of the securecookie based stores.
+func (mstore *MongoDBStore) MaxLength(l int) {
+	mstore.mu.Lock()
+	defer mstore.mu.Unlock()
+	mstore.maxLength = l
+}
+
+// getMaxLength returns the current MaxLength.
+func (mstore *MongoDBStore) getMaxLength() int {
+	mstore.mu.RLock()
+	defer mstore.mu.RUnlock()
+	return mstore.maxLength
+}
+
+// maxAge returns the MaxAge configured for sessions with the given name.
+func (mstore *MongoDBStore) maxAge(name string) int {
+	if maxAge, ok := mstore.perNameMaxAge[name]; ok {
+		return maxAge
+	}
+	return mstore.options.MaxAge
+}
 
-	cursor, err := mstore.coll.Indexes().List(ctx)
+const (
+	ttlIndexName = "modified_TTL"
+
+	// expiresAtTTLIndexName expires every document at its own expires_at.
+	expiresAtTTLIndexName = "expires_at_TTL"
+
+	// legacyTTLIndexName was keyed on modified_at, a field that session
+	// documents never had, so it never expired anything.
+	legacyTTLIndexName = "modified_at_TTL"
+)
+
+// MigrateTTLIndex drops the legacy TTL index keyed on the non-existent
+// modified_at field, if present, and ensures the TTL index on the modified
+// field of the session documents, or on expires_at with ExpiresAtTTL,
+// dropping the index of the other mode. When the index exists with another
+// expireAfterSeconds, it is updated with collMod, or dropped and recreated
+// where collMod is not available. An index of that name with another key or
+// TTLIndexOptions is recreated.
+//
+// Stores created with IndexTTL do this on construction; MigrateTTLIndex is
+// for deployments that create indexes out of band.
+func (mstore *MongoDBStore) MigrateTTLIndex(ctx context.Context) error {
+	return mstore.ensureIndexTTL(ctx)
+}
+
+// ttlIndex returns the name, field and expireAfterSeconds of the TTL index
+// the store uses.
+func (mstore *MongoDBStore) ttlIndex() (name, field string, expireAfterSeconds int64) {
+	name, field, expireAfterSeconds = ttlIndexName, mstore.fieldNames.Modified, int64(mstore.retention(mstore.options.MaxAge))
+	if mstore.expiresAtTTL {
+		name, field, expireAfterSeconds = expiresAtTTLIndexName, mstore.fieldNames.ExpiresAt, 0
+	}
+	if mstore.ttlIndexOptions.Name != "" {
+		name = mstore.ttlIndexOptions.Name
+	}
+	return name, field, expireAfterSeconds
+}
+
+func (mstore *MongoDBStore) ensureIndexTTL(ctx context.Context) error {
+	cursor, err := mstore.collection(ctx).Indexes().List(ctx)
 	if err != nil {
 		return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to list indexes: %w", err)
 	}
+	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		indexInfo := &struct {
-			Name string `bson:"name"`
-		}{}
+	name, field, expireAfterSeconds := mstore.ttlIndex()
 
+	found := false
+	for cursor.Next(ctx) {
+		indexInfo := &ttlIndexInfo{}
 		if err = cursor.Decode(indexInfo); err != nil {
 			return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to decode bson index document %w", err)
 		}
 
-		if indexInfo.Name == indexName {
-			return nil
+		switch indexInfo.Name {
+		case name:
+			if !indexInfo.matches(field, mstore.ttlIndexOptions) {
+				mstore.logger.Warn("mongodbstore: TTL index does not match the configuration, recreating it", "op", "ensure_ttl_index", "index", name)
+				if err = mstore.dropIndex(ctx, name); err != nil {
+					return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to drop mismatched index: %w", err)
+				}
+				continue
+			}
+			found = indexInfo.ExpireAfterSeconds != nil && *indexInfo.ExpireAfterSeconds == expireAfterSeconds
+			if found {
+				continue
+			}
+			if found, err = mstore.updateIndexTTL(ctx, name, expireAfterSeconds); err != nil {
+				return err
+			}
+		case ttlIndexName, expiresAtTTLIndexName:
+			// Created under another name or for the other mode.
+			if err = mstore.dropIndex(ctx, indexInfo.Name); err != nil {
+				return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to drop index %s: %w", indexInfo.Name, err)
+			}
+		case legacyTTLIndexName:
+			if _, ok := indexInfo.Key["modified_at"]; !ok {
+				continue
+			}
+			if err = mstore.dropIndex(ctx, legacyTTLIndexName); err != nil {
+				return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to drop legacy index: %w", err)
+			}
 		}
-
 	}
-	indexOpts := options.Index().
-		SetExpireAfterSeconds(int32(mstore.options.MaxAge)).
-		SetBackground(true).
-		SetSparse(true).
-		SetName(indexName)
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to list indexes: %w", err)
+	}
+	if found {
+		return nil
+	}
 
-	indexModel := mongo.IndexModel{
-		Keys: bson.M{
-			"modified_at": 1,
-		},
-		Options: indexOpts,
+	// createIndexes is run directly for its commitQuorum option.
+	coll := mstore.collection(ctx)
+	err = coll.Database().RunCommand(ctx, mstore.ttlIndexCommand(coll.Name(), name, field, expireAfterSeconds)).Err()
+	if isIndexConflict(err) {
+		// Another instance may have created the index first.
+		created, listErr := mstore.hasTTLIndex(ctx, name, field)
+		if listErr != nil {
+			return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to list indexes: %w", listErr)
+		}
+		if !created {
+			return &TTLIndexConflictError{Index: name, Field: field, Err: err}
+		}
+		mstore.logger.Debug("mongodbstore: TTL index created concurrently", "op", "ensure_ttl_index", "index", name, "error", err)
+		return nil
 	}
-	_, err = mstore.coll.Indexes().CreateOne(ctx, indexModel)
 	if err != nil {
 		return fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to create index: %w", err)
 	}
+	mstore.logger.Debug("mongodbstore: created TTL index", "op", "ensure_ttl_index", "index", name, "expire_after_seconds", expireAfterSeconds)
 
 	return nil
 }
 
-func (mstore *MongoDBStore) load(sess *sessions.Session) (found bool, err error) {
-	ID, err := primitive.ObjectIDFromHex(sess.ID)
+// updateIndexTTL changes the expireAfterSeconds of the named TTL index in
+// place with collMod. When the server does not allow that, it drops the
+// index to be recreated and returns false.
+func (mstore *MongoDBStore) updateIndexTTL(ctx context.Context, name string, expireAfterSeconds int64) (updated bool, err error) {
+	coll := mstore.collection(ctx)
+	err = coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: coll.Name()},
+		{Key: "index", Value: bson.M{"name": name, "expireAfterSeconds": expireAfterSeconds}},
+	}).Err()
+	if err == nil {
+		return true, nil
+	}
+	mstore.logger.Warn("mongodbstore: collMod failed, recreating TTL index", "op", "ensure_ttl_index", "index", name, "error", err)
+	if err = mstore.dropIndex(ctx, name); err != nil {
+		return false, fmt.Errorf("mongodbstore: error ensuring TTL index. Unable to drop outdated index: %w", err)
+	}
+
+	return false, nil
+}
+
+// dropIndex drops the named index, ignoring that another instance dropped
+// it first.
+func (mstore *MongoDBStore) dropIndex(ctx context.Context, name string) error {
+	_, err := mstore.collection(ctx).Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Name == "IndexNotFound") {
+		return nil
+	}
+	return err
+}
+
+// isIndexConflict reports whether err is the IndexOptionsConflict or
+// IndexKeySpecsConflict error of creating an index that exists already.
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	return cmdErr.Code == 85 || cmdErr.Code == 86
+}
+
+func (mstore *MongoDBStore) load(ctx context.Context, sess *sessions.Session) (found bool, err error) {
+	sessDoc, err := mstore.loadDoc(ctx, sess)
+	return sessDoc != nil, err
+}
+
+// loadDoc decodes the stored session into sess and returns its document, or
+// nil if there is no live session with the ID of sess.
+func (mstore *MongoDBStore) loadDoc(ctx context.Context, sess *sessions.Session) (*sessionDoc, error) {
+	ID, err := mstore.docID(sess.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCookie, err)
+	}
+	tenant, err := mstore.tenant(ctx)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	ctx := context.Background()
-	sessDoc := &sessionDoc{}
-	err = mstore.coll.FindOne(ctx, bson.M{"_id": ID}).Decode(sessDoc)
-	if sessDoc.ID.IsZero() {
-		return false, nil
+	var sessDoc *sessionDoc
+	if !inMongoSession(ctx) && !mstore.locking.Enabled {
+		sessDoc = mstore.cache.get(mstore.cacheKey(ctx, sess.ID), tenant)
 	}
-	err = securecookie.DecodeMulti(sess.Name(), sessDoc.Data, &sess.Values, mstore.codecs...)
+	cached := sessDoc != nil
+	if !cached {
+		sessDoc = &sessionDoc{}
+		err = mstore.findOne(ctx, mstore.loadCollection(ctx), withTenant(bson.M{"_id": ID}, tenant), sessDoc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// A new ID keeps Save from recreating a revoked session under
+			// the ID its cookie still carries.
+			sess.ID = ""
+			return nil, nil
+		}
+		if errors.Is(err, ErrDataDecode) {
+			mstore.logger.Warn("mongodbstore: stored session document can not be decoded", "op", "load", "name", sess.Name(), "session", logID(sess.ID), "error", err)
+			return nil, err
+		}
+		if err != nil {
+			return nil, &StorageError{"error loading session", err}
+		}
+	}
+	if sessDoc.Pending {
+		// A new ID keeps Save from overwriting the pending session before
+		// it is claimed.
+		sess.ID = ""
+		return nil, nil
+	}
+	if mstore.expired(sessDoc, sess.Options.MaxAge, time.Now()) {
+		mstore.observeEvent(EventExpiredOnLoad, 1)
+		if mstore.deleteExpiredOnLoad {
+			mstore.deleteExpiredDoc(ctx, sessDoc)
+		}
+		// A new ID keeps Save from reviving the expired session.
+		sess.ID = ""
+		return nil, nil
+	}
+	if mstore.absoluteMaxAge > 0 && mstore.absoluteExpired(created(sessDoc)) {
+		mstore.observeEvent(EventExpiredOnLoad, 1)
+		mstore.deleteAbsoluteExpired(ctx, sess.ID, sessDoc.TenantID)
+		// A new ID keeps Save from bringing the session back.
+		sess.ID = ""
+		return nil, nil
+	}
+	name := sess.Name()
+	if name == "" {
+		// GetByID only learns the name from the document.
+		name = sessDoc.Name
+	}
+	err = mstore.loadValues(name, sessDoc, &sess.Values)
 	if err != nil {
-		return false, err
+		mstore.logger.Warn("mongodbstore: stored session data can not be decoded", "op", "load", "name", name, "session", logID(sess.ID), "error", err)
+		return nil, err
+	}
+	if !cached && !inMongoSession(ctx) {
+		mstore.cache.put(mstore.cacheKey(ctx, sess.ID), sessDoc)
 	}
 
-	return true, err
+	return sessDoc, nil
 }
@@ -2,12 +2,17 @@ package mongodbstoregorilla
 
 import (
 	"context"
+	"encoding/gob"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -22,12 +27,15 @@ func TestStore(t *testing.T) {
 	mongoColl := "mongodbstore_sessions_test"
 	dropColl := true
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI).SetServerSelectionTimeout(time.Second))
 	if err != nil {
 		t.Fatalf("Error connecting to mongoDB: %v", err)
 	}
 
 	defer client.Disconnect(ctx)
+	if err = client.Ping(ctx, nil); err != nil {
+		t.Skipf("mongoDB is not available: %v", err)
+	}
 
 	coll := client.Database(mongoDB).Collection(mongoColl)
 
@@ -92,3 +100,470 @@ func TestStore(t *testing.T) {
 		t.Errorf("Expected 2 flashes; Got %v", flashes)
 	}
 }
+
+// newTestCollection connects to the test mongoDB and returns a collection
+// that is dropped when the test finishes. It skips the test when no server
+// answers.
+func newTestCollection(t testing.TB) *mongo.Collection {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017").SetServerSelectionTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("Error connecting to mongoDB: %v", err)
+	}
+	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		t.Skipf("mongoDB is not available: %v", err)
+	}
+	coll := client.Database("test").Collection("mongodbstore_" + t.Name())
+	t.Cleanup(func() {
+		coll.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return coll
+}
+
+func TestCodecMaxAge(t *testing.T) {
+	newStore := func(codecMaxAge int) *MongoDBStore {
+		cfg := defaultConfig
+		cfg.CodecMaxAge = &codecMaxAge
+		store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+		if err != nil {
+			t.Fatalf("Error initializing mongodb store: %v", err)
+		}
+		return store
+	}
+	saveSession := func(store *MongoDBStore) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.Get(req, "session-key")
+		session.Values["foo"] = "bar"
+		if err := session.Save(req, resp); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}
+	loadSession := func(store *MongoDBStore, cookie string) (*sessions.Session, error) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return store.New(req, "session-key")
+	}
+
+	shortStore, unlimitedStore := newStore(1), newStore(0)
+	shortCookie, unlimitedCookie := saveSession(shortStore), saveSession(unlimitedStore)
+
+	time.Sleep(2100 * time.Millisecond)
+
+	session, err := loadSession(shortStore, shortCookie)
+	if err == nil || !session.IsNew {
+		t.Errorf("Expected cookie past codec MaxAge to be rejected; Got err %v, IsNew %t", err, session.IsNew)
+	}
+	session, err = loadSession(unlimitedStore, unlimitedCookie)
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected session to load with codec MaxAge disabled; Got err %v, IsNew %t", err, session.IsNew)
+	}
+	if session.Values["foo"] != "bar" {
+		t.Errorf("Expected foo=bar; Got %v", session.Values["foo"])
+	}
+}
+
+func TestPerNameMaxAge(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.PerNameMaxAge = map[string]int{
+		"auth":  3600,
+		"prefs": 3600 * 24 * 365,
+	}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	for _, name := range []string{"auth", "prefs", "other"} {
+		session, _ := store.Get(req, name)
+		if session.Options.MaxAge != store.maxAge(name) {
+			t.Errorf("Expected %s MaxAge %d; Got %d", name, store.maxAge(name), session.Options.MaxAge)
+		}
+	}
+	if err = sessions.Save(req, resp); err != nil {
+		t.Fatalf("Error saving sessions: %v", err)
+	}
+	cookies := resp.Result().Cookies()
+	if len(cookies) != 3 {
+		t.Fatalf("Expected 3 cookies; Got %d", len(cookies))
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge != store.maxAge(cookie.Name) {
+			t.Errorf("Expected %s cookie Max-Age %d; Got %d", cookie.Name, store.maxAge(cookie.Name), cookie.MaxAge)
+		}
+	}
+
+	// Two hours from now only the auth session has expired.
+	deleted, err := store.cleanup(context.Background(), time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Error cleaning up sessions: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted session; Got %d", deleted)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	for _, name := range []string{"auth", "prefs", "other"} {
+		session, err := store.New(req, name)
+		if err != nil {
+			t.Fatalf("Error getting %s session: %v", name, err)
+		}
+		if session.IsNew != (name == "auth") {
+			t.Errorf("Expected %s IsNew %t; Got %t", name, name == "auth", session.IsNew)
+		}
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values = nil
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session with nil values: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Error loading session: %v, IsNew %t", err, session.IsNew)
+	}
+	if session.Values == nil || len(session.Values) != 0 {
+		t.Errorf("Expected empty non-nil values; Got %#v", session.Values)
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	store, err := NewMongoDBStore(newTestCollection(b), []byte("secret"))
+	if err != nil {
+		b.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values = benchmarkValues(20)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		b.Fatalf("Error saving session: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loaded := sessions.NewSession(store, "session-key")
+		loaded.ID = session.ID
+		if found, err := store.load(context.Background(), loaded); err != nil || !found {
+			b.Fatalf("Error loading session: %v, found %t", err, found)
+		}
+	}
+}
+
+func TestWriterTag(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.WriterTag = "v1.2.3"
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	var raw bson.M
+	if err = coll.FindOne(context.Background(), bson.M{}).Decode(&raw); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	if raw["writer"] != "v1.2.3" {
+		t.Errorf("Expected writer v1.2.3; Got %v", raw["writer"])
+	}
+
+	// A store running another build reads the session normally.
+	other, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = other.New(req, "session-key")
+	if err != nil || session.IsNew || len(session.Values) != 1 || session.Values["foo"] != "bar" {
+		t.Errorf("Expected writer to be ignored on load; Got err %v, IsNew %t, values %v", err, session.IsNew, session.Values)
+	}
+}
+
+func TestSaveWithModified(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	// Without ModifiedValue "modified" is an ordinary value.
+	for _, val := range []interface{}{"yesterday", true, 42, nil} {
+		session.Values["modified"] = val
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session with modified value %#v: %v", val, err)
+		}
+	}
+	session.Values["modified"] = "yesterday"
+
+	modified := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	if err = store.SaveWithModified(req, httptest.NewRecorder(), session, modified); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if sessDoc := readTestDoc(t, coll); !sessDoc.Modified.Equal(modified) {
+		t.Errorf("Expected modified %v; Got %v", modified, sessDoc.Modified)
+	}
+}
+
+func TestModifiedValue(t *testing.T) {
+	coll := newTestCollection(t)
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.ModifiedValue = true
+	cfg.Logger = logger
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// The value is stored along with the others.
+	gob.Register(time.Time{})
+	modified := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		session.Values["modified"] = modified
+		if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+	}
+	if sessDoc := readTestDoc(t, coll); !sessDoc.Modified.Equal(modified) {
+		t.Errorf("Expected modified %v; Got %v", modified, sessDoc.Modified)
+	}
+	warnings := 0
+	for _, entry := range logger.entries {
+		if entry.level == "warn" && strings.Contains(entry.msg, "deprecated") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("Expected one deprecation warning; Got %d", warnings)
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if store.getMaxLength() != DefaultMaxLength {
+		t.Errorf("Expected MaxLength %d by default; Got %d", DefaultMaxLength, store.getMaxLength())
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["blob"] = strings.Repeat("x", 1000)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	// The limit applies to the base64 encoded data, not the raw values.
+	size := len(readTestDoc(t, coll).Data)
+	if size <= 1000 {
+		t.Fatalf("Expected encoded data larger than the values; Got %d bytes", size)
+	}
+
+	store.MaxLength(size)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected a session of exactly MaxLength to save; Got %v", err)
+	}
+	store.MaxLength(size - 1)
+	err = store.Save(req, httptest.NewRecorder(), session)
+	var tooLarge *SessionTooLargeError
+	if !errors.Is(err, ErrSessionTooLarge) || !errors.As(err, &tooLarge) {
+		t.Fatalf("Expected ErrSessionTooLarge; Got %v", err)
+	}
+	if tooLarge.Size != size || tooLarge.MaxLength != size-1 {
+		t.Errorf("Expected size %d and maximum %d; Got %+v", size, size-1, tooLarge)
+	}
+	store.MaxLength(0)
+	session.Values["blob"] = strings.Repeat("x", 2*DefaultMaxLength)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected MaxLength 0 to be unlimited; Got %v", err)
+	}
+}
+
+func TestMaxLengthCompressed(t *testing.T) {
+	maxLength := 4096
+	cfg := compressedConfig()
+	cfg.MaxLength = &maxLength
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	// Larger than MaxLength, but not once compressed.
+	session.Values["blob"] = strings.Repeat("compressible ", 1000)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Errorf("Expected the compressed size to count; Got %v", err)
+	}
+}
+
+func TestSaveCookieCodecError(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// An invalid block key length makes every Encode fail.
+	store.codecs = []securecookie.Codec{securecookie.New([]byte("secret"), []byte("bad key"))}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	if err = store.Save(req, resp, session); err == nil {
+		t.Fatal("Expected an error encoding the cookie")
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected no cookie; Got %q", cookie)
+	}
+	if count, _ := coll.CountDocuments(context.Background(), bson.M{}); count != 0 {
+		t.Errorf("Expected no session document; Got %d", count)
+	}
+}
+
+func TestLargeSession(t *testing.T) {
+	maxLength := 256 * 1024
+	cfg := defaultConfig
+	cfg.MaxLength = &maxLength
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// Well past the 4096 bytes securecookie allows for a cookie by default.
+	values := map[interface{}]interface{}{"cart": strings.Repeat("item;", 100*1024/5)}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values = values
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	if len(cookie) > 4096 {
+		t.Errorf("Expected the cookie to carry only the ID; Got %d bytes", len(cookie))
+	}
+	loaded, err := loadWithCookie(store, cookie)
+	if err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if loaded.IsNew || loaded.Values["cart"] != values["cart"] {
+		t.Errorf("Expected the 100KB session to round trip; Got new %v with %d values", loaded.IsNew, len(loaded.Values))
+	}
+
+	// The cookie codecs keep their length limit.
+	if _, err = securecookie.EncodeMulti("session-key", strings.Repeat("x", 5000), store.getCodecs()...); err == nil {
+		t.Error("Expected the cookie codecs to reject a value over 4096 bytes")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	saveCookie := func() string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}
+
+	store.MaxAge(60)
+	if cookie := saveCookie(); !strings.Contains(cookie, "Max-Age=60") {
+		t.Errorf("Expected Max-Age=60; Got cookie %q", cookie)
+	}
+
+	store.Options(sessions.Options{Path: "/app", MaxAge: 120, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	cookie := saveCookie()
+	for _, attr := range []string{"Path=/app", "Max-Age=120", "HttpOnly", "SameSite=Strict"} {
+		if !strings.Contains(cookie, attr) {
+			t.Errorf("Expected %s; Got cookie %q", attr, cookie)
+		}
+	}
+	if store.codecMaxAge != 120 {
+		t.Errorf("Expected codec MaxAge 120; Got %d", store.codecMaxAge)
+	}
+}
+
+func TestClearInvalidCookies(t *testing.T) {
+	cfg := defaultConfig
+	cfg.ClearInvalidCookies = true
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	tampered := strings.Replace(saveTestSession(t, store), "session-key=", "session-key=x", 1)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", tampered)
+	resp := httptest.NewRecorder()
+	session, err := store.GetWithResponse(req, resp, "session-key")
+	if err != nil || !session.IsNew || session.ID != "" {
+		t.Fatalf("Expected a new session without error for a tampered cookie; Got %v", err)
+	}
+	cleared := resp.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != "session-key" || cleared[0].MaxAge >= 0 {
+		t.Fatalf("Expected the tampered cookie to be expired; Got %v", cleared)
+	}
+
+	// The next request carries no cookie and gets a working session.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp = httptest.NewRecorder()
+	if session, err = store.GetWithResponse(req, resp, "session-key"); err != nil || !session.IsNew {
+		t.Fatalf("Expected a new session; Got %v", err)
+	}
+	session.Values["user"] = "bob"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if loaded, err := loadWithCookie(store, resp.Header().Get("Set-Cookie")); err != nil || loaded.Values["user"] != "bob" {
+		t.Errorf("Expected the user to recover a session; Got %v, %v", loaded.Values, err)
+	}
+
+	// Without ClearInvalidCookies the error is returned and the cookie left.
+	store.clearInvalidCookies = false
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", tampered)
+	resp = httptest.NewRecorder()
+	if session, err = store.GetWithResponse(req, resp, "session-key"); !errors.Is(err, ErrInvalidCookie) || !session.IsNew {
+		t.Errorf("Expected ErrInvalidCookie with a new session; Got %v", err)
+	}
+	if resp.Header().Get("Set-Cookie") != "" {
+		t.Error("Expected no cookie without ClearInvalidCookies")
+	}
+}
@@ -0,0 +1,58 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrMissingTenant is returned when TenantFunc is configured but the context
+// carries no tenant.
+var ErrMissingTenant = errors.New("mongodbstore: missing tenant in context")
+
+// tenant returns the tenant of ctx, or "" when the store is not multi-tenant.
+func (mstore *MongoDBStore) tenant(ctx context.Context) (string, error) {
+	if mstore.tenantFunc == nil {
+		return "", nil
+	}
+	tenant := mstore.tenantFunc(ctx)
+	if tenant == "" {
+		return "", ErrMissingTenant
+	}
+
+	return tenant, nil
+}
+
+// withTenant restricts filter to the documents of tenant.
+func withTenant(filter bson.M, tenant string) bson.M {
+	if tenant != "" {
+		filter["tenant_id"] = tenant
+	}
+	return filter
+}
+
+// scopeFilter restricts filter to the documents of the tenant of ctx.
+func (mstore *MongoDBStore) scopeFilter(ctx context.Context, filter bson.M) (bson.M, error) {
+	tenant, err := mstore.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return withTenant(filter, tenant), nil
+}
+
+func (mstore *MongoDBStore) ensureTenantIndex(ctx context.Context) error {
+	indexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("tenant_id"),
+	}
+	if _, err := mstore.collection(ctx).Indexes().CreateOne(ctx, indexModel); err != nil {
+		return fmt.Errorf("mongodbstore: error ensuring tenant index: %w", err)
+	}
+
+	return nil
+}
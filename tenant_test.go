@@ -0,0 +1,74 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type tenantKey struct{}
+
+func tenantRequest(tenant string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	if tenant == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), tenantKey{}, tenant))
+}
+
+func TestTenantIsolation(t *testing.T) {
+	cfg := defaultConfig
+	cfg.TenantFunc = func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req := tenantRequest("acme")
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+
+	req = tenantRequest("acme")
+	req.Header.Add("Cookie", cookie)
+	if session, err = store.New(req, "session-key"); err != nil || session.IsNew {
+		t.Fatalf("Expected session to load for its tenant; Got err %v, IsNew %t", err, session.IsNew)
+	}
+
+	req = tenantRequest("globex")
+	req.Header.Add("Cookie", cookie)
+	if session, err = store.New(req, "session-key"); err != nil || !session.IsNew {
+		t.Fatalf("Expected session of another tenant to be new; Got err %v, IsNew %t", err, session.IsNew)
+	}
+	// Saving under the other tenant must not overwrite the original document.
+	session.Values["foo"] = "evil"
+	if err = store.Save(req, httptest.NewRecorder(), session); err == nil {
+		t.Error("Expected saving another tenant's session ID to fail")
+	}
+
+	req = tenantRequest("acme")
+	req.Header.Add("Cookie", cookie)
+	if session, err = store.New(req, "session-key"); err != nil || session.Values["foo"] != "bar" {
+		t.Errorf("Expected original session to be untouched; Got err %v, values %v", err, session.Values)
+	}
+
+	req = tenantRequest("")
+	req.Header.Add("Cookie", cookie)
+	if _, err = store.New(req, "session-key"); !errors.Is(err, ErrMissingTenant) {
+		t.Errorf("Expected ErrMissingTenant from New; Got %v", err)
+	}
+	req = tenantRequest("")
+	session, _ = store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrMissingTenant) {
+		t.Errorf("Expected ErrMissingTenant from Save; Got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type requestStateKey struct{}
+
+// requestState holds what New loaded for the sessions of one request, so
+// that Save can tell whether they changed. It lives in the request context,
+// like the gorilla sessions registry.
+type requestState struct {
+	mu     sync.Mutex
+	loaded map[*sessions.Session]*loadedSession
+	meta   map[string]SessionMeta
+	// document versions for OptimisticLocking
+	versions map[*sessions.Session]int64
+	// locks taken with Locking
+	locks map[*sessions.Session]sessionLock
+}
+
+type loadedSession struct {
+	values  map[interface{}]interface{}
+	options sessions.Options
+	stale   bool
+	// the modified timestamp was bumped on load
+	touched bool
+}
+
+// getRequestState returns the state attached to r, attaching a new one when
+// create is set.
+func getRequestState(r *http.Request, create bool) *requestState {
+	if state, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		return state
+	}
+	if !create {
+		return nil
+	}
+	state := &requestState{
+		loaded: make(map[*sessions.Session]*loadedSession),
+		meta:   make(map[string]SessionMeta),
+
+		versions: make(map[*sessions.Session]int64),
+		locks:    make(map[*sessions.Session]sessionLock),
+	}
+	*r = *r.WithContext(context.WithValue(r.Context(), requestStateKey{}, state))
+
+	return state
+}
+
+// tracksLoaded reports whether New remembers the loaded values for Save.
+func (mstore *MongoDBStore) tracksLoaded() bool {
+	return mstore.touchInterval > 0 || mstore.slidingExpiration > 0 || mstore.skipUnmodified
+}
+
+// refreshInterval returns the age after which New bumps the modified
+// timestamp of a session with the given MaxAge, 0 for never.
+func (mstore *MongoDBStore) refreshInterval(maxAge int) time.Duration {
+	interval := mstore.touchInterval
+	if mstore.slidingExpiration > 0 && maxAge > 0 {
+		sliding := time.Duration(mstore.slidingExpiration * float64(maxAge) * float64(time.Second))
+		if interval <= 0 || sliding < interval {
+			interval = sliding
+		}
+	}
+	return interval
+}
+
+// trackLoaded remembers the loaded values of session for Save and bumps its
+// modified timestamp when it is older than the touch interval.
+func (mstore *MongoDBStore) trackLoaded(ctx context.Context, r *http.Request, session *sessions.Session, sessDoc *sessionDoc) error {
+	// A second decode yields an independent copy of the values.
+	snapshot := make(map[interface{}]interface{})
+	if err := mstore.loadValues(session.Name(), sessDoc, &snapshot); err != nil {
+		return err
+	}
+	loaded := &loadedSession{values: snapshot, options: *session.Options, stale: sessDoc.stale || sessDoc.legacy}
+	state := getRequestState(r, true)
+	state.mu.Lock()
+	state.loaded[session] = loaded
+	state.mu.Unlock()
+
+	now := time.Now()
+	interval := mstore.refreshInterval(session.Options.MaxAge)
+	if interval <= 0 || now.Sub(sessDoc.Modified) < interval {
+		return nil
+	}
+	matched, err := mstore.touchDoc(ctx, mstore.collection(ctx), sessDoc, now)
+	if matched {
+		state.mu.Lock()
+		loaded.touched = true
+		state.mu.Unlock()
+	}
+	return err
+}
+
+// Refresh re-issues the cookie of session when New bumped its modified
+// timestamp, so that the browser keeps it as long as the stored session
+// with TouchInterval or SlidingExpiration. It is meant for handlers, or a
+// middleware, that load a session without saving it, and does nothing for
+// other sessions.
+func (mstore *MongoDBStore) Refresh(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if !touched(r, session) {
+		return nil
+	}
+	encodedID, err := mstore.encodeCookie(session)
+	if err != nil {
+		return err
+	}
+	return mstore.writeCookie(r, w, session, encodedID)
+}
+
+// touched reports whether New bumped the modified timestamp of session
+// during request r.
+func touched(r *http.Request, session *sessions.Session) bool {
+	state := getRequestState(r, false)
+	if state == nil {
+		return false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	loaded, ok := state.loaded[session]
+
+	return ok && loaded.touched
+}
+
+// finishUnchanged sets the cookie of a session Save left unchanged. With
+// SkipUnmodified the browser keeps the cookie it sent, unless New bumped the
+// modified timestamp, which the cookie has to follow.
+func (mstore *MongoDBStore) finishUnchanged(r *http.Request, w http.ResponseWriter, op *saveOp) error {
+	if mstore.skipUnmodified && !touched(r, op.session) {
+		return nil
+	}
+	return mstore.writeCookie(r, w, op.session, op.encodedID)
+}
+
+// Touch bumps the modified timestamp of session to now and refreshes its
+// cookie, extending its lifetime with a single update that neither encodes
+// nor writes its values. It returns ErrSessionNotFound for a session that
+// was never saved or no longer exists.
+func (mstore *MongoDBStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.IsNew || session.ID == "" {
+		return ErrSessionNotFound
+	}
+	ctx, cancel := mstore.saveContext(r.Context())
+	defer cancel()
+	ctx, err := mstore.routeRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	ID, err := mstore.docID(session.ID)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	filter, err := mstore.scopeFilter(ctx, bson.M{"_id": ID})
+	if err != nil {
+		return err
+	}
+	encodedID, err := mstore.encodeCookie(session)
+	if err != nil {
+		return err
+	}
+
+	var created time.Time
+	if meta, ok := mstore.SessionMeta(r, session.Name()); ok {
+		created = meta.Created
+	}
+	now := time.Now()
+	set := bson.M{
+		mstore.fieldNames.Modified:  now,
+		mstore.fieldNames.ExpiresAt: mstore.expiresAt(created, now, session.Options.MaxAge),
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, session.ID))
+	res, err := mstore.updateOne(ctx, mstore.collection(ctx), filter, bson.M{"$set": set})
+	if err != nil {
+		return &StorageError{"error touching session", err}
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return mstore.writeCookie(r, w, session, encodedID)
+}
+
+// TouchByID bumps the modified timestamp of the session with the given ID
+// to now, extending its lifetime without rewriting its values. It returns
+// ErrSessionNotFound when there is no such session, or when it has expired
+// or is past AbsoluteMaxAge, as TouchByID does not revive sessions.
+func (mstore *MongoDBStore) TouchByID(ctx context.Context, sessionID string) error {
+	ID, err := mstore.docID(sessionID)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	now := time.Now()
+	filter, err := mstore.scopeFilter(ctx, bson.M{"_id": ID, "$nor": bson.A{mstore.expiredFilter(now)}})
+	if err != nil {
+		return err
+	}
+	sessDoc := &sessionDoc{}
+	err = mstore.findOne(ctx, mstore.coll, filter, sessDoc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return &StorageError{"error loading session", err}
+	}
+
+	// The update only applies while the document is live and unchanged
+	// since it was read, so that the lifetime read stays accurate.
+	names := mstore.fieldNames
+	filter[names.Modified] = sessDoc.Modified
+	set := bson.M{names.Modified: now}
+	if !sessDoc.ExpiresAt.IsZero() {
+		set[names.ExpiresAt] = mstore.capExpiry(created(sessDoc), now.Add(sessDoc.ExpiresAt.Sub(sessDoc.Modified)))
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, sessionID))
+	res, err := mstore.updateOne(ctx, mstore.coll, filter, bson.M{"$set": set})
+	if err != nil {
+		return &StorageError{"error touching session", err}
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// touchDoc moves the modified timestamp of sessDoc to now in coll, and its
+// expiry time along with it.
+func (mstore *MongoDBStore) touchDoc(ctx context.Context, coll *mongo.Collection, sessDoc *sessionDoc, now time.Time) (matched bool, err error) {
+	set := bson.M{mstore.fieldNames.Modified: now}
+	if !sessDoc.ExpiresAt.IsZero() {
+		set[mstore.fieldNames.ExpiresAt] = mstore.capExpiry(created(sessDoc), now.Add(sessDoc.ExpiresAt.Sub(sessDoc.Modified)))
+	}
+	mstore.cache.invalidate(mstore.cacheKey(ctx, idString(sessDoc.ID)))
+	res, err := mstore.updateOne(ctx, coll, withTenant(bson.M{"_id": sessDoc.ID}, sessDoc.TenantID), bson.M{"$set": set})
+	if err != nil {
+		return false, &StorageError{"error touching session", err}
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// unchanged reports whether session was loaded during request r and neither
+// its values nor its options changed since.
+func (mstore *MongoDBStore) unchanged(r *http.Request, session *sessions.Session) bool {
+	state := getRequestState(r, false)
+	if state == nil {
+		return false
+	}
+	state.mu.Lock()
+	loaded, ok := state.loaded[session]
+	state.mu.Unlock()
+	if !ok || session.IsNew || loaded.stale {
+		return false
+	}
+
+	return loaded.options == *session.Options && reflect.DeepEqual(loaded.values, session.Values)
+}
@@ -0,0 +1,473 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func readTestDoc(t *testing.T, coll *mongo.Collection) *sessionDoc {
+	sessDoc := &sessionDoc{}
+	if err := coll.FindOne(context.Background(), bson.M{}).Decode(sessDoc); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	return sessDoc
+}
+
+func TestTouchInterval(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.TouchInterval = time.Hour
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.Get(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	saved := readTestDoc(t, coll)
+
+	request := func() (*http.Request, *httptest.ResponseRecorder) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return req, httptest.NewRecorder()
+	}
+
+	// Unchanged session within the interval: no write at all.
+	req, resp = request()
+	session, _ = store.Get(req, "session-key")
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); doc.Data != saved.Data || !doc.Modified.Equal(saved.Modified) {
+		t.Error("Expected unchanged session not to be written")
+	}
+	if resp.Header().Get("Set-Cookie") == "" {
+		t.Error("Expected the cookie to be set for an unchanged session")
+	}
+
+	// Changed values are always saved.
+	req, resp = request()
+	session, _ = store.Get(req, "session-key")
+	session.Values["foo"] = "baz"
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	changed := readTestDoc(t, coll)
+	if changed.Data == saved.Data {
+		t.Error("Expected changed session to be written")
+	}
+
+	// A session older than the interval is touched on load without
+	// rewriting its data.
+	old := time.Now().Add(-2 * time.Hour)
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"modified": old}}); err != nil {
+		t.Fatalf("Error aging session: %v", err)
+	}
+	req, _ = request()
+	if session, err = store.Get(req, "session-key"); err != nil || session.Values["foo"] != "baz" {
+		t.Fatalf("Error loading session: %v, values %v", err, session.Values)
+	}
+	touched := readTestDoc(t, coll)
+	if touched.Data != changed.Data {
+		t.Error("Expected touch not to rewrite the session data")
+	}
+	if time.Since(touched.Modified) > time.Minute {
+		t.Errorf("Expected modified to be bumped; Got %v", touched.Modified)
+	}
+	if !touched.ExpiresAt.After(changed.ExpiresAt) {
+		t.Errorf("Expected expires_at to move forward; Got %v, was %v", touched.ExpiresAt, changed.ExpiresAt)
+	}
+}
+
+func TestTouchIntervalDisabled(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.Get(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	saved := readTestDoc(t, coll)
+
+	time.Sleep(10 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, _ = store.Get(req, "session-key")
+	if err = session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); !doc.Modified.After(saved.Modified) {
+		t.Error("Expected every Save to rewrite the session without TouchInterval")
+	}
+}
+
+func TestSkipUnmodified(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SkipUnmodified = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	// A new session is always written.
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.Get(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	saved := readTestDoc(t, coll)
+	request := func() (*http.Request, *httptest.ResponseRecorder) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		return req, httptest.NewRecorder()
+	}
+
+	// Unchanged: neither written nor touched, however old.
+	old := time.Now().Add(-2 * time.Hour)
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"modified": old}}); err != nil {
+		t.Fatalf("Error aging session: %v", err)
+	}
+	req, resp = request()
+	session, _ = store.Get(req, "session-key")
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); doc.Data != saved.Data || time.Since(doc.Modified) < time.Hour {
+		t.Error("Expected unchanged session not to be written")
+	}
+	if resp.Header().Get("Set-Cookie") != "" {
+		t.Error("Expected no cookie for an unchanged session")
+	}
+
+	// Changed options are saved.
+	req, resp = request()
+	session, _ = store.Get(req, "session-key")
+	session.Options.MaxAge = 60
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); time.Since(doc.Modified) > time.Minute || resp.Header().Get("Set-Cookie") == "" {
+		t.Error("Expected a session with changed options to be written")
+	}
+
+	// Changed values are saved.
+	req, resp = request()
+	session, _ = store.Get(req, "session-key")
+	session.Values["foo"] = "baz"
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); doc.Data == saved.Data || time.Since(doc.Modified) > time.Minute {
+		t.Error("Expected changed session to be written")
+	}
+	if resp.Header().Get("Set-Cookie") == "" {
+		t.Error("Expected the cookie to be set for a changed session")
+	}
+}
+
+func TestSkipUnmodifiedSliding(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SkipUnmodified = true
+	cfg.TouchInterval = time.Minute
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.Get(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$set": bson.M{"modified": time.Now().Add(-time.Hour)}}); err != nil {
+		t.Fatalf("Error aging session: %v", err)
+	}
+
+	// New bumps the modified timestamp, so Save re-issues the cookie.
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookie)
+	resp = httptest.NewRecorder()
+	session, _ = store.Get(req, "session-key")
+	if err = session.Save(req, resp); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if doc := readTestDoc(t, coll); time.Since(doc.Modified) > time.Minute {
+		t.Error("Expected New to bump the modified timestamp")
+	}
+	if resp.Header().Get("Set-Cookie") == "" {
+		t.Error("Expected the cookie to follow the bumped session")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	saved := readTestDoc(t, coll)
+
+	time.Sleep(10 * time.Millisecond)
+	if err = store.TouchByID(context.Background(), session.ID); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	touched := readTestDoc(t, coll)
+	if !touched.Modified.After(saved.Modified) {
+		t.Errorf("Expected Touch to bump modified; Got %v, was %v", touched.Modified, saved.Modified)
+	}
+	if got, want := touched.ExpiresAt.Sub(touched.Modified), saved.ExpiresAt.Sub(saved.Modified); got != want {
+		t.Errorf("Expected the expiry to move along with modified; Got %v, want %v", got, want)
+	}
+	if touched.Data != saved.Data {
+		t.Error("Expected Touch to leave the session data alone")
+	}
+
+	if _, err = coll.DeleteMany(context.Background(), bson.M{}); err != nil {
+		t.Fatalf("Error deleting sessions: %v", err)
+	}
+	if err = store.TouchByID(context.Background(), session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestTouchSession(t *testing.T) {
+	coll := newTestCollection(t)
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Touch(req, httptest.NewRecorder(), session); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for a new session; Got %v", err)
+	}
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	session.IsNew = false
+	saved := readTestDoc(t, coll)
+
+	time.Sleep(10 * time.Millisecond)
+	resp := httptest.NewRecorder()
+	if err = store.Touch(req, resp, session); err != nil {
+		t.Fatalf("Error touching session: %v", err)
+	}
+	touched := readTestDoc(t, coll)
+	if !touched.Modified.After(saved.Modified) {
+		t.Errorf("Expected Touch to bump modified; Got %v, was %v", touched.Modified, saved.Modified)
+	}
+	if got, want := touched.ExpiresAt.Sub(touched.Modified), saved.ExpiresAt.Sub(saved.Modified); got != want {
+		t.Errorf("Expected the expiry to move along with modified; Got %v, want %v", got, want)
+	}
+	if touched.Data != saved.Data {
+		t.Error("Expected Touch to leave the session data alone")
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=") {
+		t.Errorf("Expected Touch to refresh the cookie; Got %q", cookie)
+	}
+
+	if _, err = coll.DeleteMany(context.Background(), bson.M{}); err != nil {
+		t.Fatalf("Error deleting sessions: %v", err)
+	}
+	resp = httptest.NewRecorder()
+	if err = store.Touch(req, resp, session); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected no cookie for a missing session; Got %q", cookie)
+	}
+}
+
+func TestTouchPastCodecMaxAge(t *testing.T) {
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 1
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["user"] = "alice"
+	resp := httptest.NewRecorder()
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if session, err = loadWithCookie(store, resp.Header().Get("Set-Cookie")); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+
+	// The data is never re-encoded while touches keep the session alive
+	// past the codec MaxAge.
+	for i := 0; i < 3; i++ {
+		time.Sleep(800 * time.Millisecond)
+		resp = httptest.NewRecorder()
+		if err = store.Touch(req, resp, session); err != nil {
+			t.Fatalf("Error touching session: %v", err)
+		}
+	}
+	loaded, err := loadWithCookie(store, resp.Header().Get("Set-Cookie"))
+	if err != nil {
+		t.Fatalf("Expected the touched session to load; Got %v", err)
+	}
+	if loaded.Values["user"] != "alice" {
+		t.Errorf("Expected the saved values; Got %v", loaded.Values)
+	}
+}
+
+func TestTouchByIDExpired(t *testing.T) {
+	cfg := defaultConfig
+	cfg.AbsoluteMaxAge = 3600
+	store, err := NewMemoryStore(cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing memory store: %v", err)
+	}
+	ctx := context.Background()
+	save := func(set bson.M) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		session, _ := store.New(req, "session-key")
+		if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		ID, _ := store.docID(session.ID)
+		if _, err := store.ops.updateOne(ctx, store.coll, bson.M{"_id": ID}, bson.M{"$set": set}); err != nil {
+			t.Fatalf("Error updating session: %v", err)
+		}
+		return session.ID
+	}
+
+	if err = store.TouchByID(ctx, save(bson.M{})); err != nil {
+		t.Errorf("Error touching session: %v", err)
+	}
+	expired := save(bson.M{store.fieldNames.ExpiresAt: time.Now().Add(-time.Minute)})
+	if err = store.TouchByID(ctx, expired); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for an expired session; Got %v", err)
+	}
+	old := save(bson.M{store.fieldNames.Created: time.Now().Add(-2 * time.Hour)})
+	if err = store.TouchByID(ctx, old); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound past AbsoluteMaxAge; Got %v", err)
+	}
+	if _, err = store.GetByID(ctx, expired); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the expired session to stay expired; Got %v", err)
+	}
+}
+
+// benchmarkTouch saves a session of about 10KB and then keeps it alive with
+// keepAlive.
+func benchmarkTouch(b *testing.B, keepAlive func(*MongoDBStore, *http.Request, http.ResponseWriter, *sessions.Session) error) {
+	store, err := NewMongoDBStore(newTestCollection(b), []byte("secret"))
+	if err != nil {
+		b.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	session.Values["payload"] = strings.Repeat("x", 10*1024)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		b.Fatalf("Error saving session: %v", err)
+	}
+	session.IsNew = false
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = keepAlive(store, req, httptest.NewRecorder(), session); err != nil {
+			b.Fatalf("Error keeping session alive: %v", err)
+		}
+	}
+}
+
+func BenchmarkTouch(b *testing.B) {
+	benchmarkTouch(b, (*MongoDBStore).Touch)
+}
+
+func BenchmarkTouchSave(b *testing.B) {
+	benchmarkTouch(b, (*MongoDBStore).Save)
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SlidingExpiration = 0.5
+	cfg.SessionOptions.MaxAge = 60
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.SaveWithModified(req, resp, session, time.Now().Add(-40*time.Second)); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Header().Get("Set-Cookie")
+	saved := readTestDoc(t, coll)
+
+	load := func() (*http.Request, *sessions.Session) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil || session.IsNew {
+			t.Fatalf("Expected the saved session to load; Got err %v", err)
+		}
+		return req, session
+	}
+
+	// Past half of MaxAge the session is refreshed on load.
+	req, session = load()
+	resp = httptest.NewRecorder()
+	if err = store.Refresh(req, resp, session); err != nil {
+		t.Fatalf("Error refreshing session: %v", err)
+	}
+	if got := resp.Header().Get("Set-Cookie"); !strings.Contains(got, "Max-Age=60") {
+		t.Errorf("Expected Refresh to re-issue the cookie; Got %q", got)
+	}
+	if touched := readTestDoc(t, coll); !touched.Modified.After(saved.Modified.Add(30 * time.Second)) {
+		t.Errorf("Expected New to bump modified; Got %v, was %v", touched.Modified, saved.Modified)
+	}
+
+	// A recently refreshed session is left alone.
+	req, session = load()
+	resp = httptest.NewRecorder()
+	if err = store.Refresh(req, resp, session); err != nil {
+		t.Fatalf("Error refreshing session: %v", err)
+	}
+	if got := resp.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("Expected no cookie for a fresh session; Got %q", got)
+	}
+
+	cfg.SlidingExpiration = 1.5
+	if _, err = NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err == nil {
+		t.Error("Expected an error for SlidingExpiration above 1")
+	}
+}
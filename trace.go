@@ -0,0 +1,52 @@
+package mongodbstoregorilla
+
+import "context"
+
+// Tracer starts a span around the store operations, so that they show up
+// in request traces. The otelstore package adapts an OpenTelemetry
+// TracerProvider. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts the span of op, a child of the span in ctx if any, and
+	// returns a context carrying it.
+	Start(ctx context.Context, op string) (context.Context, Span)
+}
+
+// Span is the span of one store operation.
+type Span interface {
+	// SetAttribute records an attribute of the operation. value is a
+	// string, bool, int or int64.
+	SetAttribute(key string, value interface{})
+	// End records err, if not nil, and ends the span.
+	End(err error)
+}
+
+// Span operations, passed to Tracer.Start.
+const (
+	OpLoad          = "Load"
+	OpSave          = "Save"
+	OpDelete        = "Delete"
+	OpSaveAll       = "SaveAll"
+	OpDeleteExpired = "DeleteExpired"
+	OpEnsureIndexes = "EnsureIndexes"
+)
+
+// startSpan starts the span of op with the attributes shared by all
+// operations. Callers check that the store has a tracer first.
+func (mstore *MongoDBStore) startSpan(ctx context.Context, op, dbOperation string) (context.Context, Span) {
+	ctx, span := mstore.tracer.Start(ctx, op)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.name", mstore.coll.Database().Name())
+	span.SetAttribute("db.mongodb.collection", mstore.coll.Name())
+	span.SetAttribute("db.operation", dbOperation)
+
+	return ctx, span
+}
+
+// setSessionID records the session ID on span as the hash the Logger gets,
+// never the ID itself, which would let anyone reading traces take over the
+// session.
+func setSessionID(span Span, id string) {
+	if id != "" {
+		span.SetAttribute("session.id_hash", logID(id))
+	}
+}
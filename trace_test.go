@@ -0,0 +1,80 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type recordingSpan struct {
+	op    string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+
+func (s *recordingSpan) End(err error) { s.err, s.ended = err, true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, op string) (context.Context, Span) {
+	span := &recordingSpan{op: op, attrs: make(map[string]interface{})}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return ctx, span
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	cfg := defaultConfig
+	cfg.Tracer = tracer
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if _, err = store.New(req, "session-key"); err != nil {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	if err = store.DeleteByID(context.Background(), session.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	deleteErr := store.DeleteByID(context.Background(), session.ID)
+
+	want := []string{OpEnsureIndexes, OpSave, OpLoad, OpDelete, OpDelete}
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("Expected %d spans; Got %d", len(want), len(tracer.spans))
+	}
+	for i, span := range tracer.spans {
+		if span.op != want[i] || !span.ended {
+			t.Errorf("Expected ended span %s; Got %+v", want[i], span)
+		}
+	}
+	if tracer.spans[2].attrs["session.is_new"] != false || tracer.spans[2].attrs["db.operation"] != "find" {
+		t.Errorf("Expected the load span to report a stored session; Got %v", tracer.spans[2].attrs)
+	}
+	for _, span := range tracer.spans[1:] {
+		if span.attrs["session.id_hash"] != logID(session.ID) {
+			t.Errorf("Expected %s to record the session ID hash; Got %v", span.op, span.attrs)
+		}
+	}
+	if tracer.spans[4].err != deleteErr || deleteErr == nil {
+		t.Errorf("Expected the failed delete to be recorded; Got %v", tracer.spans[4].err)
+	}
+}
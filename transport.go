@@ -0,0 +1,92 @@
+package mongodbstoregorilla
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// ErrNoToken is returned by HeaderTransport when a request carries no
+// session token.
+var ErrNoToken = errors.New("mongodbstore: no session token")
+
+// TokenTransport carries the token of a session, its signed ID, between the
+// client and the store. CookieTransport is the default.
+type TokenTransport interface {
+	// GetToken returns the token of the session name sent with r, or an
+	// error when there is none, which makes New start a new session.
+	GetToken(r *http.Request, name string) (string, error)
+	// SetToken sends value to the client as the token of the session name.
+	// A negative opts.MaxAge clears the token instead.
+	SetToken(w http.ResponseWriter, name, value string, opts *sessions.Options) error
+}
+
+// CookieTransport carries session tokens in cookies named after the
+// session, with the cookie attributes of its Options.
+type CookieTransport struct{}
+
+// GetToken implements TokenTransport.
+func (CookieTransport) GetToken(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// SetToken implements TokenTransport.
+func (CookieTransport) SetToken(w http.ResponseWriter, name, value string, opts *sessions.Options) error {
+	http.SetCookie(w, sessions.NewCookie(name, value, opts))
+	return nil
+}
+
+// DefaultTokenHeader is the header of HeaderTransport when none is set.
+const DefaultTokenHeader = "X-Session-Token"
+
+// HeaderTransport carries session tokens in a header, for clients that do
+// not keep cookies. It carries a single session, whatever its name.
+//
+// The token is read from Header of the request, after Scheme if one is set,
+// e.g. "Authorization" with "Bearer", and sent in Header of the response.
+// A cleared token is sent as an empty header.
+type HeaderTransport struct {
+	// header of the token, DefaultTokenHeader by default
+	Header string
+	// authentication scheme preceding the token in requests, e.g. "Bearer",
+	// empty for none
+	Scheme string
+}
+
+func (ht HeaderTransport) header() string {
+	if ht.Header == "" {
+		return DefaultTokenHeader
+	}
+	return ht.Header
+}
+
+// GetToken implements TokenTransport.
+func (ht HeaderTransport) GetToken(r *http.Request, name string) (string, error) {
+	token := strings.TrimSpace(r.Header.Get(ht.header()))
+	if ht.Scheme != "" {
+		fields := strings.Fields(token)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], ht.Scheme) {
+			return "", ErrNoToken
+		}
+		token = fields[1]
+	}
+	if token == "" {
+		return "", ErrNoToken
+	}
+	return token, nil
+}
+
+// SetToken implements TokenTransport.
+func (ht HeaderTransport) SetToken(w http.ResponseWriter, name, value string, opts *sessions.Options) error {
+	if opts.MaxAge < 0 {
+		value = ""
+	}
+	w.Header().Set(ht.header(), value)
+	return nil
+}
@@ -0,0 +1,88 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestHeaderTransport(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Transport = HeaderTransport{Header: "Authorization", Scheme: "Bearer"}
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx := context.Background()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["foo"] = "bar"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if cookie := resp.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Expected no cookie; Got %q", cookie)
+	}
+	token := resp.Header().Get("Authorization")
+	if token == "" {
+		t.Fatal("Expected the token in the response header")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew || session.Values["foo"] != "bar" {
+		t.Fatalf("Expected the session to load from the header; Got %v, %v", session.Values, err)
+	}
+
+	// The token is cleared when the session is deleted.
+	resp = httptest.NewRecorder()
+	session.Options.MaxAge = -1
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if values, ok := resp.Header()["Authorization"]; !ok || len(values) != 1 || values[0] != "" {
+		t.Errorf("Expected an empty token header; Got %v", values)
+	}
+	if n, _ := coll.CountDocuments(ctx, bson.M{}); n != 0 {
+		t.Errorf("Expected the session to be deleted; Got %d documents", n)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if session, err = store.New(req, "session-key"); err != nil || !session.IsNew {
+		t.Errorf("Expected a new session for the deleted token; Got IsNew %t, err %v", session.IsNew, err)
+	}
+}
+
+func TestHeaderTransportGetToken(t *testing.T) {
+	for _, tc := range []struct {
+		transport HeaderTransport
+		header    string
+		value     string
+		want      string
+	}{
+		{HeaderTransport{}, DefaultTokenHeader, "token", "token"},
+		{HeaderTransport{Header: "Authorization", Scheme: "Bearer"}, "Authorization", "bearer token", "token"},
+		{HeaderTransport{Header: "Authorization", Scheme: "Bearer"}, "Authorization", "Basic token", ""},
+		{HeaderTransport{Header: "Authorization", Scheme: "Bearer"}, "Authorization", "token", ""},
+		{HeaderTransport{}, DefaultTokenHeader, "", ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Set(tc.header, tc.value)
+		got, err := tc.transport.GetToken(req, "session-key")
+		if tc.want == "" && !errors.Is(err, ErrNoToken) {
+			t.Errorf("%s %q: expected ErrNoToken; Got %q, %v", tc.header, tc.value, got, err)
+		}
+		if tc.want != "" && (err != nil || got != tc.want) {
+			t.Errorf("%s %q: expected %q; Got %q, %v", tc.header, tc.value, tc.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TTLIndexOptions configures the TTL index created with IndexTTL.
+type TTLIndexOptions struct {
+	// index name, "" for modified_TTL, or expires_at_TTL with ExpiresAtTTL
+	Name string
+	// whether to leave documents without the TTL field out of the index,
+	// nil for true
+	Sparse *bool
+	// filter restricting the index, and so expiry, to matching documents,
+	// e.g. bson.M{"pending": bson.M{"$exists": false}}, nil for none. It can
+	// not be combined with Sparse.
+	PartialFilterExpression interface{}
+	// number of data-bearing voting members, or "majority", that must build
+	// the index before it is ready, nil for the server default. Requires
+	// MongoDB 4.4 on a replica set.
+	CommitQuorum interface{}
+}
+
+// ErrTTLIndexOptions is returned by the constructors for TTLIndexOptions
+// the server would reject.
+var ErrTTLIndexOptions = errors.New("mongodbstore: invalid TTL index options")
+
+// TTLIndexConflictError is returned by the constructors and MigrateTTLIndex
+// when the TTL index can not be created because an index the store does
+// not manage conflicts with it, e.g. one on the same field under another
+// name. Drop that index or set TTLIndexOptions.Name to its name.
+type TTLIndexConflictError struct {
+	Index string
+	Field string
+	Err   error
+}
+
+func (e *TTLIndexConflictError) Error() string {
+	return fmt.Sprintf("mongodbstore: TTL index %s on %s conflicts with an existing index: %v", e.Index, e.Field, e.Err)
+}
+
+// Unwrap returns the error of creating the index.
+func (e *TTLIndexConflictError) Unwrap() error {
+	return e.Err
+}
+
+// validate checks opts before any index is built with them.
+func (opts TTLIndexOptions) validate() error {
+	if opts.PartialFilterExpression != nil && opts.sparse() {
+		return fmt.Errorf("%w: PartialFilterExpression can not be combined with Sparse", ErrTTLIndexOptions)
+	}
+	if opts.PartialFilterExpression != nil {
+		if _, err := normalizeDocument(opts.PartialFilterExpression); err != nil {
+			return fmt.Errorf("%w: PartialFilterExpression: %v", ErrTTLIndexOptions, err)
+		}
+	}
+	return nil
+}
+
+// sparse reports whether the index is sparse. Without an explicit Sparse, it
+// is unless a partial filter is set.
+func (opts TTLIndexOptions) sparse() bool {
+	if opts.Sparse != nil {
+		return *opts.Sparse
+	}
+	return opts.PartialFilterExpression == nil
+}
+
+// ttlIndexInfo is an entry of the index list of the collection.
+type ttlIndexInfo struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.M   `bson:"key"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	Sparse                  bool     `bson:"sparse"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+}
+
+// matches reports whether the existing index info has the key and options
+// of the TTL index on field, apart from expireAfterSeconds.
+func (info *ttlIndexInfo) matches(field string, opts TTLIndexOptions) bool {
+	if len(info.Key) != 1 || fmt.Sprint(info.Key[field]) != "1" || info.Sparse != opts.sparse() {
+		return false
+	}
+	if opts.PartialFilterExpression == nil || info.PartialFilterExpression == nil {
+		return opts.PartialFilterExpression == nil && info.PartialFilterExpression == nil
+	}
+	want, err := normalizeDocument(opts.PartialFilterExpression)
+	if err != nil {
+		return false
+	}
+	got := bson.M{}
+	if err = bson.Unmarshal(info.PartialFilterExpression, &got); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+// normalizeDocument round-trips doc through BSON, so that it compares equal
+// to the same document read back from the server.
+func normalizeDocument(doc interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	normalized := bson.M{}
+	err = bson.Unmarshal(raw, &normalized)
+	return normalized, err
+}
+
+// hasTTLIndex reports whether the collection of ctx has the named TTL index
+// on field with the configured options.
+func (mstore *MongoDBStore) hasTTLIndex(ctx context.Context, name, field string) (bool, error) {
+	cursor, err := mstore.collection(ctx).Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		indexInfo := &ttlIndexInfo{}
+		if err = cursor.Decode(indexInfo); err != nil {
+			return false, err
+		}
+		if indexInfo.Name == name {
+			return indexInfo.matches(field, mstore.ttlIndexOptions), nil
+		}
+	}
+	return false, cursor.Err()
+}
+
+// ttlIndexCommand returns the createIndexes command for the TTL index.
+func (mstore *MongoDBStore) ttlIndexCommand(collName, name, field string, expireAfterSeconds int64) bson.D {
+	opts := mstore.ttlIndexOptions
+	index := bson.D{
+		{Key: "key", Value: bson.D{{Key: field, Value: 1}}},
+		{Key: "name", Value: name},
+		{Key: "expireAfterSeconds", Value: int32(expireAfterSeconds)},
+	}
+	if opts.sparse() {
+		index = append(index, bson.E{Key: "sparse", Value: true})
+	}
+	if opts.PartialFilterExpression != nil {
+		index = append(index, bson.E{Key: "partialFilterExpression", Value: opts.PartialFilterExpression})
+	}
+	cmd := bson.D{
+		{Key: "createIndexes", Value: collName},
+		{Key: "indexes", Value: bson.A{index}},
+	}
+	if opts.CommitQuorum != nil {
+		cmd = append(cmd, bson.E{Key: "commitQuorum", Value: opts.CommitQuorum})
+	}
+	return cmd
+}
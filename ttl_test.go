@@ -0,0 +1,512 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type testIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.M `bson:"key"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+	Sparse             bool   `bson:"sparse"`
+
+	PartialFilterExpression bson.M `bson:"partialFilterExpression"`
+}
+
+func listTestIndexes(t *testing.T, coll *mongo.Collection) map[string]testIndex {
+	cursor, err := coll.Indexes().List(context.Background())
+	if err != nil {
+		t.Fatalf("Error listing indexes: %v", err)
+	}
+	var list []testIndex
+	if err = cursor.All(context.Background(), &list); err != nil {
+		t.Fatalf("Error decoding indexes: %v", err)
+	}
+	indexes := make(map[string]testIndex, len(list))
+	for _, index := range list {
+		indexes[index.Name] = index
+	}
+	return indexes
+}
+
+func TestTTLIndexMigration(t *testing.T) {
+	coll := newTestCollection(t)
+	ctx := context.Background()
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"modified_at": 1},
+		Options: options.Index().SetName(legacyTTLIndexName).SetExpireAfterSeconds(3600).SetSparse(true),
+	})
+	if err != nil {
+		t.Fatalf("Error creating legacy index: %v", err)
+	}
+
+	store, err := NewMongoDBStore(coll, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	indexes := listTestIndexes(t, coll)
+	if _, ok := indexes[legacyTTLIndexName]; ok {
+		t.Error("Expected legacy TTL index to be dropped")
+	}
+	index, ok := indexes[ttlIndexName]
+	if !ok {
+		t.Fatalf("Expected TTL index %s; Got %v", ttlIndexName, indexes)
+	}
+	if index.ExpireAfterSeconds == nil || int(*index.ExpireAfterSeconds) != store.options.MaxAge {
+		t.Errorf("Expected expireAfterSeconds %d; Got %v", store.options.MaxAge, index.ExpireAfterSeconds)
+	}
+
+	// The indexed field must be the one session documents actually carry,
+	// otherwise the TTL monitor never reaps anything.
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	var raw bson.M
+	if err = coll.FindOne(ctx, bson.M{}).Decode(&raw); err != nil {
+		t.Fatalf("Error reading session document: %v", err)
+	}
+	for field := range index.Key {
+		if _, ok := raw[field]; !ok {
+			t.Errorf("Expected session document to have TTL field %q; Got %v", field, raw)
+		}
+	}
+
+	if err = store.MigrateTTLIndex(ctx); err != nil {
+		t.Errorf("Expected migrating again to be a no-op; Got %v", err)
+	}
+}
+
+func TestTTLIndexMaxAgeChange(t *testing.T) {
+	coll := newTestCollection(t)
+	newStore := func(maxAge int) {
+		cfg := defaultConfig
+		cfg.SessionOptions.MaxAge = maxAge
+		if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+			t.Fatalf("Error initializing mongodb store with MaxAge %d: %v", maxAge, err)
+		}
+		index, ok := listTestIndexes(t, coll)[ttlIndexName]
+		if !ok {
+			t.Fatalf("Expected TTL index %s", ttlIndexName)
+		}
+		if index.ExpireAfterSeconds == nil || int(*index.ExpireAfterSeconds) != maxAge {
+			t.Errorf("Expected expireAfterSeconds %d; Got %v", maxAge, index.ExpireAfterSeconds)
+		}
+	}
+
+	newStore(3600 * 24 * 30)
+	newStore(3600 * 24 * 7)
+	// Unchanged MaxAge, e.g. a second instance starting up.
+	newStore(3600 * 24 * 7)
+}
+
+func TestIsIndexConflict(t *testing.T) {
+	if !isIndexConflict(mongo.CommandError{Code: 85, Name: "IndexOptionsConflict"}) {
+		t.Error("Expected IndexOptionsConflict to be an index conflict")
+	}
+	if isIndexConflict(mongo.CommandError{Code: 11000}) || isIndexConflict(nil) {
+		t.Error("Expected other errors not to be index conflicts")
+	}
+}
+
+func TestExpiresAtTTL(t *testing.T) {
+	coll := newTestCollection(t)
+	if _, err := NewMongoDBStore(coll, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cfg := defaultConfig
+	cfg.ExpiresAtTTL = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	indexes := listTestIndexes(t, coll)
+	if _, ok := indexes[ttlIndexName]; ok {
+		t.Errorf("Expected TTL index %s to be replaced", ttlIndexName)
+	}
+	index, ok := indexes[expiresAtTTLIndexName]
+	if !ok {
+		t.Fatalf("Expected TTL index %s; Got %v", expiresAtTTLIndexName, indexes)
+	}
+	if _, ok = index.Key["expires_at"]; !ok || index.ExpireAfterSeconds == nil || *index.ExpireAfterSeconds != 0 {
+		t.Errorf("Expected TTL index on expires_at with expireAfterSeconds 0; Got %+v", index)
+	}
+
+	save := func(maxAge int) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Options.MaxAge = maxAge
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return resp.Header().Get("Set-Cookie")
+	}
+	isNew := func(cookie string) bool {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return session.IsNew
+	}
+	rememberMe := save(3600 * 24 * 30)
+	shortLived := save(1)
+
+	time.Sleep(1100 * time.Millisecond)
+	if isNew(rememberMe) {
+		t.Error("Expected the remember-me session to be loaded")
+	}
+	if !isNew(shortLived) {
+		t.Error("Expected the short-lived session to have expired")
+	}
+
+	// Switching back restores the index on modified.
+	if _, err = NewMongoDBStore(coll, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	indexes = listTestIndexes(t, coll)
+	if _, ok = indexes[expiresAtTTLIndexName]; ok {
+		t.Errorf("Expected TTL index %s to be dropped", expiresAtTTLIndexName)
+	}
+	if _, ok = indexes[ttlIndexName]; !ok {
+		t.Errorf("Expected TTL index %s", ttlIndexName)
+	}
+}
+
+func TestBrowserSessionCookie(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 0
+	cfg.ServerSideTTL = time.Hour
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	// Neither the TTL index nor the codecs treat MaxAge 0 literally.
+	index, ok := listTestIndexes(t, coll)[ttlIndexName]
+	if !ok || index.ExpireAfterSeconds == nil || *index.ExpireAfterSeconds != 3600 {
+		t.Errorf("Expected TTL index with expireAfterSeconds 3600; Got %+v", index)
+	}
+	if store.codecMaxAge != 3600 {
+		t.Errorf("Expected codec MaxAge 3600; Got %d", store.codecMaxAge)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Result().Cookies()[0]
+	if cookie.MaxAge != 0 || !cookie.Expires.IsZero() {
+		t.Errorf("Expected a browser session cookie; Got %s", resp.Header().Get("Set-Cookie"))
+	}
+	sessDoc := readTestDoc(t, coll)
+	if got := sessDoc.ExpiresAt.Sub(sessDoc.Modified); got != time.Hour {
+		t.Errorf("Expected the document to expire after ServerSideTTL; Got %v", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.AddCookie(cookie)
+	if session, err = store.New(req, "session-key"); err != nil || session.IsNew {
+		t.Errorf("Expected the session to load; Got %v", err)
+	}
+}
+
+func TestBrowserSessionCookieDefaultTTL(t *testing.T) {
+	cfg := defaultConfig
+	cfg.SessionOptions.MaxAge = 0
+	// Without ServerSideTTL the codecs follow DefaultServerSideTTL.
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if want := int(DefaultServerSideTTL / time.Second); store.codecMaxAge != want {
+		t.Errorf("Expected codec MaxAge %d; Got %d", want, store.codecMaxAge)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	coll := newTestCollection(t)
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.Logger = logger
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	store.MaxAge(1)
+	if logger.find("warn", "max_age") == nil {
+		t.Error("Expected a warning that the TTL index needs migrating")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if session.Options.MaxAge != 1 {
+		t.Errorf("Expected new sessions to get MaxAge 1; Got %d", session.Options.MaxAge)
+	}
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	// Keep the document alive to check the codecs alone.
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$unset": bson.M{"expires_at": ""}}); err != nil {
+		t.Fatalf("Error updating session: %v", err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	if _, err = store.New(req, "session-key"); !errors.Is(err, ErrCookieExpired) {
+		t.Errorf("Expected ErrCookieExpired after MaxAge; Got %v", err)
+	}
+
+	if err = store.MigrateTTLIndex(context.Background()); err != nil {
+		t.Fatalf("Error migrating TTL index: %v", err)
+	}
+	index := listTestIndexes(t, coll)[ttlIndexName]
+	if index.ExpireAfterSeconds == nil || *index.ExpireAfterSeconds != 1 {
+		t.Errorf("Expected the TTL index to follow MaxAge; Got %v", index.ExpireAfterSeconds)
+	}
+}
+
+func TestMaxAgeCodecMaxAge(t *testing.T) {
+	newStore := func(cfg MongoDBStoreConfig) *MongoDBStore {
+		store, err := NewMemoryStore(cfg, []byte("secret"))
+		if err != nil {
+			t.Fatalf("Error initializing memory store: %v", err)
+		}
+		return store
+	}
+
+	cfg := defaultConfig
+	cfg.PerNameMaxAge = map[string]int{"remember": 7200}
+	store := newStore(cfg)
+	store.MaxAge(60)
+	if store.codecMaxAge != 7200 {
+		t.Errorf("Expected the codecs to keep the PerNameMaxAge; Got %d", store.codecMaxAge)
+	}
+
+	codecMaxAge := 0
+	cfg = defaultConfig
+	cfg.CodecMaxAge = &codecMaxAge
+	store = newStore(cfg)
+	store.MaxAge(60)
+	if store.codecMaxAge != 0 {
+		t.Errorf("Expected the codecs to keep CodecMaxAge; Got %d", store.codecMaxAge)
+	}
+}
+
+func TestTTLIndexExisting(t *testing.T) {
+	coll := newTestCollection(t)
+	ctx := context.Background()
+	maxAge := int32(defaultConfig.SessionOptions.MaxAge)
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"modified": 1},
+		Options: options.Index().SetName(ttlIndexName).SetExpireAfterSeconds(maxAge).SetSparse(true),
+	})
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.Logger = logger
+	if _, err = NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// A matching index is left alone.
+	if entry := logger.find("warn", "ensure_ttl_index"); entry != nil {
+		t.Errorf("Expected the matching index to be kept; Got %q", entry.msg)
+	}
+	if _, ok := listTestIndexes(t, coll)[ttlIndexName]; !ok {
+		t.Errorf("Expected TTL index %s", ttlIndexName)
+	}
+}
+
+func TestTTLIndexMismatch(t *testing.T) {
+	coll := newTestCollection(t)
+	ctx := context.Background()
+	// Same name, but keyed on another field and not sparse.
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"created": 1},
+		Options: options.Index().SetName(ttlIndexName).SetExpireAfterSeconds(int32(defaultConfig.SessionOptions.MaxAge)),
+	})
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.Logger = logger
+	if _, err = NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if logger.find("warn", "ensure_ttl_index") == nil {
+		t.Error("Expected a warning about the mismatched index")
+	}
+	index := listTestIndexes(t, coll)[ttlIndexName]
+	if _, ok := index.Key["modified"]; !ok || len(index.Key) != 1 || !index.Sparse {
+		t.Errorf("Expected a sparse TTL index on modified; Got %+v", index)
+	}
+}
+
+func TestTTLIndexOptions(t *testing.T) {
+	coll := newTestCollection(t)
+	sparse := false
+	cfg := defaultConfig
+	cfg.TTLIndexOptions = TTLIndexOptions{Name: "sessions_ttl", Sparse: &sparse}
+	if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	indexes := listTestIndexes(t, coll)
+	index, ok := indexes["sessions_ttl"]
+	if !ok {
+		t.Fatalf("Expected TTL index sessions_ttl; Got %v", indexes)
+	}
+	if index.Sparse {
+		t.Errorf("Expected an index that is not sparse; Got %+v", index)
+	}
+	if _, ok = indexes[ttlIndexName]; ok {
+		t.Errorf("Expected no TTL index %s", ttlIndexName)
+	}
+
+	// Unchanged options keep the index.
+	logger := &recordingLogger{}
+	cfg.Logger = logger
+	if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	if entry := logger.find("warn", "ensure_ttl_index"); entry != nil {
+		t.Errorf("Expected the index to be kept; Got %q", entry.msg)
+	}
+
+	sparse = true
+	cfg.TTLIndexOptions.PartialFilterExpression = bson.M{"pending": false}
+	if _, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret")); !errors.Is(err, ErrTTLIndexOptions) {
+		t.Errorf("Expected ErrTTLIndexOptions for a sparse partial index; Got %v", err)
+	}
+}
+
+func TestTTLIndexPartialFilter(t *testing.T) {
+	filter := bson.M{"pending": bson.M{"$exists": false}, "tenant_id": "a"}
+	store := &MongoDBStore{ttlIndexOptions: TTLIndexOptions{PartialFilterExpression: filter, CommitQuorum: "majority"}}
+	cmd := store.ttlIndexCommand("sessions", ttlIndexName, "modified", 3600)
+	raw, err := bson.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("Error marshaling command: %v", err)
+	}
+	var decoded struct {
+		Indexes []struct {
+			Sparse                  *bool    `bson:"sparse"`
+			PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+		} `bson:"indexes"`
+		CommitQuorum string `bson:"commitQuorum"`
+	}
+	if err = bson.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Error decoding command: %v", err)
+	}
+	if len(decoded.Indexes) != 1 || decoded.Indexes[0].Sparse != nil || decoded.CommitQuorum != "majority" {
+		t.Fatalf("Expected a partial index that is not sparse, with commitQuorum; Got %+v", decoded)
+	}
+
+	// The server returns the filter with its own key order.
+	info := &ttlIndexInfo{Key: bson.M{"modified": int32(1)}, PartialFilterExpression: decoded.Indexes[0].PartialFilterExpression}
+	if !info.matches("modified", store.ttlIndexOptions) {
+		t.Error("Expected the index with the configured filter to match")
+	}
+	other := TTLIndexOptions{PartialFilterExpression: bson.M{"pending": false}}
+	if info.matches("modified", other) || info.matches("modified", TTLIndexOptions{}) {
+		t.Error("Expected the index with another filter not to match")
+	}
+}
+
+func TestTTLIndexConflict(t *testing.T) {
+	coll := newTestCollection(t)
+	_, err := coll.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"modified": 1},
+		Options: options.Index().SetName("modified_1").SetExpireAfterSeconds(60),
+	})
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+
+	_, err = NewMongoDBStore(coll, []byte("secret"))
+	var conflictErr *TTLIndexConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Skipf("Expected a TTLIndexConflictError; the server accepted a second index on modified: %v", err)
+	}
+	if conflictErr.Index != ttlIndexName || conflictErr.Field != "modified" || !isIndexConflict(conflictErr.Err) {
+		t.Errorf("Expected the conflict of %s on modified; Got %v", ttlIndexName, conflictErr)
+	}
+}
+
+func TestExpiresAtBackfill(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.ExpiresAtTTL = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	cookie := saveTestSession(t, store)
+	// A document written before expires_at existed.
+	if _, err = coll.UpdateOne(context.Background(), bson.M{}, bson.M{"$unset": bson.M{"expires_at": ""}}); err != nil {
+		t.Fatalf("Error removing expires_at: %v", err)
+	}
+
+	session, err := loadWithCookie(store, cookie)
+	if err != nil || session.IsNew {
+		t.Fatalf("Expected the document without expires_at to load; Got %v", err)
+	}
+	session.Options.MaxAge = 600
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	if err = store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	sessDoc := readTestDoc(t, coll)
+	if got := sessDoc.ExpiresAt.Sub(sessDoc.Modified); got != 10*time.Minute {
+		t.Errorf("Expected Save to backfill expires_at from MaxAge; Got %v", got)
+	}
+}
+
+func TestTTLIndexConcurrentStartup(t *testing.T) {
+	coll := newTestCollection(t)
+	startAll := func(maxAge int) {
+		cfg := defaultConfig
+		cfg.SessionOptions.MaxAge = maxAge
+		errs := make(chan error, 5)
+		for i := 0; i < cap(errs); i++ {
+			go func() {
+				_, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+				errs <- err
+			}()
+		}
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err != nil {
+				t.Errorf("Error initializing mongodb store with MaxAge %d: %v", maxAge, err)
+			}
+		}
+		index, ok := listTestIndexes(t, coll)[ttlIndexName]
+		if !ok || index.ExpireAfterSeconds == nil || int(*index.ExpireAfterSeconds) != maxAge {
+			t.Errorf("Expected TTL index with expireAfterSeconds %d; Got %+v", maxAge, index)
+		}
+	}
+
+	startAll(3600)
+	// A redeploy with a new MaxAge reconciles the stale index.
+	startAll(7200)
+}
@@ -0,0 +1,81 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrPartialUpdateUnsupported is returned by UpdateValues unless the store
+// uses StorageBSON, since encoded values can only be rewritten as a whole.
+var ErrPartialUpdateUnsupported = errors.New("mongodbstore: partial updates require StorageBSON")
+
+// UpdateValues atomically sets and removes individual values of the session
+// with the given ID, without loading it, e.g. for counters updated by
+// concurrent requests. It bumps the modified timestamp and returns
+// ErrSessionNotFound when there is no such session.
+//
+// Sessions saved before switching to StorageBSON are not found until their
+// next Save. Requests that loaded the session before the update overwrite
+// it when they save, unless OptimisticLocking is enabled.
+func (mstore *MongoDBStore) UpdateValues(ctx context.Context, sessionID string, set map[string]interface{}, unset []string) error {
+	if mstore.storage != StorageBSON {
+		return ErrPartialUpdateUnsupported
+	}
+	ID, err := mstore.docID(sessionID)
+	if err != nil {
+		return fmt.Errorf("mongodbstore: invalid session ID: %w", err)
+	}
+	filter, err := mstore.scopeFilter(ctx, bson.M{"_id": ID, "values": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+
+	setFields := bson.M{mstore.fieldNames.Modified: time.Now()}
+	for key, val := range set {
+		if err = checkValueKey(key); err != nil {
+			return err
+		}
+		if _, err = bson.Marshal(bson.M{key: val}); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedBSONValue, err)
+		}
+		setFields["values."+key] = val
+	}
+	update := bson.M{"$set": setFields}
+	if len(unset) > 0 {
+		unsetFields := make(bson.M, len(unset))
+		for _, key := range unset {
+			if err = checkValueKey(key); err != nil {
+				return err
+			}
+			unsetFields["values."+key] = ""
+		}
+		update["$unset"] = unsetFields
+	}
+	if mstore.optimisticLocking {
+		update["$inc"] = bson.M{"version": 1}
+	}
+
+	mstore.cache.invalidate(mstore.cacheKey(ctx, sessionID))
+	res, err := mstore.updateOne(ctx, mstore.coll, filter, update)
+	if err != nil {
+		return &StorageError{"error updating session values", err}
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// checkValueKey rejects keys that MongoDB would read as a path or operator.
+func checkValueKey(key string) error {
+	if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+		return fmt.Errorf("%w: key %q is not a valid field name", ErrUnsupportedBSONValue, key)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUpdateValues(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.Storage = StorageBSON
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	session.Values["user_id"] = "alice"
+	session.Values["flash"] = "saved"
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	modified := readTestDoc(t, coll).Modified
+
+	// Two goroutines updating different keys do not lose each other's updates.
+	ctx := context.Background()
+	const updates = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*updates)
+	for _, key := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for i := 1; i <= updates; i++ {
+				errs <- store.UpdateValues(ctx, session.ID, map[string]interface{}{key: i}, nil)
+			}
+		}(key)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Error updating session values: %v", err)
+		}
+	}
+	if err = store.UpdateValues(ctx, session.ID, nil, []string{"flash"}); err != nil {
+		t.Fatalf("Error removing session value: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, err = store.New(req, "session-key")
+	if err != nil || session.IsNew {
+		t.Fatalf("Error loading session: %v", err)
+	}
+	want := map[interface{}]interface{}{"user_id": "alice", "a": int32(updates), "b": int32(updates)}
+	if fmt.Sprint(session.Values) != fmt.Sprint(want) {
+		t.Errorf("Expected values %v; Got %v", want, session.Values)
+	}
+	if sessDoc := readTestDoc(t, coll); !sessDoc.Modified.After(modified) {
+		t.Errorf("Expected the modified timestamp to be bumped; Got %v", sessDoc.Modified)
+	}
+
+	if err = store.UpdateValues(ctx, primitive.NewObjectID().Hex(), map[string]interface{}{"a": 1}, nil); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for a missing session; Got %v", err)
+	}
+	if err = store.UpdateValues(ctx, session.ID, map[string]interface{}{"a.b": 1}, nil); !errors.Is(err, ErrUnsupportedBSONValue) {
+		t.Errorf("Expected ErrUnsupportedBSONValue for a dotted key; Got %v", err)
+	}
+}
+
+func TestUpdateValuesEncoded(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	err = store.UpdateValues(context.Background(), primitive.NewObjectID().Hex(), map[string]interface{}{"a": 1}, nil)
+	if !errors.Is(err, ErrPartialUpdateUnsupported) {
+		t.Errorf("Expected ErrPartialUpdateUnsupported; Got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeleteOtherSessions deletes all sessions of userID except keepID and
+// returns how many were removed, e.g. to sign out of all other devices after
+// a password change. With DryRun it only counts them.
+//
+// It requires UserIDKey to be configured.
+func (mstore *MongoDBStore) DeleteOtherSessions(ctx context.Context, userID, keepID string) (int64, error) {
+	if mstore.userIDKey == "" {
+		return 0, errors.New("mongodbstore: DeleteOtherSessions requires UserIDKey")
+	}
+	if userID == "" {
+		return 0, errors.New("mongodbstore: empty user ID")
+	}
+	keep, err := mstore.docID(keepID)
+	if err != nil {
+		return 0, fmt.Errorf("mongodbstore: invalid session ID to keep: %w", err)
+	}
+	filter, err := mstore.scopeFilter(ctx, bson.M{"user_id": userID, "_id": bson.M{"$ne": keep}})
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := mstore.deleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("mongodbstore: error deleting user sessions: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// userID returns the user ID stored in the session values, or "".
+func (mstore *MongoDBStore) userID(session *sessions.Session) string {
+	if mstore.userIDKey == "" {
+		return ""
+	}
+	val, ok := session.Values[mstore.userIDKey]
+	if !ok || val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+func (mstore *MongoDBStore) ensureUserIDIndex(ctx context.Context) error {
+	indexModel := mongo.IndexModel{
+		Keys:    bson.M{"user_id": 1},
+		Options: options.Index().SetName("user_id").SetSparse(true),
+	}
+	if _, err := mstore.collection(ctx).Indexes().CreateOne(ctx, indexModel); err != nil {
+		return fmt.Errorf("mongodbstore: error ensuring user ID index: %w", err)
+	}
+
+	return nil
+}
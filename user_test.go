@@ -0,0 +1,59 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteOtherSessions(t *testing.T) {
+	cfg := defaultConfig
+	cfg.UserIDKey = "user_id"
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	login := func(userID interface{}) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		resp := httptest.NewRecorder()
+		session, _ := store.New(req, "session-key")
+		session.Values["user_id"] = userID
+		if err := store.Save(req, resp, session); err != nil {
+			t.Fatalf("Error saving session: %v", err)
+		}
+		return session.ID, resp.Header().Get("Set-Cookie")
+	}
+	isNew := func(cookie string) bool {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.Header.Add("Cookie", cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return session.IsNew
+	}
+
+	keepID, keepCookie := login(42)
+	_, otherCookie1 := login(42)
+	_, otherCookie2 := login(42)
+	_, strangerCookie := login(7)
+
+	deleted, err := store.DeleteOtherSessions(context.Background(), "42", keepID)
+	if err != nil {
+		t.Fatalf("Error deleting other sessions: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted sessions; Got %d", deleted)
+	}
+	if isNew(keepCookie) {
+		t.Error("Expected kept session to survive")
+	}
+	if !isNew(otherCookie1) || !isNew(otherCookie2) {
+		t.Error("Expected other sessions of the user to be deleted")
+	}
+	if isNew(strangerCookie) {
+		t.Error("Expected sessions of other users to survive")
+	}
+}
@@ -0,0 +1,107 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// saveWithRetryAttempts is the number of times SaveWithRetry saves a session
+// before giving up with ErrConcurrentModification.
+const saveWithRetryAttempts = 3
+
+// SaveWithRetry saves session like Save. With OptimisticLocking, when
+// another request saved the session since it was loaded, it loads the
+// session as now stored, calls merge to combine the stored values into
+// session, and saves again. It gives up after saveWithRetryAttempts saves,
+// returning ErrConcurrentModification, and returns ErrSessionNotFound when
+// the session was deleted in the meantime.
+func (mstore *MongoDBStore) SaveWithRetry(r *http.Request, w http.ResponseWriter, session *sessions.Session, merge func(stored, session *sessions.Session)) error {
+	err := mstore.Save(r, w, session)
+	for attempt := 1; attempt < saveWithRetryAttempts && errors.Is(err, ErrConcurrentModification); attempt++ {
+		var stored *sessions.Session
+		if stored, err = mstore.reload(r, session); err != nil {
+			return err
+		}
+		merge(stored, session)
+		err = mstore.Save(r, w, session)
+	}
+	return err
+}
+
+// reload loads the session stored behind session into a new session, and
+// records its version for the next Save of session.
+func (mstore *MongoDBStore) reload(r *http.Request, session *sessions.Session) (*sessions.Session, error) {
+	ctx, cancel := mstore.operationContext(r.Context())
+	defer cancel()
+	ctx, err := mstore.routeRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	stored := sessions.NewSession(mstore, session.Name())
+	options := *session.Options
+	stored.Options = &options
+	stored.ID = session.ID
+	// The cache may hold the version this request already lost to.
+	mstore.cache.invalidate(mstore.cacheKey(ctx, session.ID))
+	sessDoc, err := mstore.loadDoc(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	if sessDoc == nil {
+		return nil, ErrSessionNotFound
+	}
+	setLoadedVersion(r, session, sessDoc.Version)
+
+	return stored, nil
+}
+
+// setLoadedVersion records the document version session had when it was
+// loaded or last saved during request r.
+func setLoadedVersion(r *http.Request, session *sessions.Session, version int64) {
+	state := getRequestState(r, true)
+	state.mu.Lock()
+	state.versions[session] = version
+	state.mu.Unlock()
+}
+
+// loadedVersion returns the version recorded by setLoadedVersion.
+func loadedVersion(r *http.Request, session *sessions.Session) (int64, bool) {
+	state := getRequestState(r, false)
+	if state == nil {
+		return 0, false
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	version, ok := state.versions[session]
+
+	return version, ok
+}
+
+// withVersion restricts filter to the document at version. Documents
+// written before OptimisticLocking was enabled have no version.
+func withVersion(filter bson.M, version int64) bson.M {
+	versioned := bson.M{"version": version}
+	if version == 0 {
+		versioned["version"] = bson.M{"$exists": false}
+	}
+	for key, val := range filter {
+		versioned[key] = val
+	}
+	return versioned
+}
+
+// conflictOrNotFound tells why a versioned update matched nothing.
+func (mstore *MongoDBStore) conflictOrNotFound(ctx context.Context, filter bson.M) error {
+	count, err := mstore.ops.countDocuments(ctx, mstore.collection(ctx), filter)
+	if err != nil {
+		return &StorageError{"error saving session", err}
+	}
+	if count > 0 {
+		return ErrConcurrentModification
+	}
+	return ErrSessionNotFound
+}
@@ -0,0 +1,156 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestOptimisticLocking(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.OptimisticLocking = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	if version := readTestDoc(t, coll).Version; version != 1 {
+		t.Errorf("Expected a new session to be inserted with version 1; Got %d", version)
+	}
+	cookie := resp.Result().Cookies()[0]
+	load := func() (*http.Request, *sessions.Session) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.AddCookie(cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil || session.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return req, session
+	}
+
+	// Two requests load the same session before either saves it.
+	firstReq, first := load()
+	secondReq, second := load()
+	first.Values["cart"] = "book"
+	second.Values["theme"] = "dark"
+	if err = store.Save(firstReq, httptest.NewRecorder(), first); err != nil {
+		t.Fatalf("Error saving first session: %v", err)
+	}
+	if err = store.Save(secondReq, httptest.NewRecorder(), second); !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("Expected ErrConcurrentModification for the stale session; Got %v", err)
+	}
+
+	// The first update survives and saving it again keeps working.
+	first.Values["cart"] = "pen"
+	if err = store.Save(firstReq, httptest.NewRecorder(), first); err != nil {
+		t.Errorf("Expected the up to date session to save again; Got %v", err)
+	}
+	_, reloaded := load()
+	if reloaded.Values["cart"] != "pen" || reloaded.Values["theme"] != nil {
+		t.Errorf("Expected only the first request's changes; Got %v", reloaded.Values)
+	}
+	if version := readTestDoc(t, coll).Version; version != 3 {
+		t.Errorf("Expected version 3 after two updates; Got %d", version)
+	}
+}
+
+func TestOptimisticLockingDeleted(t *testing.T) {
+	coll := newTestCollection(t)
+	cfg := defaultConfig
+	cfg.OptimisticLocking = true
+	store, err := NewMongoDBStoreWithConfig(coll, cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", resp.Header().Get("Set-Cookie"))
+	session, _ = store.New(req, "session-key")
+	if err = store.DeleteByID(req.Context(), session.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	// A deleted session is not a conflict.
+	if err = store.Save(req, httptest.NewRecorder(), session); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound; Got %v", err)
+	}
+}
+
+func TestSaveWithRetry(t *testing.T) {
+	cfg := defaultConfig
+	cfg.OptimisticLocking = true
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	resp := httptest.NewRecorder()
+	session, _ := store.New(req, "session-key")
+	if err = store.Save(req, resp, session); err != nil {
+		t.Fatalf("Error saving session: %v", err)
+	}
+	cookie := resp.Result().Cookies()[0]
+	load := func() (*http.Request, *sessions.Session) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		req.AddCookie(cookie)
+		session, err := store.New(req, "session-key")
+		if err != nil || session.IsNew {
+			t.Fatalf("Error loading session: %v", err)
+		}
+		return req, session
+	}
+	// merge keeps the stored values the session did not set.
+	merges := 0
+	merge := func(stored, session *sessions.Session) {
+		merges++
+		for key, val := range stored.Values {
+			if _, ok := session.Values[key]; !ok {
+				session.Values[key] = val
+			}
+		}
+	}
+
+	// Two requests modify different keys of the same session.
+	firstReq, first := load()
+	secondReq, second := load()
+	first.Values["cart"] = "book"
+	second.Values["theme"] = "dark"
+	if err = store.SaveWithRetry(firstReq, httptest.NewRecorder(), first, merge); err != nil {
+		t.Fatalf("Error saving first session: %v", err)
+	}
+	if err = store.SaveWithRetry(secondReq, httptest.NewRecorder(), second, merge); err != nil {
+		t.Fatalf("Error saving second session: %v", err)
+	}
+	if merges != 1 {
+		t.Errorf("Expected a single merge; Got %d", merges)
+	}
+	_, reloaded := load()
+	if reloaded.Values["cart"] != "book" || reloaded.Values["theme"] != "dark" {
+		t.Errorf("Expected the changes of both requests; Got %v", reloaded.Values)
+	}
+
+	// A session deleted in the meantime is not brought back.
+	thirdReq, third := load()
+	if err = store.DeleteByID(context.Background(), third.ID); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if err = store.SaveWithRetry(thirdReq, httptest.NewRecorder(), third, merge); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for a deleted session; Got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrChangeStreamsUnsupported is returned by WatchInvalidations when the
+// server does not support change streams, e.g. a standalone mongod.
+var ErrChangeStreamsUnsupported = errors.New("mongodbstore: change streams are not supported by the server")
+
+// watchRetryDelay is the pause before WatchInvalidations reopens a failed
+// change stream.
+var watchRetryDelay = time.Second
+
+// WatchInvalidations watches the collection for updated, replaced and
+// deleted session documents, drops them from the cache and calls
+// OnInvalidate, so that revoking or changing a session takes effect on every
+// instance at once. It blocks until ctx is cancelled, and is typically run
+// in its own goroutine. Saves of this instance invalidate its own cache too,
+// costing a FindOne on the next load.
+//
+// After a transient error the change stream is reopened where it left off.
+// On servers without change streams it logs a warning and returns
+// ErrChangeStreamsUnsupported.
+func (mstore *MongoDBStore) WatchInvalidations(ctx context.Context) error {
+	return mstore.watchInvalidations(ctx, nil)
+}
+
+// watchInvalidations runs WatchInvalidations, reading stream first when it
+// is not nil.
+func (mstore *MongoDBStore) watchInvalidations(ctx context.Context, stream *mongo.ChangeStream) error {
+	var resumeToken bson.Raw
+	for {
+		var err error
+		if stream == nil {
+			stream, err = mstore.openChangeStream(ctx, resumeToken)
+		}
+		if err == nil {
+			for stream.Next(ctx) {
+				mstore.handleChange(stream.Current)
+				resumeToken = stream.ResumeToken()
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+			stream = nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if isChangeStreamUnsupported(err) {
+			mstore.logger.Warn("mongodbstore: change streams are not supported, sessions are not invalidated across instances", "op", "watch", "error", err)
+			return ErrChangeStreamsUnsupported
+		}
+		mstore.logger.Warn("mongodbstore: error watching sessions, retrying", "op", "watch", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// openChangeStream watches the collection for changed and deleted session
+// documents, after resumeToken when it is not nil.
+func (mstore *MongoDBStore) openChangeStream(ctx context.Context, resumeToken bson.Raw) (*mongo.ChangeStream, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": bson.A{"delete", "replace", "update"}},
+	}}}}
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+	return mstore.coll.Watch(ctx, pipeline, opts)
+}
+
+// handleChange invalidates the session of the change event.
+func (mstore *MongoDBStore) handleChange(event bson.Raw) {
+	var change struct {
+		DocumentKey struct {
+			ID interface{} `bson:"_id"`
+		} `bson:"documentKey"`
+	}
+	if err := bson.Unmarshal(event, &change); err != nil || change.DocumentKey.ID == nil {
+		mstore.logger.Warn("mongodbstore: change event without a session ID", "op", "watch", "error", err)
+		return
+	}
+	mstore.invalidate(idString(change.DocumentKey.ID))
+}
+
+// watcher is a WatchInvalidations run by StartWatch.
+type watcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartWatch runs WatchInvalidations in the background until ctx is
+// cancelled or StopWatch is called. Unlike WatchInvalidations, it opens the
+// change stream before returning, so that it returns
+// ErrChangeStreamsUnsupported right away on servers without change streams.
+func (mstore *MongoDBStore) StartWatch(ctx context.Context) error {
+	mstore.watchMu.Lock()
+	defer mstore.watchMu.Unlock()
+	if w := mstore.watch; w != nil {
+		select {
+		case <-w.done:
+		default:
+			return errors.New("mongodbstore: already watching")
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := mstore.openChangeStream(ctx, nil)
+	if err != nil {
+		cancel()
+		if isChangeStreamUnsupported(err) {
+			return fmt.Errorf("%w: %v", ErrChangeStreamsUnsupported, err)
+		}
+		return fmt.Errorf("mongodbstore: error watching sessions: %w", err)
+	}
+	w := &watcher{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		mstore.watchInvalidations(ctx, stream)
+	}()
+	mstore.watch = w
+
+	return nil
+}
+
+// StopWatch stops the watch started by StartWatch and waits for it to
+// return. It does nothing when the store is not watching.
+func (mstore *MongoDBStore) StopWatch() {
+	mstore.watchMu.Lock()
+	w := mstore.watch
+	mstore.watch = nil
+	mstore.watchMu.Unlock()
+	if w != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// invalidate drops the session id from the cache and reports it to
+// OnInvalidate.
+func (mstore *MongoDBStore) invalidate(id string) {
+	mstore.cache.invalidate(id)
+	if mstore.onInvalidate != nil {
+		mstore.onInvalidate(id)
+	}
+}
+
+// isChangeStreamUnsupported reports whether err says that the server has no
+// change streams.
+func isChangeStreamUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	switch cmdErr.Code {
+	case 40573, // only supported on replica sets
+		40324, // unrecognized pipeline stage
+		115,   // CommandNotSupported
+		238:   // NotImplemented
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package mongodbstoregorilla
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWatchInvalidationsUnsupported(t *testing.T) {
+	logger := &recordingLogger{}
+	cfg := defaultConfig
+	cfg.Logger = logger
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = store.WatchInvalidations(ctx)
+	if ctx.Err() != nil {
+		// The server supports change streams; cancellation stops the watcher.
+		if err != nil {
+			t.Errorf("Expected no error after cancellation; Got %v", err)
+		}
+		return
+	}
+	if !errors.Is(err, ErrChangeStreamsUnsupported) {
+		t.Fatalf("Expected ErrChangeStreamsUnsupported; Got %v", err)
+	}
+	if logger.find("warn", "watch") == nil {
+		t.Error("Expected a warning")
+	}
+}
+
+func TestWatchInvalidationsCancel(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = store.WatchInvalidations(ctx); err != nil {
+		t.Errorf("Expected no error for a cancelled context; Got %v", err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	var invalidated []string
+	cfg := defaultConfig
+	cfg.Cache = CacheConfig{Enabled: true}
+	cfg.OnInvalidate = func(sessionID string) { invalidated = append(invalidated, sessionID) }
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	store.cache.put("revoked", &sessionDoc{})
+	store.invalidate("revoked")
+	if store.cache.get("revoked", "") != nil {
+		t.Error("Expected the session to be dropped from the cache")
+	}
+	if len(invalidated) != 1 || invalidated[0] != "revoked" {
+		t.Errorf("Expected OnInvalidate(\"revoked\"); Got %v", invalidated)
+	}
+}
+
+func TestIsChangeStreamUnsupported(t *testing.T) {
+	if !isChangeStreamUnsupported(mongo.CommandError{Code: 40573, Name: "Location40573"}) {
+		t.Error("Expected a standalone server error to mean unsupported")
+	}
+	if isChangeStreamUnsupported(mongo.CommandError{Code: 280, Name: "ChangeStreamFatalError"}) || isChangeStreamUnsupported(nil) {
+		t.Error("Expected other errors not to mean unsupported")
+	}
+}
+
+func TestStartWatch(t *testing.T) {
+	store, err := NewMongoDBStore(newTestCollection(t), []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	// StopWatch without a watch does nothing.
+	store.StopWatch()
+
+	err = store.StartWatch(context.Background())
+	if errors.Is(err, ErrChangeStreamsUnsupported) {
+		if store.watch != nil {
+			t.Error("Expected no watch to be running")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error starting watch: %v", err)
+	}
+	if err = store.StartWatch(context.Background()); err == nil {
+		t.Error("Expected an error starting a second watch")
+	}
+	store.StopWatch()
+	if err = store.StartWatch(context.Background()); err != nil {
+		t.Errorf("Expected a watch to start after StopWatch; Got %v", err)
+	}
+	store.StopWatch()
+}
+
+func TestHandleChange(t *testing.T) {
+	var invalidated []string
+	cfg := defaultConfig
+	cfg.Cache = CacheConfig{Enabled: true}
+	cfg.OnInvalidate = func(sessionID string) { invalidated = append(invalidated, sessionID) }
+	store, err := NewMongoDBStoreWithConfig(newTestCollection(t), cfg, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Error initializing mongodb store: %v", err)
+	}
+	ID := primitive.NewObjectID()
+	store.cache.put(ID.Hex(), &sessionDoc{})
+
+	event, _ := bson.Marshal(bson.M{"operationType": "update", "documentKey": bson.M{"_id": ID}})
+	store.handleChange(event)
+	if store.cache.get(ID.Hex(), "") != nil {
+		t.Error("Expected an updated session to be dropped from the cache")
+	}
+	event, _ = bson.Marshal(bson.M{"operationType": "delete"})
+	store.handleChange(event)
+	if len(invalidated) != 1 || invalidated[0] != ID.Hex() {
+		t.Errorf("Expected OnInvalidate(%q) only; Got %v", ID.Hex(), invalidated)
+	}
+}